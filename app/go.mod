@@ -18,6 +18,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/milvus-io/milvus-sdk-go/v2 v2.4.2
 	github.com/mszlu521/thunder v1.0.3
+	github.com/spf13/viper v1.19.0
 	golang.org/x/crypto v0.45.0
 	golang.org/x/net v0.47.0
 	gorm.io/gorm v1.31.1
@@ -155,7 +156,6 @@ require (
 	github.com/spf13/afero v1.11.0 // indirect
 	github.com/spf13/cast v1.7.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	github.com/spf13/viper v1.19.0 // indirect
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
@@ -237,6 +237,136 @@ func (h *Handler) DeleteAgentKnowledgeBase(c *gin.Context) {
 	res.Success(c, resp)
 }
 
+func (h *Handler) AddAgentSubAgent(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var addReq addAgentSubAgentReq
+	if err := req.JsonParam(c, &addReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.addAgentSubAgent(c.Request.Context(), userID, agentId, addReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) DeleteAgentSubAgent(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var subAgentId uuid.UUID
+	if err := req.Path(c, "subAgentId", &subAgentId); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.deleteAgentSubAgent(c.Request.Context(), userID, agentId, subAgentId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) ListConversations(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var listReq listConversationReq
+	if err := req.QueryParam(c, &listReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.listConversations(c.Request.Context(), userID, agentId, listReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) GetConversation(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var conversationId uuid.UUID
+	if err := req.Path(c, "conversationId", &conversationId); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.getConversation(c.Request.Context(), userID, agentId, conversationId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) UpdateConversation(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var conversationId uuid.UUID
+	if err := req.Path(c, "conversationId", &conversationId); err != nil {
+		return
+	}
+	var updateReq updateConversationReq
+	if err := req.JsonParam(c, &updateReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.updateConversation(c.Request.Context(), userID, agentId, conversationId, updateReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) DeleteConversation(c *gin.Context) {
+	var agentId uuid.UUID
+	if err := req.Path(c, "id", &agentId); err != nil {
+		return
+	}
+	var conversationId uuid.UUID
+	if err := req.Path(c, "conversationId", &conversationId); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	err := h.service.deleteConversation(c.Request.Context(), userID, agentId, conversationId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, nil)
+}
+
 func NewHandler() *Handler {
 	return &Handler{
 		service: newService(),
@@ -3,6 +3,7 @@ package agents
 import (
 	"context"
 	"model"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mszlu521/thunder/gorms"
@@ -30,6 +31,29 @@ func (m *models) createAgentKnowledgeBase(ctx context.Context, ab *model.AgentKn
 	return m.db.WithContext(ctx).Create(ab).Error
 }
 
+func (m *models) isAgentSubAgentExist(ctx context.Context, agentId uuid.UUID, subAgentId uuid.UUID) (bool, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&model.AgentSubAgent{}).Where("agent_id = ? and sub_agent_id = ?", agentId, subAgentId).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (m *models) createAgentSubAgent(ctx context.Context, as *model.AgentSubAgent) error {
+	return m.db.WithContext(ctx).Create(as).Error
+}
+
+func (m *models) deleteAgentSubAgent(ctx context.Context, agentId uuid.UUID, subAgentId uuid.UUID) error {
+	return m.db.WithContext(ctx).Where("agent_id = ? and sub_agent_id = ?", agentId, subAgentId).Delete(&model.AgentSubAgent{}).Error
+}
+
+func (m *models) listSubAgentIds(ctx context.Context, agentId uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := m.db.WithContext(ctx).Model(&model.AgentSubAgent{}).Where("agent_id = ?", agentId).Pluck("sub_agent_id", &ids).Error
+	return ids, err
+}
+
 func (m *models) deleteAgentTools(ctx context.Context, agentId uuid.UUID) error {
 	return m.db.WithContext(ctx).Where("agent_id = ?", agentId).Delete(&model.AgentTool{}).Error
 }
@@ -47,6 +71,7 @@ func (m *models) getAgent(ctx context.Context, userID uuid.UUID, id uuid.UUID) (
 	err := m.db.WithContext(ctx).
 		Preload("Tools").
 		Preload("KnowledgeBases").
+		Preload("SubAgents").
 		Where("id = ? and creator_id = ? ", id, userID).First(&agent).Error
 	if gorms.IsRecordNotFoundError(err) {
 		return nil, nil
@@ -81,6 +106,105 @@ func (m *models) createAgent(ctx context.Context, agent *model.Agent) error {
 	return m.db.WithContext(ctx).Create(agent).Error
 }
 
+func (m *models) transaction(ctx context.Context, f func(tx *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Transaction(f)
+}
+
+func (m *models) createConversation(ctx context.Context, conversation *model.Conversation) error {
+	return m.db.WithContext(ctx).Create(conversation).Error
+}
+
+func (m *models) getConversation(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, id uuid.UUID) (*model.Conversation, error) {
+	var conversation model.Conversation
+	err := m.db.WithContext(ctx).
+		Where("id = ? and agent_id = ? and creator_id = ?", id, agentId, userID).
+		First(&conversation).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &conversation, err
+}
+
+func (m *models) listConversations(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, filter ConversationFilter) ([]*model.Conversation, int64, error) {
+	var conversations []*model.Conversation
+	var count int64
+	query := m.db.WithContext(ctx).Model(&model.Conversation{})
+	query = query.Where("agent_id = ? and creator_id = ?", agentId, userID)
+	if filter.Title != "" {
+		query = query.Where("title like ?", "%"+filter.Title+"%")
+	}
+	query = query.Count(&count)
+	//最近有消息的会话排在前面
+	query = query.Order("last_message_at desc nulls last").Limit(filter.Limit).Offset(filter.Offset)
+	return conversations, count, query.Find(&conversations).Error
+}
+
+type ConversationFilter struct {
+	Title  string
+	Limit  int
+	Offset int
+}
+
+func (m *models) updateConversation(ctx context.Context, conversation *model.Conversation) error {
+	return m.db.WithContext(ctx).Updates(conversation).Error
+}
+
+func (m *models) deleteConversation(ctx context.Context, tx *gorm.DB, id uuid.UUID) error {
+	if tx == nil {
+		tx = m.db
+	}
+	return tx.WithContext(ctx).Where("id = ?", id).Delete(&model.Conversation{}).Error
+}
+
+func (m *models) createConversationMessage(ctx context.Context, message *model.ConversationMessage) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(message).Error
+		if err != nil {
+			return err
+		}
+		//同步更新会话的消息数和最后消息时间
+		return tx.Model(&model.Conversation{}).Where("id = ?", message.ConversationID).Updates(map[string]any{
+			"message_count":   gorm.Expr("message_count + 1"),
+			"last_message_at": message.CreatedAt,
+		}).Error
+	})
+}
+
+func (m *models) listConversationMessages(ctx context.Context, conversationId uuid.UUID, after *time.Time, limit int) ([]*model.ConversationMessage, error) {
+	var messages []*model.ConversationMessage
+	query := m.db.WithContext(ctx).Where("conversation_id = ?", conversationId)
+	if after != nil {
+		query = query.Where("created_at > ?", *after)
+	}
+	query = query.Order("created_at desc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	//查询是倒序的，这里翻转成时间正序
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (m *models) updateConversationSummary(ctx context.Context, id uuid.UUID, summary string, summarizedAt time.Time) error {
+	return m.db.WithContext(ctx).Model(&model.Conversation{}).Where("id = ?", id).Updates(map[string]any{
+		"summary":       summary,
+		"summarized_at": summarizedAt,
+	}).Error
+}
+
+func (m *models) deleteConversationMessages(ctx context.Context, tx *gorm.DB, conversationId uuid.UUID) error {
+	if tx == nil {
+		tx = m.db
+	}
+	return tx.WithContext(ctx).Where("conversation_id = ?", conversationId).Unscoped().Delete(&model.ConversationMessage{}).Error
+}
+
 func newModels(db *gorm.DB) *models {
 	return &models{
 		db: db,
@@ -0,0 +1,34 @@
+package agents
+
+import (
+	"app/shared"
+	"context"
+
+	"github.com/mszlu521/thunder/event"
+)
+
+type PublicService struct {
+	service *service
+}
+
+// RunAgent 不保存会话，执行一次智能体并返回最终的回答，供工作流等模块调用
+func (s *PublicService) RunAgent(e event.Event) (any, error) {
+	request := e.Data.(*shared.RunAgentRequest)
+	ctx := context.Background()
+	if request.Ctx != nil {
+		ctx = request.Ctx
+	}
+	content, err := s.service.runAgent(ctx, request.UserId, request.AgentId, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &shared.RunAgentResponse{
+		Content: content,
+	}, nil
+}
+
+func NewPublicService() *PublicService {
+	return &PublicService{
+		service: newService(),
+	}
+}
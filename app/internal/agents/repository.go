@@ -3,8 +3,10 @@ package agents
 import (
 	"context"
 	"model"
+	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type repository interface {
@@ -17,4 +19,18 @@ type repository interface {
 	isAgentKnowledgeBaseExist(ctx context.Context, agentId uuid.UUID, knowledgeBaseID uuid.UUID) (bool, error)
 	createAgentKnowledgeBase(ctx context.Context, ab *model.AgentKnowledgeBase) error
 	deleteAgentKnowledgeBase(ctx context.Context, agentId uuid.UUID, kbId uuid.UUID) error
+	isAgentSubAgentExist(ctx context.Context, agentId uuid.UUID, subAgentId uuid.UUID) (bool, error)
+	createAgentSubAgent(ctx context.Context, as *model.AgentSubAgent) error
+	deleteAgentSubAgent(ctx context.Context, agentId uuid.UUID, subAgentId uuid.UUID) error
+	listSubAgentIds(ctx context.Context, agentId uuid.UUID) ([]uuid.UUID, error)
+	transaction(ctx context.Context, f func(tx *gorm.DB) error) error
+	createConversation(ctx context.Context, conversation *model.Conversation) error
+	getConversation(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, id uuid.UUID) (*model.Conversation, error)
+	listConversations(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, filter ConversationFilter) ([]*model.Conversation, int64, error)
+	updateConversation(ctx context.Context, conversation *model.Conversation) error
+	deleteConversation(ctx context.Context, tx *gorm.DB, id uuid.UUID) error
+	createConversationMessage(ctx context.Context, message *model.ConversationMessage) error
+	listConversationMessages(ctx context.Context, conversationId uuid.UUID, after *time.Time, limit int) ([]*model.ConversationMessage, error)
+	updateConversationSummary(ctx context.Context, id uuid.UUID, summary string, summarizedAt time.Time) error
+	deleteConversationMessages(ctx context.Context, tx *gorm.DB, conversationId uuid.UUID) error
 }
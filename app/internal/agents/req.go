@@ -47,6 +47,20 @@ type ToolItem struct {
 	Type string    `json:"type"`
 }
 
+type addAgentSubAgentReq struct {
+	SubAgentID uuid.UUID `json:"subAgentId"`
+}
+
 type addAgentKnowledgeBaseReq struct {
 	KnowledgeBaseID uuid.UUID `json:"kb_id"`
 }
+
+type listConversationReq struct {
+	Title    string `json:"title" form:"title"`
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+}
+
+type updateConversationReq struct {
+	Title string `json:"title"`
+}
@@ -6,3 +6,8 @@ type ListAgentResponse struct {
 	Agents []*model.Agent `json:"agents"`
 	Total  int64          `json:"total"`
 }
+
+type ListConversationResponse struct {
+	Conversations []*model.Conversation `json:"conversations"`
+	Total         int64                 `json:"total"`
+}
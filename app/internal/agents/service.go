@@ -3,20 +3,21 @@ package agents
 import (
 	"app/shared"
 	"common/biz"
+	"common/utils"
 	"context"
 	"core/ai"
+	"core/ai/kbs"
 	"core/ai/mcps"
+	"core/ai/memory"
 	"core/ai/tools"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"model"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/cloudwego/eino-ext/components/model/ollama"
-	"github.com/cloudwego/eino-ext/components/model/openai"
-	"github.com/cloudwego/eino-ext/components/model/qwen"
 	"github.com/cloudwego/eino/adk"
 	"github.com/cloudwego/eino/adk/prebuilt/supervisor"
 	aiModel "github.com/cloudwego/eino/components/model"
@@ -24,13 +25,13 @@ import (
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/schema"
-	"github.com/eino-contrib/ollama/api"
 	"github.com/google/uuid"
 	"github.com/mszlu521/thunder/ai/einos"
 	"github.com/mszlu521/thunder/database"
 	"github.com/mszlu521/thunder/errs"
 	"github.com/mszlu521/thunder/event"
 	"github.com/mszlu521/thunder/logs"
+	"gorm.io/gorm"
 )
 
 type service struct {
@@ -154,21 +155,29 @@ func (s *service) agentMessage(ctx context.Context, userID uuid.UUID, req AgentM
 			s.sendError(ctx, errChan, err)
 			return
 		}
-		//我们用eino框架的adk来进行agent开发，所以这里我们需要构建一个主agent
-		//因为我们的智能体能添加子智能体，一起协同工作
-		mainAgent, err := s.buildMainAgent(ctx, agent, req.Message, dataChan)
+		if agent == nil {
+			s.sendError(ctx, errChan, biz.AgentNotFound)
+			return
+		}
+		//加载会话，没有sessionId的时候新建一个会话，有的话把之前的对话记录查出来作为上下文
+		conversation, stored, err := s.loadConversation(ctx, userID, agent, req)
 		if err != nil {
-			logs.Errorf("构建主智能体失败: %v", err)
+			logs.Errorf("加载会话失败: %v", err)
 			s.sendError(ctx, errChan, err)
 			return
 		}
-		//构建supervisoragent
-		supervisorAgent, err := supervisor.New(ctx, &supervisor.Config{
-			Supervisor: mainAgent,
-			SubAgents:  []adk.Agent{
-				//这里可以添加多个子智能体
-			},
+		//历史消息超过token预算时，将较早的对话压缩成摘要
+		history := s.compactHistory(ctx, agent, conversation, stored)
+		//告诉前端当前的会话ID
+		s.sendData(ctx, dataChan, ai.BuildConversationMessage(agent.Name, conversation.ID.String()))
+		//先把用户的消息存起来
+		s.saveConversationMessage(conversation.ID, &model.ConversationMessage{
+			Role:    schema.User,
+			Content: req.Message,
 		})
+		//我们用eino框架的adk来进行agent开发，所以这里我们需要构建一个主agent
+		//因为我们的智能体能添加子智能体，一起协同工作，主agent作为supervisor，关联的子智能体注册为sub agent
+		supervisorAgent, err := s.buildAgentTree(ctx, agent, newRagQuery(req.Message, history), dataChan, newAgentTree(), 0)
 		if err != nil {
 			logs.Errorf("构建supervisorAgent失败: %v", err)
 			s.sendError(ctx, errChan, err)
@@ -179,7 +188,9 @@ func (s *service) agentMessage(ctx context.Context, userID uuid.UUID, req AgentM
 			Agent:           supervisorAgent,
 			EnableStreaming: true,
 		})
-		iter := runner.Query(ctx, req.Message)
+		//历史消息加上本次用户的消息一起发给大模型
+		messages := append(history, schema.UserMessage(req.Message))
+		iter := runner.Run(ctx, messages)
 		for {
 			//处理大模型返回的数据
 			events, ok := iter.Next()
@@ -209,6 +220,8 @@ func (s *service) agentMessage(ctx context.Context, userID uuid.UUID, req AgentM
 					s.sendError(ctx, errChan, err)
 					return
 				}
+				//模型回答、工具调用以及工具结果都要存入会话
+				s.saveConversationMessage(conversation.ID, s.toConversationMessage(events.AgentName, msg))
 				if msg.Content == "" && msg.ReasoningContent == "" {
 					continue
 				}
@@ -226,6 +239,56 @@ func (s *service) agentMessage(ctx context.Context, userID uuid.UUID, req AgentM
 	return dataChan, errChan
 }
 
+// runAgent 执行一次智能体，不保存会话也不推送中间过程，只返回最后的回答
+func (s *service) runAgent(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, message string) (string, error) {
+	agent, err := s.repo.getAgent(ctx, userID, agentId)
+	if err != nil {
+		logs.Errorf("查询智能代理失败: %v", err)
+		return "", errs.DBError
+	}
+	if agent == nil {
+		return "", biz.AgentNotFound
+	}
+	//构建智能体时会往dataChan推送知识库的内容，这里不需要，直接丢弃
+	dataChan := make(chan string)
+	defer close(dataChan)
+	go func() {
+		for range dataChan {
+		}
+	}()
+	supervisorAgent, err := s.buildAgentTree(ctx, agent, newRagQuery(message, nil), dataChan, newAgentTree(), 0)
+	if err != nil {
+		logs.Errorf("构建supervisorAgent失败: %v", err)
+		return "", err
+	}
+	runner := adk.NewRunner(ctx, adk.RunnerConfig{
+		Agent: supervisorAgent,
+	})
+	iter := runner.Run(ctx, []adk.Message{schema.UserMessage(message)})
+	var answer string
+	for {
+		events, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if events.Err != nil {
+			return "", events.Err
+		}
+		if events.Output == nil || events.Output.MessageOutput == nil {
+			continue
+		}
+		msg, err := events.Output.MessageOutput.GetMessage()
+		if err != nil {
+			return "", err
+		}
+		//最后一条模型的回答就是智能体的最终结果
+		if msg.Role == schema.Assistant && msg.Content != "" {
+			answer = msg.Content
+		}
+	}
+	return answer, nil
+}
+
 func (s *service) sendError(ctx context.Context, errChan chan error, err error) {
 	select {
 	case errChan <- err:
@@ -234,27 +297,101 @@ func (s *service) sendError(ctx context.Context, errChan chan error, err error)
 	}
 }
 
-func (s *service) buildMainAgent(ctx context.Context, agent *model.Agent, message string, dataChan chan string) (adk.Agent, error) {
-	//构建主智能体
-	//首先需要获取到agent的模型配置信息
-	providerConfig, err := s.getProviderConfig(ctx, model.LLMTypeChat, agent.ModelProvider, agent.ModelName)
+const maxSubAgentDepth = 3 //子智能体最大的嵌套层级
+
+// agentTree 记录构建智能体树时的状态，用于检测循环引用和重名
+type agentTree struct {
+	path  map[uuid.UUID]bool //当前构建路径上的智能体
+	names map[string]bool    //已经注册的智能体名称，supervisor根据名称进行转交，所以名称不能重复
+}
+
+func newAgentTree() *agentTree {
+	return &agentTree{
+		path:  make(map[uuid.UUID]bool),
+		names: make(map[string]bool),
+	}
+}
+
+// buildAgentTree 构建智能体以及它关联的子智能体，子智能体也可以有自己的子智能体
+func (s *service) buildAgentTree(ctx context.Context, agent *model.Agent, query *ragQuery, dataChan chan string, tree *agentTree, depth int) (adk.Agent, error) {
+	tree.path[agent.ID] = true
+	tree.names[agent.Name] = true
+	defer delete(tree.path, agent.ID)
+	var subAgents []adk.Agent
+	var subAgentInfos []*model.Agent
+	for _, v := range agent.SubAgents {
+		if depth+1 > maxSubAgentDepth {
+			logs.Warnf("子智能体层级超过%d层，忽略: %s", maxSubAgentDepth, v.Name)
+			break
+		}
+		if tree.path[v.ID] {
+			logs.Warnf("子智能体存在循环引用，忽略: %s -> %s", agent.Name, v.Name)
+			continue
+		}
+		if tree.names[v.Name] {
+			logs.Warnf("子智能体名称重复，忽略: %s", v.Name)
+			continue
+		}
+		//关联查询出来的子智能体没有工具、知识库等信息，需要重新查询
+		subAgent, err := s.repo.getAgent(ctx, agent.CreatorID, v.ID)
+		if err != nil {
+			logs.Errorf("查询子智能体失败: %v", err)
+			continue
+		}
+		if subAgent == nil {
+			continue
+		}
+		built, err := s.buildAgentTree(ctx, subAgent, query, dataChan, tree, depth+1)
+		if err != nil {
+			logs.Errorf("构建子智能体失败: %v", err)
+			continue
+		}
+		subAgents = append(subAgents, built)
+		subAgentInfos = append(subAgentInfos, subAgent)
+	}
+	mainAgent, err := s.buildMainAgent(ctx, agent, query, dataChan, s.formatAgentsInfo(subAgentInfos))
 	if err != nil {
-		return nil, errs.DBError
+		logs.Errorf("构建主智能体失败: %v", err)
+		return nil, err
 	}
-	if providerConfig == nil {
-		return nil, biz.ErrProviderConfigNotFound
+	if depth > 0 && len(subAgents) == 0 {
+		return mainAgent, nil
+	}
+	return supervisor.New(ctx, &supervisor.Config{
+		Supervisor: mainAgent,
+		SubAgents:  subAgents,
+	})
+}
+
+func (s *service) formatAgentsInfo(agents []*model.Agent) string {
+	if len(agents) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("# 协作智能体\n")
+	builder.WriteString("【可转交的智能体列表】如果问题更适合由以下智能体处理，请将任务转交给对应的智能体\n")
+	for _, a := range agents {
+		builder.WriteString(fmt.Sprintf("- name: `%s` \n", a.Name))
+		builder.WriteString(fmt.Sprintf("  description: `%s` \n", a.Description))
 	}
-	//构建chatmodel，因为这里有很多厂商，所以这里要适配
-	chatModel, err := s.buildToolCallingChatModel(ctx, agent, providerConfig)
+	return builder.String()
+}
+
+func (s *service) buildMainAgent(ctx context.Context, agent *model.Agent, query *ragQuery, dataChan chan string, agentsInfo string) (adk.Agent, error) {
+	//构建主智能体
+	//首先需要获取到agent的模型配置信息，构建chatmodel，因为这里有很多厂商，所以这里要适配
+	chatModel, err := s.buildChatModel(ctx, agent)
 	if err != nil {
 		logs.Errorf("构建chatmodel失败: %v", err)
 		return nil, err
 	}
+	modelParams := agent.ModelParameters.ToModelParams()
+	memoryManager := memory.NewManager(chatModel, modelParams.ContextWindow, modelParams.MaxTokens)
 	var allTools []tool.BaseTool
 	//这里需要把关联的工具添加进去
 	allTools = append(allTools, s.buildTools(agent)...)
 	//在这里将关联的知识库内容查询出来
-	ragContext := s.buildRagContext(ctx, dataChan, message, agent)
+	ragContext := s.buildRagContext(ctx, dataChan, query, agent, chatModel)
 	modelAgent, err := adk.NewChatModelAgent(ctx, &adk.ChatModelAgentConfig{
 		Model:       chatModel,
 		Name:        agent.Name,
@@ -267,14 +404,15 @@ func (s *service) buildMainAgent(ctx context.Context, agent *model.Agent, messag
 				"role":       agent.SystemPrompt,
 				"ragContext": ragContext,
 				"toolsInfo":  s.formatToolsInfo(allTools),
-				"agentsInfo": "",
+				"agentsInfo": agentsInfo,
 			})
 			if err2 != nil {
 				logs.Errorf("格式化模板失败: %v", err2)
 				return nil, err2
 			}
 			messages = append(messages, input.Messages...)
-			return messages, nil
+			//兜底：保证发送给模型的内容不超过上下文窗口
+			return memory.Trim(messages, memoryManager.InputBudget()), nil
 		},
 		ToolsConfig: adk.ToolsConfig{
 			ToolsNodeConfig: compose.ToolsNodeConfig{
@@ -289,6 +427,17 @@ func (s *service) buildMainAgent(ctx context.Context, agent *model.Agent, messag
 	return modelAgent, nil
 }
 
+func (s *service) buildChatModel(ctx context.Context, agent *model.Agent) (aiModel.ToolCallingChatModel, error) {
+	providerConfig, err := s.getProviderConfig(ctx, model.LLMTypeChat, agent.ModelProvider, agent.ModelName)
+	if err != nil {
+		return nil, errs.DBError
+	}
+	if providerConfig == nil {
+		return nil, biz.ErrProviderConfigNotFound
+	}
+	return s.buildToolCallingChatModel(ctx, agent, providerConfig)
+}
+
 func (s *service) getProviderConfig(ctx context.Context, chat model.LLMType, provider string, name string) (*model.ProviderConfig, error) {
 	//这个需要调用llms服务 所以我们需要定义event事件
 	trigger, err := event.Trigger("getProviderConfig", &shared.GetProviderConfigsRequest{
@@ -304,55 +453,8 @@ func (s *service) getProviderConfig(ctx context.Context, chat model.LLMType, pro
 }
 
 func (s *service) buildToolCallingChatModel(ctx context.Context, agent *model.Agent, config *model.ProviderConfig) (aiModel.ToolCallingChatModel, error) {
-	var chatModel aiModel.ToolCallingChatModel
-	var err error
 	modelParams := agent.ModelParameters.ToModelParams()
-	temperature := float32(modelParams.Temperature)
-	topP := float32(modelParams.TopP)
-	maxTokens := modelParams.MaxTokens
-	if config.Provider == model.OllamaProvider {
-		chatModel, err = ollama.NewChatModel(ctx, &ollama.ChatModelConfig{
-			Model:   agent.ModelName,
-			BaseURL: config.APIBase,
-			Options: &api.Options{
-				Temperature: temperature,
-				TopP:        topP,
-				Runner: api.Runner{
-					NumCtx: maxTokens,
-				},
-			},
-		})
-	} else if config.Provider == model.OpenAIProvider {
-		chatModel, err = openai.NewChatModel(ctx, &openai.ChatModelConfig{
-			Model:               agent.ModelName,
-			BaseURL:             config.APIBase,
-			APIKey:              config.APIKey,
-			MaxCompletionTokens: &maxTokens,
-			Temperature:         &temperature,
-			TopP:                &topP,
-		})
-	} else if config.Provider == model.QwenProvider {
-		chatModel, err = qwen.NewChatModel(ctx, &qwen.ChatModelConfig{
-			Model:       agent.ModelName,
-			BaseURL:     config.APIBase,
-			APIKey:      config.APIKey,
-			MaxTokens:   &maxTokens,
-			Temperature: &temperature,
-			TopP:        &topP,
-		})
-	} else {
-		//默认用openai，大部分厂商都支持openai的方式
-		chatModel, err = openai.NewChatModel(ctx, &openai.ChatModelConfig{
-			Model:               agent.ModelName,
-			BaseURL:             config.APIBase,
-			APIKey:              config.APIKey,
-			MaxCompletionTokens: &maxTokens,
-			Temperature:         &temperature,
-			TopP:                &topP,
-		})
-	}
-
-	return chatModel, err
+	return ai.NewChatModel(ctx, config, agent.ModelName, &modelParams)
 }
 
 func (s *service) sendData(ctx context.Context, dataChan chan string, data string) {
@@ -510,6 +612,101 @@ func (s *service) addAgentKnowledgeBase(ctx context.Context, userId uuid.UUID, a
 	return nil, nil
 }
 
+func (s *service) addAgentSubAgent(ctx context.Context, userId uuid.UUID, agentId uuid.UUID, addReq addAgentSubAgentReq) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	if agentId == addReq.SubAgentID {
+		return nil, biz.ErrSubAgentCycle
+	}
+	//智能体和子智能体都必须是当前用户的
+	agent, err := s.repo.getAgent(ctx, userId, agentId)
+	if err != nil {
+		logs.Errorf("addAgentSubAgent 获取agent失败: %v", err)
+		return nil, errs.DBError
+	}
+	if agent == nil {
+		return nil, biz.AgentNotFound
+	}
+	subAgent, err := s.repo.getAgent(ctx, userId, addReq.SubAgentID)
+	if err != nil {
+		logs.Errorf("addAgentSubAgent 获取子agent失败: %v", err)
+		return nil, errs.DBError
+	}
+	if subAgent == nil {
+		return nil, biz.AgentNotFound
+	}
+	exist, err := s.repo.isAgentSubAgentExist(ctx, agentId, addReq.SubAgentID)
+	if err != nil {
+		logs.Errorf("addAgentSubAgent 查询关联关系是否存在失败: %v", err)
+		return nil, errs.DBError
+	}
+	if exist {
+		return nil, nil
+	}
+	//检查子智能体的下级中是否包含当前智能体，同时计算子智能体的层级
+	depth, err := s.subAgentDepth(ctx, addReq.SubAgentID, agentId, 1)
+	if err != nil {
+		return nil, err
+	}
+	if depth > maxSubAgentDepth {
+		return nil, biz.ErrSubAgentDepth
+	}
+	err = s.repo.createAgentSubAgent(ctx, &model.AgentSubAgent{
+		AgentID:    agentId,
+		SubAgentID: addReq.SubAgentID,
+		Status:     model.Enabled,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		logs.Errorf("addAgentSubAgent 创建关联关系失败: %v", err)
+		return nil, errs.DBError
+	}
+	return nil, nil
+}
+
+// subAgentDepth 返回以agentId为根的子智能体树的层级，如果树中出现了rootId说明会形成循环
+func (s *service) subAgentDepth(ctx context.Context, agentId uuid.UUID, rootId uuid.UUID, depth int) (int, error) {
+	if depth > maxSubAgentDepth {
+		return depth, nil
+	}
+	ids, err := s.repo.listSubAgentIds(ctx, agentId)
+	if err != nil {
+		logs.Errorf("查询子智能体失败: %v", err)
+		return 0, errs.DBError
+	}
+	maxDepth := depth
+	for _, id := range ids {
+		if id == rootId {
+			return 0, biz.ErrSubAgentCycle
+		}
+		d, err := s.subAgentDepth(ctx, id, rootId, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		maxDepth = max(maxDepth, d)
+	}
+	return maxDepth, nil
+}
+
+func (s *service) deleteAgentSubAgent(ctx context.Context, userId uuid.UUID, agentId uuid.UUID, subAgentId uuid.UUID) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	agent, err := s.repo.getAgent(ctx, userId, agentId)
+	if err != nil {
+		logs.Errorf("deleteAgentSubAgent 获取agent失败: %v", err)
+		return nil, errs.DBError
+	}
+	if agent == nil {
+		return nil, biz.AgentNotFound
+	}
+	err = s.repo.deleteAgentSubAgent(ctx, agentId, subAgentId)
+	if err != nil {
+		logs.Errorf("deleteAgentSubAgent 删除关联关系失败: %v", err)
+		return nil, errs.DBError
+	}
+	return nil, nil
+}
+
 func (s *service) getKnowledgeBase(ctx context.Context, userId uuid.UUID, kbId uuid.UUID) (*model.KnowledgeBase, error) {
 	trigger, err := event.Trigger("getKnowledgeBase", &shared.GetKnowledgeBaseRequest{
 		UserId:          userId,
@@ -529,9 +726,48 @@ func (s *service) deleteAgentKnowledgeBase(ctx context.Context, userID uuid.UUID
 	return nil, nil
 }
 
-func (s *service) buildRagContext(ctx context.Context, dataChan chan string, message string, agent *model.Agent) string {
+// ragQuery 本次对话用于检索知识库的问题，智能体树中的所有智能体共用
+// 有历史对话时，第一个需要检索知识库的智能体会把用户的问题改写成完整的问题，后面的智能体直接使用改写的结果
+type ragQuery struct {
+	message   string
+	history   []adk.Message
+	query     string
+	condensed bool
+}
+
+func newRagQuery(message string, history []adk.Message) *ragQuery {
+	return &ragQuery{message: message, history: history}
+}
+
+// get 返回检索使用的问题，改写失败时使用用户的原始问题
+func (q *ragQuery) get(ctx context.Context, chatModel aiModel.BaseChatModel) string {
+	if q.condensed {
+		return q.query
+	}
+	q.condensed = true
+	q.query = q.message
+	if len(q.history) == 0 {
+		return q.query
+	}
+	query, err := kbs.NewQueryCondenser(chatModel, maxCondenseTurns).Condense(ctx, q.history, q.message)
+	if err != nil {
+		logs.Errorf("改写检索问题失败: %v", err)
+		return q.query
+	}
+	q.query = query
+	return q.query
+}
+
+const (
+	maxCondenseTurns = 6 //改写检索问题时参考的最近对话条数
+	maxRagSources    = 5 //提供给大模型的知识库来源数量
+)
+
+func (s *service) buildRagContext(ctx context.Context, dataChan chan string, query *ragQuery, agent *model.Agent, chatModel aiModel.BaseChatModel) string {
 	var ragContext string
 	if len(agent.KnowledgeBases) > 0 {
+		//追问往往依赖上下文，例如"第二个呢？"，先结合历史对话改写成完整的问题再检索
+		message := query.get(ctx, chatModel)
 		//从关联的知识库中进行查询
 		var allResult []*shared.SearchKnowledgeBaseResult
 		for _, v := range agent.KnowledgeBases {
@@ -543,25 +779,32 @@ func (s *service) buildRagContext(ctx context.Context, dataChan chan string, mes
 			allResult = append(allResult, results...)
 		}
 		if len(allResult) > 0 {
+			//多个知识库的结果合并之后按分数排序，为了防止内容过长，只取前几个作为来源
+			sort.SliceStable(allResult, func(i, j int) bool {
+				return allResult[i].Score > allResult[j].Score
+			})
+			if len(allResult) > maxRagSources {
+				allResult = allResult[:maxRagSources]
+			}
 			var contextBuilder strings.Builder
-			contextBuilder.WriteString("【 参考以下知识库内容回答问题 】\n")
+			contextBuilder.WriteString("【 参考以下知识库内容回答问题，使用了某个来源的内容时，在对应的句子末尾用 [编号] 标注来源，例如 [1] 】\n")
+			citations := make([]*ai.Citation, 0, len(allResult))
 			for i, v := range allResult {
-				//为了防止内容过长，这里只取前几位的结果
-				//这个数字根据实际进行调整
-				if i >= 1 {
-					break
-				}
-				contextBuilder.WriteString(fmt.Sprintf("%d.  %s \n", i+1, v.Content))
+				contextBuilder.WriteString(fmt.Sprintf("[%d] 来源：%s\n%s\n\n", i+1, v.DocumentName, v.Content))
+				citations = append(citations, &ai.Citation{
+					Index:           i + 1,
+					KnowledgeBaseId: v.KnowledgeBaseId.String(),
+					DocumentId:      v.DocumentId.String(),
+					DocumentName:    v.DocumentName,
+					ChunkId:         v.ChunkId.String(),
+					Content:         v.Content,
+					Score:           v.Score,
+					Metadata:        v.Metadata,
+				})
 			}
 			ragContext = contextBuilder.String()
-			//知识库查询出来的内容，我们发送到前端进行展示
-			//toolName使用知识库的名称
-			var names strings.Builder
-			for _, v := range agent.KnowledgeBases {
-				names.WriteString(v.Name + "\t")
-			}
-			buildMessage := ai.BuildMessage(agent.Name, names.String(), ragContext)
-			dataChan <- buildMessage
+			//引用来源单独发送给前端，回答中的编号可以链接到对应的文档和分段，同时展示实际检索的问题
+			dataChan <- ai.BuildCitationsMessage(agent.Name, message, citations)
 		}
 	}
 	return ragContext
@@ -581,6 +824,264 @@ func (s *service) searchKnowledgeBase(ctx context.Context, userId uuid.UUID, mes
 	return response.Results, nil
 }
 
+const maxTitleLength = 50 //会话标题的最大长度
+
+func (s *service) loadConversation(ctx context.Context, userID uuid.UUID, agent *model.Agent, req AgentMessageReq) (*model.Conversation, []*model.ConversationMessage, error) {
+	if req.SessionId == uuid.Nil {
+		//新的会话 标题先用用户的第一条消息
+		title := []rune(strings.TrimSpace(req.Message))
+		if len(title) > maxTitleLength {
+			title = title[:maxTitleLength]
+		}
+		conversation := &model.Conversation{
+			BaseModel: model.BaseModel{
+				ID: uuid.New(),
+			},
+			AgentID:   agent.ID,
+			CreatorID: userID,
+			Title:     string(title),
+		}
+		err := s.repo.createConversation(ctx, conversation)
+		if err != nil {
+			logs.Errorf("创建会话失败: %v", err)
+			return nil, nil, errs.DBError
+		}
+		return conversation, nil, nil
+	}
+	conversation, err := s.repo.getConversation(ctx, userID, agent.ID, req.SessionId)
+	if err != nil {
+		logs.Errorf("查询会话失败: %v", err)
+		return nil, nil, errs.DBError
+	}
+	if conversation == nil {
+		return nil, nil, biz.ErrConversationNotFound
+	}
+	//已经压缩进摘要的消息不再加载，没有压缩的消息全部加载，超过预算的部分由 compactHistory 合并进摘要
+	messages, err := s.repo.listConversationMessages(ctx, conversation.ID, conversation.SummarizedAt, 0)
+	if err != nil {
+		logs.Errorf("查询会话消息失败: %v", err)
+		return nil, nil, errs.DBError
+	}
+	return conversation, messages, nil
+}
+
+// compactHistory 使用记忆管理器让历史消息保持在token预算内，摘要会保存到会话中
+// 压缩失败时不影响对话，发送给模型前还会再按预算裁剪一次
+func (s *service) compactHistory(ctx context.Context, agent *model.Agent, conversation *model.Conversation, stored []*model.ConversationMessage) []adk.Message {
+	summary := conversation.Summary
+	if len(stored) > 0 {
+		chatModel, err := s.buildChatModel(ctx, agent)
+		if err != nil {
+			logs.Errorf("构建chatmodel失败: %v", err)
+		} else {
+			modelParams := agent.ModelParameters.ToModelParams()
+			manager := memory.NewManager(chatModel, modelParams.ContextWindow, modelParams.MaxTokens)
+			messages := make([]*schema.Message, 0, len(stored))
+			for _, m := range stored {
+				messages = append(messages, m.ToSchemaMessage())
+			}
+			result, err := manager.Compact(ctx, summary, messages)
+			if err != nil {
+				logs.Errorf("压缩会话历史失败: %v", err)
+			} else if result.Compacted > 0 {
+				summarizedAt := stored[result.Compacted-1].CreatedAt
+				err = s.repo.updateConversationSummary(ctx, conversation.ID, result.Summary, summarizedAt)
+				if err != nil {
+					logs.Errorf("保存会话摘要失败: %v", err)
+				} else {
+					conversation.Summary = result.Summary
+					conversation.SummarizedAt = &summarizedAt
+				}
+				summary = result.Summary
+				stored = stored[result.Compacted:]
+			}
+		}
+	}
+	history := s.buildHistoryMessages(stored)
+	if summary != "" {
+		history = append([]adk.Message{memory.SummaryMessage(summary)}, history...)
+	}
+	return history
+}
+
+// buildHistoryMessages 将存储的会话消息转换为大模型的上下文
+// 工具调用和工具结果必须成对出现，否则大模型会报错，所以这里把不完整的工具调用过滤掉
+func (s *service) buildHistoryMessages(messages []*model.ConversationMessage) []adk.Message {
+	toolResults := make(map[string]bool)
+	for _, m := range messages {
+		if m.Role == schema.Tool && m.ToolCallID != "" {
+			toolResults[m.ToolCallID] = true
+		}
+	}
+	toolCalls := make(map[string]bool)
+	history := make([]adk.Message, 0, len(messages))
+	for _, m := range messages {
+		switch m.Role {
+		case schema.Assistant:
+			if len(m.ToolCalls) > 0 {
+				complete := true
+				for _, tc := range m.ToolCalls {
+					if !toolResults[tc.ID] {
+						complete = false
+						break
+					}
+				}
+				if !complete {
+					//工具调用不完整，只保留文本内容
+					if m.Content != "" {
+						history = append(history, schema.AssistantMessage(m.Content, nil))
+					}
+					continue
+				}
+				for _, tc := range m.ToolCalls {
+					toolCalls[tc.ID] = true
+				}
+			} else if m.Content == "" {
+				continue
+			}
+			history = append(history, m.ToSchemaMessage())
+		case schema.Tool:
+			if !toolCalls[m.ToolCallID] {
+				continue
+			}
+			history = append(history, m.ToSchemaMessage())
+		case schema.User:
+			history = append(history, m.ToSchemaMessage())
+		}
+	}
+	return history
+}
+
+func (s *service) toConversationMessage(agentName string, msg adk.Message) *model.ConversationMessage {
+	if msg.Content == "" && msg.ReasoningContent == "" && len(msg.ToolCalls) == 0 {
+		return nil
+	}
+	return &model.ConversationMessage{
+		Role:             msg.Role,
+		AgentName:        agentName,
+		Content:          msg.Content,
+		ReasoningContent: msg.ReasoningContent,
+		ToolCalls:        msg.ToolCalls,
+		ToolCallID:       msg.ToolCallID,
+		ToolName:         msg.ToolName,
+		TokenCount:       utils.GetTokenCount(msg.Content),
+	}
+}
+
+func (s *service) saveConversationMessage(conversationId uuid.UUID, message *model.ConversationMessage) {
+	if message == nil {
+		return
+	}
+	//客户端断开连接后也要把消息存下来，所以这里不用请求的上下文
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	message.ID = uuid.New()
+	message.ConversationID = conversationId
+	if message.TokenCount == 0 {
+		message.TokenCount = utils.GetTokenCount(message.Content)
+	}
+	err := s.repo.createConversationMessage(ctx, message)
+	if err != nil {
+		logs.Errorf("保存会话消息失败: %v", err)
+	}
+}
+
+func (s *service) listConversations(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, req listConversationReq) (*ListConversationResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	page := req.Page
+	if page <= 0 {
+		page = 1
+	}
+	size := req.PageSize
+	if size <= 0 {
+		size = 20
+	}
+	filter := ConversationFilter{
+		Title:  req.Title,
+		Limit:  size,
+		Offset: (page - 1) * size,
+	}
+	list, total, err := s.repo.listConversations(ctx, userID, agentId, filter)
+	if err != nil {
+		logs.Errorf("查询会话列表失败: %v", err)
+		return nil, errs.DBError
+	}
+	return &ListConversationResponse{
+		Conversations: list,
+		Total:         total,
+	}, nil
+}
+
+func (s *service) getConversation(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, id uuid.UUID) (*model.Conversation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conversation, err := s.repo.getConversation(ctx, userID, agentId, id)
+	if err != nil {
+		logs.Errorf("查询会话失败: %v", err)
+		return nil, errs.DBError
+	}
+	if conversation == nil {
+		return nil, biz.ErrConversationNotFound
+	}
+	messages, err := s.repo.listConversationMessages(ctx, conversation.ID, nil, 0)
+	if err != nil {
+		logs.Errorf("查询会话消息失败: %v", err)
+		return nil, errs.DBError
+	}
+	conversation.Messages = messages
+	return conversation, nil
+}
+
+func (s *service) updateConversation(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, id uuid.UUID, req updateConversationReq) (*model.Conversation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return nil, errs.ErrParam
+	}
+	conversation, err := s.repo.getConversation(ctx, userID, agentId, id)
+	if err != nil {
+		logs.Errorf("查询会话失败: %v", err)
+		return nil, errs.DBError
+	}
+	if conversation == nil {
+		return nil, biz.ErrConversationNotFound
+	}
+	conversation.Title = title
+	err = s.repo.updateConversation(ctx, conversation)
+	if err != nil {
+		logs.Errorf("更新会话失败: %v", err)
+		return nil, errs.DBError
+	}
+	return conversation, nil
+}
+
+func (s *service) deleteConversation(ctx context.Context, userID uuid.UUID, agentId uuid.UUID, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conversation, err := s.repo.getConversation(ctx, userID, agentId, id)
+	if err != nil {
+		logs.Errorf("查询会话失败: %v", err)
+		return errs.DBError
+	}
+	if conversation == nil {
+		return biz.ErrConversationNotFound
+	}
+	err = s.repo.transaction(ctx, func(tx *gorm.DB) error {
+		err := s.repo.deleteConversationMessages(ctx, tx, conversation.ID)
+		if err != nil {
+			return err
+		}
+		return s.repo.deleteConversation(ctx, tx, conversation.ID)
+	})
+	if err != nil {
+		logs.Errorf("删除会话失败: %v", err)
+		return errs.DBError
+	}
+	return nil
+}
+
 func newService() *service {
 	return &service{
 		repo: newModels(database.GetPostgresDB().GormDB),
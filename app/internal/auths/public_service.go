@@ -0,0 +1,38 @@
+package auths
+
+import (
+	"app/shared"
+	"context"
+	"model"
+	"time"
+
+	"github.com/mszlu521/thunder/database"
+	"github.com/mszlu521/thunder/event"
+	"github.com/mszlu521/thunder/logs"
+)
+
+type PublicService struct {
+	repo repository
+}
+
+// GetUserPlan 获取用户当前的订阅版本，用于其他模块校验额度
+func (s *PublicService) GetUserPlan(e event.Event) (any, error) {
+	request := e.Data.(*shared.GetUserPlanRequest)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	user, err := s.repo.findById(ctx, request.UserId)
+	if err != nil {
+		logs.Errorf("get user error: %v", err)
+		return nil, err
+	}
+	if user == nil || user.CurrentPlan == "" {
+		return model.FreePlan, nil
+	}
+	return user.CurrentPlan, nil
+}
+
+func NewPublicService() *PublicService {
+	return &PublicService{
+		repo: newModel(database.GetPostgresDB().GormDB),
+	}
+}
@@ -1,6 +1,7 @@
 package inits
 
 import (
+	"app/internal/knowledges"
 	"app/internal/router"
 	"core/ai/tools"
 
@@ -9,15 +10,18 @@ import (
 	"github.com/mszlu521/thunder/logs"
 	"github.com/mszlu521/thunder/server"
 	"github.com/mszlu521/thunder/tools/jwt"
+	"github.com/spf13/viper"
 )
 
-func Init(s *server.Server, conf *config.Config) {
+func Init(s *server.Server, conf *config.Config, v *viper.Viper) {
 	//初始化数据库
 	database.InitPostgres(conf.DB.Postgres)
 	//初始化redis
 	database.InitRedis(conf.DB.Redis)
 	//初始化jwt
 	jwt.Init(conf.Jwt.GetSecret())
+	//读取知识库模块的配置
+	knowledges.InitConfig(v)
 	//注册系统工具
 	registerTools()
 	closeFuncs := s.RegisterRouters(
@@ -28,6 +32,7 @@ func Init(s *server.Server, conf *config.Config) {
 		&router.LLMRouter{},
 		&router.ToolRouter{},
 		&router.KnowledgeBaseRouter{},
+		&router.WorkflowRouter{},
 	)
 	s.Close = func() {
 		for _, f := range closeFuncs {
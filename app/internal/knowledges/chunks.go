@@ -0,0 +1,398 @@
+package knowledges
+
+import (
+	"common/biz"
+	"common/utils"
+	"context"
+	"core/ai/kbs"
+	"fmt"
+	"model"
+	"strings"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/errs"
+	"github.com/mszlu521/thunder/logs"
+)
+
+// 分段的修改只针对父分段，子分段跟随父分段重新切分和向量化
+// 文档重新上传或者重新入库时会重新切分，手动的修改会被覆盖
+
+func (s *service) listChunks(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, params listChunkReq) (*ListChunksResp, error) {
+	_, doc, err := s.getChunkDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		return nil, err
+	}
+	page := params.Page
+	if page <= 0 {
+		page = 1
+	}
+	size := params.PageSize
+	if size <= 0 {
+		size = 10
+	}
+	filter := ChunkFilter{
+		Status: params.Status,
+		Limit:  size,
+		Offset: (page - 1) * size,
+	}
+	chunks, total, err := s.repo.listDocumentChunks(ctx, doc.ID, filter)
+	if err != nil {
+		logs.Errorf("list document chunks error: %v", err)
+		return nil, errs.DBError
+	}
+	return &ListChunksResp{
+		Chunks: chunks,
+		Total:  total,
+	}, nil
+}
+
+// getChunk 返回父分段和它的子分段
+func (s *service) getChunk(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, chunkId uuid.UUID) (*ChunkResponse, error) {
+	_, doc, err := s.getChunkDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := s.getParentChunk(ctx, doc.ID, chunkId)
+	if err != nil {
+		return nil, err
+	}
+	children, err := s.repo.listChunkChildren(ctx, chunk.ID)
+	if err != nil {
+		logs.Errorf("list chunk children error: %v", err)
+		return nil, errs.DBError
+	}
+	return &ChunkResponse{
+		DocumentChunk: chunk,
+		Children:      children,
+	}, nil
+}
+
+// updateChunk 修改父分段的内容，重新切分子分段并写入向量库
+func (s *service) updateChunk(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, chunkId uuid.UUID, req updateChunkReq) (*model.DocumentChunk, error) {
+	kb, doc, err := s.getChunkDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDocumentEditable(doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, errs.ErrParam
+	}
+	chunk, err := s.getParentChunk(ctx, doc.ID, chunkId)
+	if err != nil {
+		return nil, err
+	}
+	chunk.Content = req.Content
+	chunk.TokenCount = utils.GetTokenCount(req.Content)
+	if req.MetaInfo != nil {
+		chunk.MetaInfo = req.MetaInfo
+	}
+	err = s.storeChunkChildren(ctx, kb, doc, chunk, s.splitChunkChildren(kb, doc, chunk), false)
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// updateChunkStatus 禁用的分段从向量库中删除，检索不到，启用时使用保存的子分段重新写入向量库
+func (s *service) updateChunkStatus(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, chunkId uuid.UUID, req updateChunkStatusReq) (*model.DocumentChunk, error) {
+	kb, doc, err := s.getChunkDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDocumentEditable(doc); err != nil {
+		return nil, err
+	}
+	chunk, err := s.getParentChunk(ctx, doc.ID, chunkId)
+	if err != nil {
+		return nil, err
+	}
+	status := model.ChunkStatusDisabled
+	if req.Enabled {
+		status = model.ChunkStatusEmbedded
+	}
+	if chunk.Status == status {
+		return chunk, nil
+	}
+	store, err := s.newVectorStore(ctx, kb)
+	if err != nil {
+		logs.Errorf("new vector store error: %v", err)
+		return nil, err
+	}
+	if req.Enabled {
+		children, err := s.repo.listChunkChildren(ctx, chunk.ID)
+		if err != nil {
+			logs.Errorf("list chunk children error: %v", err)
+			return nil, errs.DBError
+		}
+		if len(children) == 0 {
+			//之前入库的分段没有保存子分段，禁用时向量已经删除，重新切分父分段并保存子分段
+			childDocs := s.splitChunkChildren(kb, doc, chunk)
+			if len(childDocs) == 0 {
+				return nil, biz.ErrEmbedding
+			}
+			//storeChunkChildren 按父分段的状态写入向量库，同时保存父分段的状态
+			chunk.Status = status
+			err = s.storeChunkChildren(ctx, kb, doc, chunk, childDocs, false)
+			if err != nil {
+				return nil, err
+			}
+			return chunk, nil
+		}
+		docs := make([]*schema.Document, 0, len(children))
+		for _, child := range children {
+			metadata := make(map[string]any, len(child.MetaInfo))
+			for k, v := range child.MetaInfo {
+				metadata[k] = v
+			}
+			docs = append(docs, &schema.Document{
+				ID:       child.ID.String(),
+				Content:  child.Content,
+				MetaData: metadata,
+			})
+		}
+		//先删除再写入，上一次启用失败时可能残留了一部分向量
+		err = store.DeleteByParent(ctx, chunk.ID.String())
+		if err == nil && len(docs) > 0 {
+			err = store.Store(ctx, docs)
+		}
+		if err != nil {
+			logs.Errorf("store chunk vectors error: %v", err)
+			return nil, biz.ErrEmbedding
+		}
+	} else {
+		err = store.DeleteByParent(ctx, chunk.ID.String())
+		if err != nil {
+			logs.Errorf("delete chunk vectors error: %v", err)
+			return nil, err
+		}
+	}
+	err = s.repo.updateChunkStatus(ctx, chunk.ID, status)
+	if err != nil {
+		logs.Errorf("update chunk status error: %v", err)
+		return nil, errs.DBError
+	}
+	chunk.Status = status
+	return chunk, nil
+}
+
+// createChunk 手动添加分段，传入问题和答案时按问答对保存，问题单独作为一个子分段方便匹配用户的提问
+func (s *service) createChunk(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, req createChunkReq) (*model.DocumentChunk, error) {
+	kb, doc, err := s.getChunkDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDocumentEditable(doc); err != nil {
+		return nil, err
+	}
+	question := strings.TrimSpace(req.Question)
+	answer := strings.TrimSpace(req.Answer)
+	content := strings.TrimSpace(req.Content)
+	metaInfo := model.JSON{}
+	for k, v := range req.MetaInfo {
+		metaInfo[k] = v
+	}
+	metaInfo["source"] = doc.Name
+	metaInfo["type"] = "manual"
+	if question != "" || answer != "" {
+		if question == "" || answer == "" {
+			return nil, errs.ErrParam
+		}
+		content = fmt.Sprintf("问：%s\n答：%s", question, answer)
+		metaInfo["type"] = "qa"
+		metaInfo["question"] = question
+	}
+	if content == "" {
+		return nil, errs.ErrParam
+	}
+	index, err := s.repo.nextChunkIndex(ctx, doc.ID)
+	if err != nil {
+		logs.Errorf("next chunk index error: %v", err)
+		return nil, errs.DBError
+	}
+	chunk := &model.DocumentChunk{
+		BaseModel:       model.BaseModel{ID: uuid.New()},
+		DocumentID:      doc.ID,
+		KnowledgeBaseID: kb.ID,
+		Content:         content,
+		ChunkIndex:      index,
+		MetaInfo:        metaInfo,
+		TokenCount:      utils.GetTokenCount(content),
+		Status:          model.ChunkStatusEmbedded,
+	}
+	pathPrefix := fmt.Sprintf("【文档:%s】\n", doc.Name)
+	var childDocs []*schema.Document
+	if question != "" {
+		childDocs = append(childDocs, s.buildChildSchemaDoc(chunk.ID, doc, kb, pathPrefix+question, index, 0, 0, metaInfo))
+	}
+	_, childChunker := chunkers(kb)
+	for _, text := range childChunker.Split(content) {
+		childDocs = append(childDocs, s.buildChildSchemaDoc(chunk.ID, doc, kb, pathPrefix+text, index, len(childDocs), 0, metaInfo))
+	}
+	err = s.storeChunkChildren(ctx, kb, doc, chunk, childDocs, true)
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// splitChunkChildren 按知识库的切分配置把父分段的内容切分成子分段
+func (s *service) splitChunkChildren(kb *model.KnowledgeBase, doc *model.Document, chunk *model.DocumentChunk) []*schema.Document {
+	_, childChunker := chunkers(kb)
+	pathPrefix := fmt.Sprintf("【文档:%s】\n", doc.Name)
+	var childDocs []*schema.Document
+	for j, text := range childChunker.Split(chunk.Content) {
+		childDocs = append(childDocs, s.buildChildSchemaDoc(chunk.ID, doc, kb, pathPrefix+text, chunk.ChunkIndex, j, 0, chunk.MetaInfo))
+	}
+	return childDocs
+}
+
+// storeChunkChildren 替换父分段在向量库和数据库中的子分段，禁用的分段只修改数据库
+// 先写入新的向量，数据库保存成功之后再删除旧的向量，任何一步失败时检索到的还是修改之前的内容
+// isNew 为 true 时新建父分段
+func (s *service) storeChunkChildren(ctx context.Context, kb *model.KnowledgeBase, doc *model.Document, chunk *model.DocumentChunk, childDocs []*schema.Document, isNew bool) error {
+	children := s.buildChildChunks(doc, kb, childDocs, 0)
+	for _, child := range children {
+		child.Status = chunk.Status
+	}
+	var store kbs.VectorStore
+	var oldIds []string
+	//legacyDeleted 旧的向量已经按父分段删除
+	legacyDeleted := false
+	if chunk.Status == model.ChunkStatusEmbedded {
+		var err error
+		store, err = s.newVectorStore(ctx, kb)
+		if err != nil {
+			logs.Errorf("new vector store error: %v", err)
+			return err
+		}
+		if !isNew {
+			oldChildren, err := s.repo.listChunkChildren(ctx, chunk.ID)
+			if err != nil {
+				logs.Errorf("list chunk children error: %v", err)
+				return errs.DBError
+			}
+			if len(oldChildren) == 0 {
+				//之前入库的分段没有保存子分段，不知道旧向量的id，只能先按父分段删除
+				//禁用的分段在向量库中没有数据，不需要删除
+				stored, err := s.getParentChunk(ctx, doc.ID, chunk.ID)
+				if err != nil {
+					return err
+				}
+				if stored.Status == model.ChunkStatusEmbedded {
+					err = store.DeleteByParent(ctx, chunk.ID.String())
+					if err != nil {
+						logs.Errorf("delete chunk vectors error: %v", err)
+						return err
+					}
+					legacyDeleted = true
+				}
+			}
+			for _, child := range oldChildren {
+				oldIds = append(oldIds, child.ID.String())
+			}
+		}
+		err = store.Store(ctx, childDocs)
+		if err != nil {
+			logs.Errorf("store chunk vectors error: %v", err)
+			if legacyDeleted {
+				s.markChunkPending(ctx, chunk)
+			}
+			return biz.ErrEmbedding
+		}
+	}
+	err := s.saveChunkChildren(ctx, chunk, children, isNew)
+	if err != nil {
+		if store != nil {
+			//数据库没有修改，删除刚写入的向量，检索时使用原来的子分段
+			newIds := make([]string, 0, len(childDocs))
+			for _, child := range childDocs {
+				newIds = append(newIds, child.ID)
+			}
+			if err := store.DeleteByIds(ctx, newIds); err != nil {
+				logs.Errorf("delete chunk vectors error: %v", err)
+			}
+			if legacyDeleted {
+				s.markChunkPending(ctx, chunk)
+			}
+		}
+		return err
+	}
+	if len(oldIds) > 0 {
+		//旧的子分段在数据库中已经删除，残留的向量检索时会被过滤掉，删除失败不影响结果
+		if err := store.DeleteByIds(ctx, oldIds); err != nil {
+			logs.Errorf("delete chunk vectors error: %v", err)
+		}
+	}
+	return nil
+}
+
+// saveChunkChildren 保存父分段和子分段
+func (s *service) saveChunkChildren(ctx context.Context, chunk *model.DocumentChunk, children []*model.DocumentChunk, isNew bool) error {
+	if isNew {
+		err := s.repo.createDocumentChunks(ctx, []*model.DocumentChunk{chunk})
+		if err != nil {
+			logs.Errorf("create document chunk error: %v", err)
+			return errs.DBError
+		}
+	}
+	err := s.repo.replaceChunkChildren(ctx, chunk, children)
+	if err != nil {
+		logs.Errorf("replace chunk children error: %v", err)
+		return errs.DBError
+	}
+	return nil
+}
+
+// markChunkPending 旧的向量已经删除但是新的没有写入时，把父分段改成待处理，避免状态是已入库但是检索不到
+// 重新启用分段时会重新切分并写入向量库
+func (s *service) markChunkPending(ctx context.Context, chunk *model.DocumentChunk) {
+	err := s.repo.updateChunkStatus(context.WithoutCancel(ctx), chunk.ID, model.ChunkStatusPending)
+	if err != nil {
+		logs.Errorf("update chunk status error: %v", err)
+	}
+}
+
+// getChunkDocument 确认知识库和文档存在并且属于当前用户
+func (s *service) getChunkDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) (*model.KnowledgeBase, *model.Document, error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
+	if err != nil {
+		logs.Errorf("get knowledge base error: %v", err)
+		return nil, nil, errs.DBError
+	}
+	if kb == nil || kb.CreatorID != userId {
+		return nil, nil, biz.ErrKnowledgeBaseNotFound
+	}
+	doc, err := s.repo.getDocument(ctx, userId, kbId, documentId)
+	if err != nil {
+		logs.Errorf("get document error: %v", err)
+		return nil, nil, errs.DBError
+	}
+	if doc == nil {
+		return nil, nil, biz.ErrDocumentNotFound
+	}
+	return kb, doc, nil
+}
+
+// getParentChunk 只能操作父分段，子分段的ID按不存在处理
+func (s *service) getParentChunk(ctx context.Context, documentId uuid.UUID, chunkId uuid.UUID) (*model.DocumentChunk, error) {
+	chunk, err := s.repo.getDocumentChunk(ctx, documentId, chunkId)
+	if err != nil {
+		logs.Errorf("get document chunk error: %v", err)
+		return nil, errs.DBError
+	}
+	if chunk == nil || chunk.ParentID != nil {
+		return nil, biz.ErrChunkNotFound
+	}
+	return chunk, nil
+}
+
+// checkDocumentEditable 入库过程中会重新切分，修改的内容会被覆盖，处理完成之后才能修改
+func checkDocumentEditable(doc *model.Document) error {
+	if doc.Status == model.DocumentStatusPending || doc.Status == model.DocumentStatusProcessing {
+		return biz.ErrDocumentProcessing
+	}
+	return nil
+}
@@ -1,7 +1,12 @@
 package knowledges
 
 import (
+	"context"
+	"encoding/json"
+	"mime"
+	"model"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -140,7 +145,72 @@ func (h *Handler) UploadDocuments(c *gin.Context) {
 		res.Error(c, errs.ErrParam)
 		return
 	}
-	resp, err := h.service.uploadDocuments(c.Request.Context(), userId, kbId, file)
+	//replace=true 时重新处理内容相同的文档并替换旧的版本
+	replace := c.PostForm("replace") == "true" || c.Query("replace") == "true"
+	resp, err := h.service.uploadDocuments(c.Request.Context(), userId, kbId, file, replace)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+// PreviewDocument 试切上传的文件，返回切分结果和预估的向量化费用，不会入库
+func (h *Handler) PreviewDocument(c *gin.Context) {
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		res.Error(c, errs.ErrParam)
+		return
+	}
+	var params previewReq
+	//切分配置以json字符串的形式放在表单中
+	if config := c.PostForm("chunkingConfig"); config != "" {
+		params.ChunkingConfig = &model.ChunkingConfig{}
+		if err := json.Unmarshal([]byte(config), params.ChunkingConfig); err != nil {
+			res.Error(c, errs.ErrParam)
+			return
+		}
+	}
+	if price := c.PostForm("pricePer1kTokens"); price != "" {
+		params.PricePer1KTokens, err = strconv.ParseFloat(price, 64)
+		if err != nil {
+			res.Error(c, errs.ErrParam)
+			return
+		}
+	}
+	resp, err := h.service.previewDocument(c.Request.Context(), userId, kbId, file, params)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) UpdateDocument(c *gin.Context) {
+	var updateReq updateDocumentReq
+	if err := req.JsonParam(c, &updateReq); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	resp, err := h.service.updateDocument(c.Request.Context(), userId, kbId, documentId, updateReq)
 	if err != nil {
 		res.Error(c, err)
 		return
@@ -169,6 +239,74 @@ func (h *Handler) DeleteDocuments(c *gin.Context) {
 	res.Success(c, nil)
 }
 
+// ReindexKnowledgeBase 重建知识库的向量索引，进度通过文档事件推送，也可以查询任务
+func (h *Handler) ReindexKnowledgeBase(c *gin.Context) {
+	var params reindexReq
+	if err := req.JsonParam(c, &params); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	resp, err := h.service.reindexKnowledgeBase(c.Request.Context(), userId, kbId, params)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+// GetReindexJob 查询知识库最近一次重建索引的任务
+func (h *Handler) GetReindexJob(c *gin.Context) {
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	resp, err := h.service.getReindexJob(c.Request.Context(), userId, kbId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+// DownloadDocument 下载文档的原始文件
+func (h *Handler) DownloadDocument(c *gin.Context) {
+	var kbId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	var documentId uuid.UUID
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	doc, reader, err := h.service.downloadDocument(c.Request.Context(), userId, kbId, documentId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	defer reader.Close()
+	contentType := mime.TypeByExtension(doc.FileType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.Name}))
+	c.DataFromReader(http.StatusOK, doc.Size, contentType, reader, nil)
+}
+
 func (h *Handler) SearchKnowledgeBase(c *gin.Context) {
 	rc := http.NewResponseController(c.Writer)
 	//为了防止超时，因为我们加了大模型对话
@@ -196,12 +334,200 @@ func (h *Handler) SearchKnowledgeBase(c *gin.Context) {
 	res.Success(c, resp)
 }
 
+// DocumentEvents 通过SSE推送知识库中文档的入库进度
+func (h *Handler) DocumentEvents(c *gin.Context) {
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	datachan, err := h.service.documentEvents(ctx, userId, kbId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	//入库进度会一直推送，不能使用全局的超时
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logs.Warnf("SetWriteDeadline error: %v", err)
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Writer.Flush()
+	//创建一个心跳 这里是防止一些防火墙拦截 导致连接中断
+	heartbeat := time.NewTicker(time.Second * 5)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logs.Warnf("context done, 客户端断开连接")
+			return
+		case <-heartbeat.C:
+			_, err := c.Writer.Write([]byte(": keep-alive\n\n"))
+			if err != nil {
+				logs.Warnf("write heartbeat error: %v", err)
+				cancel()
+				return
+			}
+			c.Writer.Flush()
+		case data, ok := <-datachan:
+			if !ok {
+				_, err := c.Writer.Write([]byte("data: [DONE]\n\n"))
+				if err != nil {
+					logs.Warnf("write done error: %v", err)
+				}
+				c.Writer.Flush()
+				return
+			}
+			_, err := c.Writer.Write([]byte("data: " + data + "\n\n"))
+			if err != nil {
+				logs.Errorf("write data error: %v", err)
+				cancel()
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
 func (h *Handler) Close() error {
 	return h.service.Close()
 }
 
 func NewHandler() *Handler {
 	return &Handler{
-		service: newService(),
+		service: getService(),
+	}
+}
+
+func (h *Handler) ListChunks(c *gin.Context) {
+	var params listChunkReq
+	if err := req.QueryParam(c, &params); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	resp, err := h.service.listChunks(c.Request.Context(), userId, kbId, documentId, params)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) GetChunk(c *gin.Context) {
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId, chunkId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
 	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	if err := req.Path(c, "chunkId", &chunkId); err != nil {
+		return
+	}
+	resp, err := h.service.getChunk(c.Request.Context(), userId, kbId, documentId, chunkId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) CreateChunk(c *gin.Context) {
+	var createReq createChunkReq
+	if err := req.JsonParam(c, &createReq); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	resp, err := h.service.createChunk(c.Request.Context(), userId, kbId, documentId, createReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) UpdateChunk(c *gin.Context) {
+	var updateReq updateChunkReq
+	if err := req.JsonParam(c, &updateReq); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId, chunkId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	if err := req.Path(c, "chunkId", &chunkId); err != nil {
+		return
+	}
+	resp, err := h.service.updateChunk(c.Request.Context(), userId, kbId, documentId, chunkId, updateReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+// UpdateChunkStatus 启用或者禁用分段
+func (h *Handler) UpdateChunkStatus(c *gin.Context) {
+	var statusReq updateChunkStatusReq
+	if err := req.JsonParam(c, &statusReq); err != nil {
+		return
+	}
+	userId, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	var kbId, documentId, chunkId uuid.UUID
+	if err := req.Path(c, "id", &kbId); err != nil {
+		return
+	}
+	if err := req.Path(c, "documentId", &documentId); err != nil {
+		return
+	}
+	if err := req.Path(c, "chunkId", &chunkId); err != nil {
+		return
+	}
+	resp, err := h.service.updateChunkStatus(c.Request.Context(), userId, kbId, documentId, chunkId, statusReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
 }
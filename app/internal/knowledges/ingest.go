@@ -0,0 +1,331 @@
+package knowledges
+
+import (
+	"context"
+	"fmt"
+	"model"
+	"sync"
+	"time"
+
+	"github.com/mszlu521/thunder/logs"
+	"github.com/spf13/viper"
+)
+
+// ingestConfig 文档入库任务的配置，对应 etc/config.yml 中的 knowledge.ingest
+type ingestConfig struct {
+	// Concurrency 同时处理的文档数量
+	Concurrency int `mapstructure:"concurrency"`
+	// MaxAttempts 每个文档最多处理的次数
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// BaseBackoff 第一次重试的等待时间，之后每次翻倍，最多等待 MaxBackoff
+	BaseBackoff time.Duration `mapstructure:"baseBackoff"`
+	MaxBackoff  time.Duration `mapstructure:"maxBackoff"`
+	// PollInterval 没有任务时查询任务表的间隔
+	PollInterval time.Duration `mapstructure:"pollInterval"`
+	// StaleTimeout 执行中的任务超过这个时间没有刷新，认为worker已经退出，重新放回队列
+	StaleTimeout time.Duration `mapstructure:"staleTimeout"`
+}
+
+// defaultIngestConfig 配置文件中没有配置时使用的默认值
+var defaultIngestConfig = ingestConfig{
+	Concurrency:  4,
+	MaxAttempts:  3,
+	BaseBackoff:  10 * time.Second,
+	MaxBackoff:   10 * time.Minute,
+	PollInterval: 2 * time.Second,
+	StaleTimeout: 5 * time.Minute,
+}
+
+// ingestSettings 当前使用的配置，由 InitConfig 从配置文件中读取
+var ingestSettings = defaultIngestConfig
+
+// InitConfig 读取知识库模块的配置，需要在注册路由之前调用
+func InitConfig(v *viper.Viper) {
+	if v == nil {
+		return
+	}
+	config := defaultIngestConfig
+	if err := v.UnmarshalKey("knowledge.ingest", &config); err != nil {
+		logs.Errorf("load knowledge ingest config error: %v", err)
+		return
+	}
+	ingestSettings = config.withDefaults()
+}
+
+// withDefaults 把没有配置或者配置错误的项换成默认值
+func (c ingestConfig) withDefaults() ingestConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultIngestConfig.Concurrency
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultIngestConfig.MaxAttempts
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = defaultIngestConfig.BaseBackoff
+	}
+	if c.MaxBackoff < c.BaseBackoff {
+		c.MaxBackoff = max(defaultIngestConfig.MaxBackoff, c.BaseBackoff)
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultIngestConfig.PollInterval
+	}
+	if c.StaleTimeout <= 0 {
+		c.StaleTimeout = defaultIngestConfig.StaleTimeout
+	}
+	return c
+}
+
+// ingestQueue 基于 Postgres 任务表的文档入库队列
+// 任务持久化在 ingestion_jobs 表中，进程重启之后会继续执行
+type ingestQueue struct {
+	repo     repository
+	config   ingestConfig
+	progress *progressHub
+	process  func(ctx context.Context, doc *model.Document) error
+	wake     chan struct{}
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newIngestQueue(repo repository, config ingestConfig, progress *progressHub, process func(ctx context.Context, doc *model.Document) error) *ingestQueue {
+	return &ingestQueue{
+		repo:     repo,
+		config:   config,
+		progress: progress,
+		process:  process,
+		wake:     make(chan struct{}, config.Concurrency),
+	}
+}
+
+// start 启动worker和恢复任务的协程
+func (q *ingestQueue) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.recoverLoop(ctx)
+	}()
+	for i := 0; i < q.config.Concurrency; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.worker(ctx)
+		}()
+	}
+}
+
+// close 停止领取新任务并等待执行中的任务结束
+func (q *ingestQueue) close() {
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.wg.Wait()
+}
+
+// enqueue 为文档创建入库任务
+func (q *ingestQueue) enqueue(ctx context.Context, doc *model.Document) error {
+	err := q.repo.createIngestionJob(ctx, &model.IngestionJob{
+		DocumentID:      doc.ID,
+		KnowledgeBaseID: doc.KnowledgeBaseID,
+		Status:          model.IngestionJobPending,
+		MaxAttempts:     q.config.MaxAttempts,
+		NextRunAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	q.publish(doc, &IngestProgress{Stage: IngestStageQueued})
+	//唤醒一个空闲的worker，不用等到下一次轮询
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *ingestQueue) worker(ctx context.Context) {
+	ticker := time.NewTicker(q.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		//一直领取任务直到没有到期的任务
+		for ctx.Err() == nil {
+			job, err := q.repo.claimIngestionJob(ctx)
+			if err != nil {
+				logs.Errorf("claim ingestion job error: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.runJob(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *ingestQueue) runJob(ctx context.Context, job *model.IngestionJob) {
+	doc, err := q.repo.getDocumentById(ctx, job.DocumentID)
+	if err != nil {
+		logs.Errorf("get document error: %v", err)
+		q.retry(ctx, job, nil, err)
+		return
+	}
+	if doc == nil {
+		//文档已经被删除
+		job.Status = model.IngestionJobFailed
+		job.Error = "文档不存在"
+		q.finish(ctx, job)
+		return
+	}
+	if job.Attempts > job.MaxAttempts {
+		//执行过程中进程退出，被重新领取的次数超过了限制
+		q.fail(ctx, job, doc, fmt.Errorf("超过最大重试次数"))
+		return
+	}
+	err = q.repo.updateDocumentResult(ctx, doc.ID, model.DocumentStatusProcessing, "")
+	if err != nil {
+		logs.Errorf("update document status error: %v", err)
+	}
+	//执行过程中定时刷新锁定时间，避免被当成失效的任务
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	go q.heartbeat(heartbeatCtx, job)
+	//处理时间比较长，进程退出时不打断正在处理的文档
+	err = q.process(context.WithoutCancel(ctx), doc)
+	stopHeartbeat()
+	if err != nil {
+		logs.Errorf("process document %s error: %v", doc.ID, err)
+		q.retry(ctx, job, doc, err)
+		return
+	}
+	err = q.repo.updateDocumentResult(ctx, doc.ID, model.DocumentStatusCompleted, "")
+	if err != nil {
+		logs.Errorf("update document status error: %v", err)
+	}
+	job.Status = model.IngestionJobSucceeded
+	job.Error = ""
+	q.finish(ctx, job)
+	q.publish(doc, &IngestProgress{Stage: IngestStageCompleted, Attempt: job.Attempts})
+}
+
+func (q *ingestQueue) publish(doc *model.Document, progress *IngestProgress) {
+	progress.KbId = doc.KnowledgeBaseID
+	progress.DocumentId = doc.ID
+	progress.DocumentName = doc.Name
+	q.progress.publish(progress)
+}
+
+// retry 没有超过最大次数时按指数退避重新放回队列
+func (q *ingestQueue) retry(ctx context.Context, job *model.IngestionJob, doc *model.Document, cause error) {
+	if job.Attempts >= job.MaxAttempts {
+		q.fail(ctx, job, doc, cause)
+		return
+	}
+	delay := backoff(job.Attempts, q.config.BaseBackoff, q.config.MaxBackoff)
+	job.Status = model.IngestionJobPending
+	job.Error = cause.Error()
+	job.NextRunAt = time.Now().Add(delay)
+	q.finish(ctx, job)
+	if doc != nil {
+		errMsg := fmt.Sprintf("第%d次处理失败，%s后重试: %s", job.Attempts, delay, cause.Error())
+		err := q.repo.updateDocumentResult(context.WithoutCancel(ctx), doc.ID, model.DocumentStatusPending, errMsg)
+		if err != nil {
+			logs.Errorf("update document status error: %v", err)
+		}
+		q.publish(doc, &IngestProgress{Stage: IngestStageRetrying, Attempt: job.Attempts, Error: cause.Error()})
+	}
+}
+
+func (q *ingestQueue) fail(ctx context.Context, job *model.IngestionJob, doc *model.Document, cause error) {
+	job.Status = model.IngestionJobFailed
+	job.Error = cause.Error()
+	q.finish(ctx, job)
+	if doc != nil {
+		err := q.repo.updateDocumentResult(context.WithoutCancel(ctx), doc.ID, model.DocumentStatusFailed, cause.Error())
+		if err != nil {
+			logs.Errorf("update document status error: %v", err)
+		}
+		q.publish(doc, &IngestProgress{Stage: IngestStageFailed, Attempt: job.Attempts, Error: cause.Error()})
+	}
+}
+
+func (q *ingestQueue) finish(ctx context.Context, job *model.IngestionJob) {
+	job.LockedAt = nil
+	//进程退出时也要把任务的结果写回去
+	err := q.repo.updateIngestionJob(context.WithoutCancel(ctx), job)
+	if err != nil {
+		logs.Errorf("update ingestion job error: %v", err)
+	}
+}
+
+func (q *ingestQueue) heartbeat(ctx context.Context, job *model.IngestionJob) {
+	ticker := time.NewTicker(q.config.StaleTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.repo.touchIngestionJob(ctx, job.ID); err != nil {
+				logs.Errorf("touch ingestion job error: %v", err)
+			}
+		}
+	}
+}
+
+// recoverLoop 启动时和之后定期恢复失效的任务
+func (q *ingestQueue) recoverLoop(ctx context.Context) {
+	q.recover(ctx)
+	ticker := time.NewTicker(q.config.StaleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.recover(ctx)
+		}
+	}
+}
+
+// recover 恢复两种情况：
+// 1. worker退出之后一直处于执行中的任务
+// 2. 停留在 pending/processing 但是没有任务的文档，比如队列上线之前上传的文档
+func (q *ingestQueue) recover(ctx context.Context) {
+	count, err := q.repo.requeueStaleIngestionJobs(ctx, time.Now().Add(-q.config.StaleTimeout))
+	if err != nil {
+		logs.Errorf("requeue stale ingestion jobs error: %v", err)
+	} else if count > 0 {
+		logs.Infof("requeue %d stale ingestion jobs", count)
+	}
+	docs, err := q.repo.listDocumentsWithoutJob(ctx, []model.DocumentStatus{
+		model.DocumentStatusPending,
+		model.DocumentStatusProcessing,
+	}, time.Now().Add(-time.Minute))
+	if err != nil {
+		logs.Errorf("list stuck documents error: %v", err)
+		return
+	}
+	for _, doc := range docs {
+		if err := q.enqueue(ctx, doc); err != nil {
+			logs.Errorf("enqueue document %s error: %v", doc.ID, err)
+		}
+	}
+}
+
+// backoff 第n次失败之后等待的时间
+func backoff(attempts int, base time.Duration, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return min(delay, maxDelay)
+}
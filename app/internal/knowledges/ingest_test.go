@@ -0,0 +1,27 @@
+package knowledges
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestInitConfig(t *testing.T) {
+	t.Cleanup(func() { ingestSettings = defaultIngestConfig })
+	v := viper.New()
+	v.SetConfigType("yml")
+	err := v.ReadConfig(strings.NewReader("knowledge:\n  ingest:\n    concurrency: 8\n    maxAttempts: 0\n    baseBackoff: 30s\n"))
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	InitConfig(v)
+	if ingestSettings.Concurrency != 8 || ingestSettings.BaseBackoff != 30*time.Second {
+		t.Fatalf("ingestSettings = %+v", ingestSettings)
+	}
+	//没有配置或者配置错误的项使用默认值
+	if ingestSettings.MaxAttempts != defaultIngestConfig.MaxAttempts || ingestSettings.MaxBackoff != defaultIngestConfig.MaxBackoff {
+		t.Fatalf("ingestSettings = %+v", ingestSettings)
+	}
+}
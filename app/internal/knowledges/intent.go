@@ -0,0 +1,118 @@
+package knowledges
+
+import (
+	"context"
+	"core/ai/kbs"
+	"model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/logs"
+)
+
+const (
+	metadataFieldsTTL       = 10 * time.Minute //知识库元数据字段的缓存时间
+	maxMetadataSamples      = 5                //每个字段提供给模型的示例取值数量
+	maxMetadataSampleChunks = 1000             //统计元数据字段时最多读取的子分段数量
+)
+
+// metadataFieldsCache 缓存知识库中的元数据字段，不需要每次检索都统计一次
+var metadataFieldsCache sync.Map
+
+type cachedMetadataFields struct {
+	fields  []kbs.MetadataField
+	expires time.Time
+}
+
+// extractQueryIntent 按知识库配置的方式提取问题意图，提取失败时使用原始问题检索
+func (s *service) extractQueryIntent(ctx context.Context, kb *model.KnowledgeBase, query string) *kbs.QueryIntent {
+	extractor, err := s.newIntentExtractor(ctx, kb)
+	if err == nil {
+		var intent *kbs.QueryIntent
+		intent, err = extractor.Extract(ctx, query)
+		if err == nil {
+			return intent
+		}
+	}
+	logs.Errorf("extract query intent error: %v", err)
+	intent, _ := kbs.NoopIntentExtractor{}.Extract(ctx, query)
+	return intent
+}
+
+func (s *service) newIntentExtractor(ctx context.Context, kb *model.KnowledgeBase) (kbs.QueryIntentExtractor, error) {
+	name := kb.RetrievalConfig.IntentExtractor
+	if !kbs.NeedsChatModel(name) {
+		return kbs.NoopIntentExtractor{}, nil
+	}
+	chatModel, err := s.getChatModel(kb.ChatModelName, kb.ChatModelProvider)
+	if err != nil {
+		return nil, err
+	}
+	if name == kbs.IntentExtractorNovel {
+		return kbs.NewNovelIntentExtractor(chatModel), nil
+	}
+	fields, err := s.metadataFields(ctx, kb.ID)
+	if err != nil {
+		return nil, err
+	}
+	return kbs.NewMetadataIntentExtractor(chatModel, fields), nil
+}
+
+// metadataFields 统计知识库子分段中的元数据字段和部分取值
+func (s *service) metadataFields(ctx context.Context, kbId uuid.UUID) ([]kbs.MetadataField, error) {
+	if cached, ok := metadataFieldsCache.Load(kbId); ok && time.Now().Before(cached.(*cachedMetadataFields).expires) {
+		return cached.(*cachedMetadataFields).fields, nil
+	}
+	keys, err := s.repo.listMetadataKeys(ctx, kbId, maxMetadataSampleChunks)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]kbs.MetadataField, 0, len(keys))
+	for _, key := range keys {
+		samples := strings.Split(key.Samples, "|")
+		if len(samples) > maxMetadataSamples {
+			samples = samples[:maxMetadataSamples]
+		}
+		fields = append(fields, kbs.MetadataField{Key: key.Key, Samples: samples})
+	}
+	metadataFieldsCache.Store(kbId, &cachedMetadataFields{
+		fields:  fields,
+		expires: time.Now().Add(metadataFieldsTTL),
+	})
+	return fields, nil
+}
+
+// queryModeNone 请求中使用这个值表示不改写，覆盖知识库的配置
+const queryModeNone = "none"
+
+func validQueryMode(mode string) bool {
+	switch mode {
+	case kbs.QueryModeNone, queryModeNone, kbs.QueryModeMultiQuery, kbs.QueryModeHyDE:
+		return true
+	}
+	return false
+}
+
+// expandQuery 按改写方式生成检索文本，失败时只使用原始问题
+func (s *service) expandQuery(ctx context.Context, kb *model.KnowledgeBase, mode string, query string) []string {
+	if mode != kbs.QueryModeMultiQuery && mode != kbs.QueryModeHyDE {
+		return []string{query}
+	}
+	chatModel, err := s.getChatModel(kb.ChatModelName, kb.ChatModelProvider)
+	if err != nil {
+		logs.Errorf("get chat model error: %v", err)
+		return []string{query}
+	}
+	var expander kbs.QueryExpander = kbs.NewMultiQueryExpander(chatModel, 0)
+	if mode == kbs.QueryModeHyDE {
+		expander = kbs.NewHyDEExpander(chatModel)
+	}
+	queries, err := expander.Expand(ctx, query)
+	if err != nil || len(queries) == 0 {
+		logs.Errorf("expand query error: %v", err)
+		return []string{query}
+	}
+	return queries
+}
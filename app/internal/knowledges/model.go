@@ -3,6 +3,7 @@ package knowledges
 import (
 	"context"
 	"model"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mszlu521/thunder/gorms"
@@ -15,7 +16,11 @@ type models struct {
 
 func (m *models) getDocumentChunksByIds(ctx context.Context, ids []string) ([]*model.DocumentChunk, error) {
 	var documentChunks []*model.DocumentChunk
-	err := m.db.WithContext(ctx).Where("id in ?", ids).Find(&documentChunks).Error
+	//只返回处理完成并且启用的文档的分段，正在处理、替换中或者禁用的文档不能被检索到
+	err := m.db.WithContext(ctx).
+		Joins("join documents d on d.id = document_chunks.document_id and d.status = ? and d.enabled = true and d.deleted_at is null", model.DocumentStatusCompleted).
+		Where("document_chunks.id in ? and document_chunks.status = ?", ids, model.ChunkStatusEmbedded).
+		Find(&documentChunks).Error
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +74,10 @@ func (m *models) createDocument(ctx context.Context, doc *model.Document) error
 	return m.db.WithContext(ctx).Create(doc).Error
 }
 
+func (m *models) updateDocumentEnabled(ctx context.Context, id uuid.UUID, enabled bool) error {
+	return m.db.WithContext(ctx).Model(&model.Document{}).Where("id = ?", id).Update("enabled", enabled).Error
+}
+
 func (m *models) updateDocumentStatus(ctx context.Context, id uuid.UUID, status model.DocumentStatus) error {
 	return m.db.WithContext(ctx).Model(&model.Document{}).Where("id = ?", id).Update("status", status).Error
 }
@@ -144,8 +153,294 @@ func (m *models) createKnowledgeBase(ctx context.Context, kb *model.KnowledgeBas
 	return m.db.WithContext(ctx).Create(kb).Error
 }
 
+func (m *models) getDocumentById(ctx context.Context, id uuid.UUID) (*model.Document, error) {
+	var doc model.Document
+	err := m.db.WithContext(ctx).Where("id = ?", id).First(&doc).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &doc, err
+}
+
+// getDocumentsByIds 批量查询文档，检索结果中需要展示文档名称
+func (m *models) getDocumentsByIds(ctx context.Context, ids []uuid.UUID) ([]*model.Document, error) {
+	var docs []*model.Document
+	err := m.db.WithContext(ctx).Where("id in ?", ids).Find(&docs).Error
+	return docs, err
+}
+
+// getDocumentByHash 查询知识库中内容相同的最新的文档
+func (m *models) getDocumentByHash(ctx context.Context, kbId uuid.UUID, fileHash string) (*model.Document, error) {
+	var doc model.Document
+	err := m.db.WithContext(ctx).Where("kb_id = ? and file_hash = ?", kbId, fileHash).Order("created_at desc").First(&doc).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &doc, err
+}
+
+// countDocumentsByStorageKey 内容相同的文件共用一个对象，删除对象之前需要确认没有文档在使用
+func (m *models) countDocumentsByStorageKey(ctx context.Context, storageKey string) (int64, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&model.Document{}).Where("storage_key = ?", storageKey).Count(&count).Error
+	return count, err
+}
+
+func (m *models) completeDocument(ctx context.Context, tx *gorm.DB, id uuid.UUID) error {
+	if tx == nil {
+		tx = m.db
+	}
+	return tx.WithContext(ctx).Model(&model.Document{}).Where("id = ?", id).Updates(map[string]any{
+		"status":        model.DocumentStatusCompleted,
+		"error_message": "",
+	}).Error
+}
+
+func (m *models) updateDocumentResult(ctx context.Context, id uuid.UUID, status model.DocumentStatus, errMsg string) error {
+	return m.db.WithContext(ctx).Model(&model.Document{}).Where("id = ?", id).Updates(map[string]any{
+		"status":        status,
+		"error_message": errMsg,
+	}).Error
+}
+
+// listDocumentsWithoutJob 查询处于某些状态但是没有待执行任务的文档，before 之后创建的文档可能还没来得及创建任务
+func (m *models) listDocumentsWithoutJob(ctx context.Context, statuses []model.DocumentStatus, before time.Time) ([]*model.Document, error) {
+	var documents []*model.Document
+	err := m.db.WithContext(ctx).
+		Where("status in ? and created_at < ?", statuses, before).
+		Where("not exists (select 1 from ingestion_jobs j where j.document_id = documents.id and j.status in ? and j.deleted_at is null)",
+			[]model.IngestionJobStatus{model.IngestionJobPending, model.IngestionJobRunning}).
+		Find(&documents).Error
+	return documents, err
+}
+
+func (m *models) createIngestionJob(ctx context.Context, job *model.IngestionJob) error {
+	return m.db.WithContext(ctx).Create(job).Error
+}
+
+// claimIngestionJob 领取一个到期的任务，SKIP LOCKED 保证多个worker(多个实例)不会领取到同一个任务
+func (m *models) claimIngestionJob(ctx context.Context) (*model.IngestionJob, error) {
+	var jobs []*model.IngestionJob
+	err := m.db.WithContext(ctx).Raw(`UPDATE ingestion_jobs SET status = ?, attempts = attempts + 1, locked_at = now(), updated_at = now()
+WHERE id = (
+	SELECT id FROM ingestion_jobs
+	WHERE status = ? AND next_run_at <= now() AND deleted_at IS NULL
+	ORDER BY next_run_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`, model.IngestionJobRunning, model.IngestionJobPending).Scan(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return jobs[0], nil
+}
+
+func (m *models) touchIngestionJob(ctx context.Context, id uuid.UUID) error {
+	return m.db.WithContext(ctx).Model(&model.IngestionJob{}).Where("id = ?", id).Update("locked_at", time.Now()).Error
+}
+
+func (m *models) updateIngestionJob(ctx context.Context, job *model.IngestionJob) error {
+	return m.db.WithContext(ctx).Model(job).Select("status", "error", "next_run_at", "locked_at").Updates(job).Error
+}
+
+// requeueStaleIngestionJobs 把长时间没有刷新的执行中任务重新放回队列
+func (m *models) requeueStaleIngestionJobs(ctx context.Context, before time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Model(&model.IngestionJob{}).
+		Where("status = ? and locked_at < ?", model.IngestionJobRunning, before).
+		Updates(map[string]any{
+			"status":      model.IngestionJobPending,
+			"locked_at":   nil,
+			"next_run_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (m *models) countChildChunks(ctx context.Context, kbId uuid.UUID) (int64, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&model.DocumentChunk{}).
+		Where("kb_id = ? and parent_id is not null and status = ?", kbId, model.ChunkStatusEmbedded).
+		Count(&count).Error
+	return count, err
+}
+
+// listChildChunks 按创建时间分页查询子分段，after 是上一页的最后一条，处理过程中新写入的子分段也能查询到
+func (m *models) listChildChunks(ctx context.Context, kbId uuid.UUID, after *model.DocumentChunk, limit int) ([]*model.DocumentChunk, error) {
+	var chunks []*model.DocumentChunk
+	db := m.db.WithContext(ctx).
+		Where("kb_id = ? and parent_id is not null and status = ?", kbId, model.ChunkStatusEmbedded)
+	if after != nil {
+		db = db.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+	err := db.Order("created_at, id").Limit(limit).Find(&chunks).Error
+	return chunks, err
+}
+
+// listDocumentsWithoutChildren 查询有父分段但是没有保存子分段的文档，这些文档在保存子分段之前入库
+func (m *models) listDocumentsWithoutChildren(ctx context.Context, kbId uuid.UUID) ([]*model.Document, error) {
+	var documents []*model.Document
+	err := m.db.WithContext(ctx).
+		Where("kb_id = ? and status = ?", kbId, model.DocumentStatusCompleted).
+		Where("exists (select 1 from document_chunks c where c.document_id = documents.id and c.parent_id is null and c.deleted_at is null)").
+		Where("not exists (select 1 from document_chunks c where c.document_id = documents.id and c.parent_id is not null and c.deleted_at is null)").
+		Find(&documents).Error
+	return documents, err
+}
+
+func (m *models) createReindexJob(ctx context.Context, job *model.ReindexJob) error {
+	return m.db.WithContext(ctx).Create(job).Error
+}
+
+func (m *models) updateReindexJob(ctx context.Context, tx *gorm.DB, job *model.ReindexJob) error {
+	if tx == nil {
+		tx = m.db
+	}
+	return tx.WithContext(ctx).Model(job).
+		Select("status", "total_chunks", "processed_chunks", "locked_at", "finished_at", "error").
+		Updates(job).Error
+}
+
+func (m *models) getLatestReindexJob(ctx context.Context, kbId uuid.UUID) (*model.ReindexJob, error) {
+	var job model.ReindexJob
+	err := m.db.WithContext(ctx).Where("kb_id = ?", kbId).Order("created_at desc").First(&job).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &job, err
+}
+
+// claimStaleReindexJobs 领取长时间没有刷新的执行中任务，刷新锁定时间之后其他实例不会重复领取
+func (m *models) claimStaleReindexJobs(ctx context.Context, before time.Time) ([]*model.ReindexJob, error) {
+	var jobs []*model.ReindexJob
+	err := m.db.WithContext(ctx).Raw(`UPDATE reindex_jobs SET locked_at = now(), updated_at = now()
+WHERE id IN (
+	SELECT id FROM reindex_jobs
+	WHERE status = ? AND (locked_at IS NULL OR locked_at < ?) AND deleted_at IS NULL
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`, model.ReindexJobRunning, before).Scan(&jobs).Error
+	return jobs, err
+}
+
+// switchVectorIndex 知识库切换到任务的新索引和新的向量模型
+func (m *models) switchVectorIndex(ctx context.Context, tx *gorm.DB, job *model.ReindexJob) error {
+	if tx == nil {
+		tx = m.db
+	}
+	return tx.WithContext(ctx).Model(&model.KnowledgeBase{}).Where("id = ?", job.KnowledgeBaseID).Updates(map[string]any{
+		"vector_index":             job.TargetIndex,
+		"embedding_model_name":     job.EmbeddingModelName,
+		"embedding_model_provider": job.EmbeddingModelProvider,
+		"embedding_dimension":      job.EmbeddingDimension,
+	}).Error
+}
+
+func (m *models) updateEmbeddingDimension(ctx context.Context, id uuid.UUID, dimension int) error {
+	return m.db.WithContext(ctx).Model(&model.KnowledgeBase{}).Where("id = ?", id).Update("embedding_dimension", dimension).Error
+}
+
 func newModels(db *gorm.DB) *models {
 	return &models{
 		db: db,
 	}
 }
+
+type ChunkFilter struct {
+	Limit  int
+	Offset int
+	Status string
+}
+
+// listDocumentChunks 按顺序查询文档的父分段
+func (m *models) listDocumentChunks(ctx context.Context, documentId uuid.UUID, filter ChunkFilter) ([]*model.DocumentChunk, int64, error) {
+	var chunks []*model.DocumentChunk
+	var count int64
+	query := m.db.WithContext(ctx).Model(&model.DocumentChunk{}).
+		Where("document_id = ? and parent_id is null", documentId)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	query = query.Count(&count)
+	query = query.Order("chunk_index, created_at").Limit(filter.Limit).Offset(filter.Offset)
+	return chunks, count, query.Find(&chunks).Error
+}
+
+func (m *models) getDocumentChunk(ctx context.Context, documentId uuid.UUID, id uuid.UUID) (*model.DocumentChunk, error) {
+	var chunk model.DocumentChunk
+	err := m.db.WithContext(ctx).Where("id = ? and document_id = ?", id, documentId).First(&chunk).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &chunk, err
+}
+
+func (m *models) listChunkChildren(ctx context.Context, parentId uuid.UUID) ([]*model.DocumentChunk, error) {
+	var chunks []*model.DocumentChunk
+	err := m.db.WithContext(ctx).Where("parent_id = ?", parentId).Order("chunk_index").Find(&chunks).Error
+	return chunks, err
+}
+
+// replaceChunkChildren 保存修改后的父分段，同时用新的子分段替换旧的子分段
+func (m *models) replaceChunkChildren(ctx context.Context, parent *model.DocumentChunk, children []*model.DocumentChunk) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(parent).
+			Select("content", "token_count", "meta_info", "status").
+			Updates(parent).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("parent_id = ?", parent.ID).Unscoped().Delete(&model.DocumentChunk{}).Error
+		if err != nil {
+			return err
+		}
+		if len(children) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(children, len(children)).Error
+	})
+}
+
+// updateChunkStatus 修改父分段和它的子分段的状态
+func (m *models) updateChunkStatus(ctx context.Context, id uuid.UUID, status model.ChunkStatus) error {
+	return m.db.WithContext(ctx).Model(&model.DocumentChunk{}).
+		Where("id = ? or parent_id = ?", id, id).
+		Update("status", status).Error
+}
+
+// nextChunkIndex 文档中父分段的下一个序号，手动添加的分段放在最后
+func (m *models) nextChunkIndex(ctx context.Context, documentId uuid.UUID) (int, error) {
+	var index int
+	err := m.db.WithContext(ctx).Model(&model.DocumentChunk{}).
+		Where("document_id = ? and parent_id is null", documentId).
+		Select("coalesce(max(chunk_index), -1) + 1").
+		Scan(&index).Error
+	return index, err
+}
+
+// MetadataKey 元数据字段和用 | 分隔的取值
+type MetadataKey struct {
+	Key     string
+	Samples string
+}
+
+// internalMetadataKeys 入库时内部使用的字段，不能作为检索的过滤条件
+var internalMetadataKeys = []string{"doc_id", "kb_id", "parent_id", "seq", "header", "question"}
+
+// listMetadataKeys 从最近的 limit 个子分段中统计元数据字段，只统计字符串、数字和布尔类型的值
+func (m *models) listMetadataKeys(ctx context.Context, kbId uuid.UUID, limit int) ([]*MetadataKey, error) {
+	var keys []*MetadataKey
+	err := m.db.WithContext(ctx).Raw(`SELECT e.key AS key, string_agg(DISTINCT left(e.value #>> '{}', 50), '|') AS samples
+FROM (
+	SELECT meta_info FROM document_chunks
+	WHERE kb_id = ? AND parent_id IS NOT NULL AND status = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	LIMIT ?
+) c, jsonb_each(c.meta_info) e
+WHERE jsonb_typeof(e.value) IN ('string', 'number', 'boolean') AND e.key NOT IN ?
+GROUP BY e.key
+ORDER BY e.key`, kbId, model.ChunkStatusEmbedded, limit, internalMetadataKeys).Scan(&keys).Error
+	return keys, err
+}
@@ -0,0 +1,134 @@
+package knowledges
+
+import (
+	"common/biz"
+	"common/utils"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"model"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/errs"
+	"github.com/mszlu521/thunder/logs"
+)
+
+// defaultEmbeddingPrice 不认识的向量模型按这个价格估算，单位 美元/1K tokens
+const defaultEmbeddingPrice = 0.0001
+
+// embeddingPrices 常用向量模型的价格，单位 美元/1K tokens，只用于预估费用，本地部署的模型不收费
+var embeddingPrices = map[string]float64{
+	"text-embedding-3-small": 0.00002,
+	"text-embedding-3-large": 0.00013,
+	"text-embedding-ada-002": 0.0001,
+	"text-embedding-v1":      0.0001,
+	"text-embedding-v2":      0.0001,
+	"text-embedding-v3":      0.0001,
+	"embedding-2":            0.0007,
+	"embedding-3":            0.0007,
+}
+
+// previewDocument 按知识库的切分配置试切上传的文件，只返回切分结果和预估的向量化费用，不保存文件也不写入任何存储
+// config 不为空时使用传入的切分配置，方便在修改知识库配置之前先看效果
+func (s *service) previewDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, uploadFile *multipart.FileHeader, params previewReq) (*PreviewResponse, error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
+	if err != nil {
+		logs.Errorf("get knowledge base error: %v", err)
+		return nil, errs.DBError
+	}
+	if kb == nil || kb.CreatorID != userId {
+		return nil, biz.ErrKnowledgeBaseNotFound
+	}
+	if params.ChunkingConfig != nil {
+		if !validChunkingConfig(params.ChunkingConfig) {
+			return nil, biz.ErrChunkingConfig
+		}
+		//只修改本次预览使用的配置，不保存
+		preview := *kb
+		preview.ChunkingConfig = *params.ChunkingConfig
+		kb = &preview
+	}
+	ext := strings.ToLower(filepath.Ext(uploadFile.Filename))
+	src, err := uploadFile.Open()
+	if err != nil {
+		logs.Errorf("open file error: %v", err)
+		return nil, biz.FileLoadError
+	}
+	defer src.Close()
+	docs, err := s.parseFile(ctx, ext, src)
+	if err != nil {
+		logs.Errorf("parse file error: %v", err)
+		return nil, biz.FileLoadError
+	}
+	doc := &model.Document{
+		BaseModel: model.BaseModel{
+			ID: uuid.New(),
+		},
+		KnowledgeBaseID: kb.ID,
+		CreatorID:       userId,
+		Name:            uploadFile.Filename,
+		FileType:        ext,
+		Size:            uploadFile.Size,
+	}
+	parentModels, childSchemaDocs := s.splitDocument(doc, docs, kb)
+	//子分段挂到所属的父分段下面
+	parents := make([]*PreviewChunk, 0, len(parentModels))
+	parentMap := make(map[string]*PreviewChunk, len(parentModels))
+	resp := &PreviewResponse{
+		DocumentName:       doc.Name,
+		FileType:           ext,
+		ChunkingConfig:     kb.ChunkingConfig.WithDefaults(),
+		EmbeddingModelName: kb.EmbeddingModelName,
+		Currency:           "USD",
+	}
+	for _, parent := range parentModels {
+		chunk := &PreviewChunk{
+			Id:         parent.ID,
+			Index:      parent.ChunkIndex,
+			Content:    parent.Content,
+			Metadata:   parent.MetaInfo,
+			TokenCount: parent.TokenCount,
+		}
+		parents = append(parents, chunk)
+		parentMap[parent.ID.String()] = chunk
+		resp.TokenCount += parent.TokenCount
+	}
+	for i, child := range childSchemaDocs {
+		tokenCount := utils.GetTokenCount(child.Content)
+		chunk := &PreviewChunk{
+			Id:         uuid.MustParse(child.ID),
+			Index:      i,
+			Content:    child.Content,
+			Metadata:   child.MetaData,
+			TokenCount: tokenCount,
+		}
+		if parent, ok := parentMap[fmt.Sprint(child.MetaData["parent_id"])]; ok {
+			parent.Children = append(parent.Children, chunk)
+		}
+		resp.EmbeddingTokens += tokenCount
+	}
+	resp.Parents = parents
+	resp.ParentCount = len(parentModels)
+	resp.ChildCount = len(childSchemaDocs)
+	//向量化的是子分段，按子分段的 token 数估算费用
+	resp.PricePer1KTokens = params.PricePer1KTokens
+	if resp.PricePer1KTokens <= 0 {
+		resp.PricePer1KTokens = embeddingPrice(kb.EmbeddingModelName)
+	}
+	resp.EstimatedCost = float64(resp.EmbeddingTokens) / 1000 * resp.PricePer1KTokens
+	return resp, nil
+}
+
+// embeddingPrice 按模型名称查找价格，模型名称可能带有厂商的前缀
+func embeddingPrice(modelName string) float64 {
+	name := strings.ToLower(modelName)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if price, ok := embeddingPrices[name]; ok {
+		return price
+	}
+	return defaultEmbeddingPrice
+}
@@ -0,0 +1,95 @@
+package knowledges
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IngestStage 文档入库的阶段
+type IngestStage string
+
+const (
+	IngestStageQueued    IngestStage = "queued"    // 已经进入队列
+	IngestStageParsing   IngestStage = "parsing"   // 读取并解析文件
+	IngestStageChunking  IngestStage = "chunking"  // 切分父子分段
+	IngestStageStoring   IngestStage = "storing"   // 父分段写入数据库
+	IngestStageEmbedding IngestStage = "embedding" // 子分段分批向量化并写入向量库
+	IngestStageCompleted IngestStage = "completed"
+	IngestStageRetrying  IngestStage = "retrying"
+	IngestStageFailed    IngestStage = "failed"
+	// 重建索引的进度，没有对应的文档
+	IngestStageReindexing    IngestStage = "reindexing"
+	IngestStageReindexed     IngestStage = "reindexed"
+	IngestStageReindexFailed IngestStage = "reindex_failed"
+)
+
+// IngestProgress 文档入库的进度，通过SSE推送给前端
+type IngestProgress struct {
+	KbId         uuid.UUID   `json:"kbId"`
+	DocumentId   uuid.UUID   `json:"documentId"`
+	DocumentName string      `json:"documentName"`
+	Stage        IngestStage `json:"stage"`
+	// Batch 和 TotalBatches 是向量化的批次进度
+	Batch        int `json:"batch,omitempty"`
+	TotalBatches int `json:"totalBatches,omitempty"`
+	// ChunkCount 父分段数量，ChildChunkCount 子分段数量
+	ChunkCount      int    `json:"chunkCount,omitempty"`
+	ChildChunkCount int    `json:"childChunkCount,omitempty"`
+	TokenCount      int    `json:"tokenCount,omitempty"`
+	Attempt         int    `json:"attempt,omitempty"`
+	Error           string `json:"error,omitempty"`
+	Time            int64  `json:"time"`
+}
+
+// progressHub 按知识库分发入库进度，只在当前进程内分发
+// 订阅者处理不过来时丢弃消息，不能阻塞入库流程
+type progressHub struct {
+	mu          sync.RWMutex
+	subscribers map[uuid.UUID]map[chan *IngestProgress]struct{}
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{
+		subscribers: make(map[uuid.UUID]map[chan *IngestProgress]struct{}),
+	}
+}
+
+// subscribe 订阅某个知识库的入库进度，使用完之后需要调用返回的取消函数
+func (h *progressHub) subscribe(kbId uuid.UUID) (<-chan *IngestProgress, func()) {
+	ch := make(chan *IngestProgress, 64)
+	h.mu.Lock()
+	if h.subscribers[kbId] == nil {
+		h.subscribers[kbId] = make(map[chan *IngestProgress]struct{})
+	}
+	h.subscribers[kbId][ch] = struct{}{}
+	h.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[kbId], ch)
+			if len(h.subscribers[kbId]) == 0 {
+				delete(h.subscribers, kbId)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (h *progressHub) publish(progress *IngestProgress) {
+	if h == nil {
+		return
+	}
+	progress.Time = time.Now().UnixMilli()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subscribers[progress.KbId] {
+		select {
+		case ch <- progress:
+		default:
+		}
+	}
+}
@@ -9,7 +9,8 @@ import (
 )
 
 type PublicService struct {
-	repo repository
+	repo    repository
+	service *service
 }
 
 func (s *PublicService) GetKnowledgeBase(e event.Event) (any, error) {
@@ -20,18 +21,27 @@ func (s *PublicService) GetKnowledgeBase(e event.Event) (any, error) {
 
 func (s *PublicService) SearchKnowledgeBase(e event.Event) (any, error) {
 	request := e.Data.(*shared.SearchKnowledgeBaseRequest)
-	kbService := newService()
-	response, err := kbService.searchKnowledgeBase(context.Background(), request.UserId, request.KnowledgeBaseId, searchParams{
-		Query: request.Query,
+	response, err := s.service.searchKnowledgeBase(context.Background(), request.UserId, request.KnowledgeBaseId, searchParams{
+		Query:     request.Query,
+		QueryMode: request.QueryMode,
 	})
 	if err != nil {
 		return nil, err
 	}
 	var results []*shared.SearchKnowledgeBaseResult
 	for _, v := range response.Results {
-		results = append(results, &shared.SearchKnowledgeBaseResult{
-			Content: v.Content,
-		})
+		result := &shared.SearchKnowledgeBaseResult{
+			KnowledgeBaseId: request.KnowledgeBaseId,
+			DocumentId:      v.DocumentId,
+			ChunkId:         v.Id,
+			Content:         v.Content,
+			Score:           v.Score,
+			Metadata:        v.Metadata,
+		}
+		if v.Document != nil {
+			result.DocumentName = v.Document.Name
+		}
+		results = append(results, result)
 	}
 	return &shared.SearchKnowledgeBaseResponse{
 		Results: results,
@@ -40,6 +50,7 @@ func (s *PublicService) SearchKnowledgeBase(e event.Event) (any, error) {
 
 func NewPublicService() *PublicService {
 	return &PublicService{
-		repo: newModels(database.GetPostgresDB().GormDB),
+		repo:    newModels(database.GetPostgresDB().GormDB),
+		service: getService(),
 	}
 }
@@ -0,0 +1,328 @@
+package knowledges
+
+import (
+	"common/biz"
+	"context"
+	"errors"
+	"fmt"
+	"model"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/errs"
+	"github.com/mszlu521/thunder/logs"
+	"gorm.io/gorm"
+)
+
+// reindexStaleTimeout 执行中的重建任务超过这个时间没有刷新，认为进程已经退出，重新执行
+const reindexStaleTimeout = 5 * time.Minute
+
+// reindexKnowledgeBase 使用指定的向量模型重建知识库的向量索引，不指定时使用当前的模型
+func (s *service) reindexKnowledgeBase(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, req reindexReq) (*model.ReindexJob, error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
+	if err != nil {
+		logs.Errorf("get knowledge base error: %v", err)
+		return nil, errs.DBError
+	}
+	if kb == nil || kb.CreatorID != userId {
+		return nil, biz.ErrKnowledgeBaseNotFound
+	}
+	provider := kb.EmbeddingModelProvider
+	if req.EmbeddingModelProvider != "" {
+		provider = req.EmbeddingModelProvider
+	}
+	modelName := kb.EmbeddingModelName
+	if req.EmbeddingModelName != "" {
+		modelName = req.EmbeddingModelName
+	}
+	return s.startReindex(ctx, kb, provider, modelName)
+}
+
+func (s *service) getReindexJob(ctx context.Context, userId uuid.UUID, kbId uuid.UUID) (*model.ReindexJob, error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
+	if err != nil {
+		logs.Errorf("get knowledge base error: %v", err)
+		return nil, errs.DBError
+	}
+	if kb == nil || kb.CreatorID != userId {
+		return nil, biz.ErrKnowledgeBaseNotFound
+	}
+	job, err := s.repo.getLatestReindexJob(ctx, kb.ID)
+	if err != nil {
+		logs.Errorf("get reindex job error: %v", err)
+		return nil, errs.DBError
+	}
+	return job, nil
+}
+
+// startReindex 创建重建任务并在后台执行，同一个知识库同时只能有一个重建任务
+func (s *service) startReindex(ctx context.Context, kb *model.KnowledgeBase, provider string, modelName string) (*model.ReindexJob, error) {
+	//先确认新的向量模型可以使用，新的索引按新模型的维度创建
+	dimension, err := s.probeDimension(ctx, provider, modelName, kb.CreatorID)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := s.repo.getLatestReindexJob(ctx, kb.ID)
+	if err != nil {
+		logs.Errorf("get reindex job error: %v", err)
+		return nil, errs.DBError
+	}
+	if latest != nil && latest.Status == model.ReindexJobRunning {
+		return nil, biz.ErrReindexRunning
+	}
+	now := time.Now()
+	job := &model.ReindexJob{
+		BaseModel: model.BaseModel{
+			ID: uuid.New(),
+		},
+		KnowledgeBaseID:        kb.ID,
+		CreatorID:              kb.CreatorID,
+		EmbeddingModelName:     modelName,
+		EmbeddingModelProvider: provider,
+		EmbeddingDimension:     dimension,
+		SourceIndex:            kb.VectorIndex,
+		//新索引的名称带上时间，和正在使用的索引区分开
+		TargetIndex: fmt.Sprintf("%s_r%d", s.baseIndex(kb), now.Unix()),
+		Status:      model.ReindexJobRunning,
+		LockedAt:    &now,
+	}
+	err = s.repo.createReindexJob(ctx, job)
+	if err != nil {
+		logs.Errorf("create reindex job error: %v", err)
+		return nil, errs.DBError
+	}
+	s.runReindexAsync(job)
+	return job, nil
+}
+
+func (s *service) runReindexAsync(job *model.ReindexJob) {
+	s.reindexWg.Add(1)
+	go func() {
+		defer s.reindexWg.Done()
+		s.runReindex(s.reindexCtx, job)
+	}()
+}
+
+func (s *service) runReindex(ctx context.Context, job *model.ReindexJob) {
+	err := s.reindex(ctx, job)
+	if err == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		//进程退出，释放任务，重启之后重新执行
+		job.LockedAt = nil
+		if err := s.repo.updateReindexJob(context.WithoutCancel(ctx), nil, job); err != nil {
+			logs.Errorf("update reindex job error: %v", err)
+		}
+		return
+	}
+	logs.Errorf("reindex knowledge base %s error: %v", job.KnowledgeBaseID, err)
+	now := time.Now()
+	job.Status = model.ReindexJobFailed
+	job.Error = err.Error()
+	job.LockedAt = nil
+	job.FinishedAt = &now
+	if err := s.repo.updateReindexJob(ctx, nil, job); err != nil {
+		logs.Errorf("update reindex job error: %v", err)
+	}
+	s.progress.publish(&IngestProgress{
+		KbId:  job.KnowledgeBaseID,
+		Stage: IngestStageReindexFailed,
+		Error: err.Error(),
+	})
+}
+
+// reindex 把数据库中保存的子分段使用新的向量模型写入新的索引，全部写完之后再切换
+// 切换之前检索一直使用旧的索引，切换在一个事务中完成
+func (s *service) reindex(ctx context.Context, job *model.ReindexJob) (err error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, job.CreatorID, job.KnowledgeBaseID)
+	if err != nil {
+		return err
+	}
+	if kb == nil {
+		return biz.ErrKnowledgeBaseNotFound
+	}
+	target, err := s.openVectorStore(ctx, kb, job.TargetIndex, job.EmbeddingModelProvider, job.EmbeddingModelName, job.EmbeddingDimension)
+	if err != nil {
+		return err
+	}
+	//重新执行时先清空上一次写入了一部分的索引，清空之后需要重新创建
+	err = target.DropIndex(ctx)
+	if err != nil {
+		return err
+	}
+	target, err = s.openVectorStore(ctx, kb, job.TargetIndex, job.EmbeddingModelProvider, job.EmbeddingModelName, job.EmbeddingDimension)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		//失败时删除写了一部分的新索引，进程退出时留给下一次执行清理
+		if err != nil && ctx.Err() == nil {
+			if dropErr := target.DropIndex(context.WithoutCancel(ctx)); dropErr != nil {
+				logs.Errorf("drop reindex target error: %v", dropErr)
+			}
+		}
+	}()
+	//之前入库的文档没有保存子分段，原始文件也已经删除，先从父分段切分出子分段，和其他子分段一起写入新的索引
+	err = s.backfillChildren(ctx, kb)
+	if err != nil {
+		return err
+	}
+	total, err := s.repo.countChildChunks(ctx, kb.ID)
+	if err != nil {
+		return err
+	}
+	job.TotalChunks = int(total)
+	job.ProcessedChunks = 0
+	err = s.repo.updateReindexJob(ctx, nil, job)
+	if err != nil {
+		return err
+	}
+	totalBatches := (job.TotalChunks + embeddingBatchSize - 1) / embeddingBatchSize
+	var last *model.DocumentChunk
+	for batch := 1; ; batch++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		chunks, err := s.repo.listChildChunks(ctx, kb.ID, last, embeddingBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			break
+		}
+		docs := make([]*schema.Document, 0, len(chunks))
+		for _, chunk := range chunks {
+			metadata := make(map[string]any, len(chunk.MetaInfo))
+			for k, v := range chunk.MetaInfo {
+				metadata[k] = v
+			}
+			docs = append(docs, &schema.Document{
+				ID:       chunk.ID.String(),
+				Content:  chunk.Content,
+				MetaData: metadata,
+			})
+		}
+		err = target.Store(ctx, docs)
+		if err != nil {
+			return err
+		}
+		last = chunks[len(chunks)-1]
+		now := time.Now()
+		job.ProcessedChunks += len(chunks)
+		//重建过程中新上传的文档也会被处理，总数可能变多
+		job.TotalChunks = max(job.TotalChunks, job.ProcessedChunks)
+		job.LockedAt = &now
+		err = s.repo.updateReindexJob(ctx, nil, job)
+		if err != nil {
+			return err
+		}
+		s.progress.publish(&IngestProgress{
+			KbId:            kb.ID,
+			Stage:           IngestStageReindexing,
+			Batch:           batch,
+			TotalBatches:    max(totalBatches, batch),
+			ChildChunkCount: job.TotalChunks,
+		})
+	}
+	//还有没有子分段的文档时不能切换，否则这些文档在新的索引中检索不到
+	missing, err := s.repo.listDocumentsWithoutChildren(ctx, kb.ID)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d documents have no child chunks", len(missing))
+	}
+	now := time.Now()
+	job.Status = model.ReindexJobSucceeded
+	job.LockedAt = nil
+	job.FinishedAt = &now
+	err = s.repo.transaction(ctx, func(tx *gorm.DB) error {
+		err := s.repo.switchVectorIndex(ctx, tx, job)
+		if err != nil {
+			return err
+		}
+		return s.repo.updateReindexJob(ctx, tx, job)
+	})
+	if err != nil {
+		return err
+	}
+	s.cleanupAfterReindex(ctx, kb, job)
+	s.progress.publish(&IngestProgress{
+		KbId:            kb.ID,
+		Stage:           IngestStageReindexed,
+		ChildChunkCount: job.ProcessedChunks,
+	})
+	return nil
+}
+
+// backfillChildren 给没有保存子分段的文档补上子分段，按知识库的切分配置重新切分数据库中保存的父分段
+// 一个文档的子分段一次写入，中途失败时文档还是没有子分段，下一次重建时重新处理
+func (s *service) backfillChildren(ctx context.Context, kb *model.KnowledgeBase) error {
+	docs, err := s.repo.listDocumentsWithoutChildren(ctx, kb.ID)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var children []*model.DocumentChunk
+		for offset := 0; ; offset += embeddingBatchSize {
+			parents, _, err := s.repo.listDocumentChunks(ctx, doc.ID, ChunkFilter{Limit: embeddingBatchSize, Offset: offset})
+			if err != nil {
+				return err
+			}
+			for _, parent := range parents {
+				for _, child := range s.buildChildChunks(doc, kb, s.splitChunkChildren(kb, doc, parent), 0) {
+					//禁用的分段补上的子分段也是禁用的，不写入新的索引
+					child.Status = parent.Status
+					children = append(children, child)
+				}
+			}
+			if len(parents) < embeddingBatchSize {
+				break
+			}
+		}
+		if len(children) == 0 {
+			continue
+		}
+		err = s.repo.createDocumentChunks(ctx, children)
+		if err != nil {
+			return err
+		}
+		logs.Infof("backfill %d child chunks for document %s", len(children), doc.ID)
+	}
+	return nil
+}
+
+// cleanupAfterReindex 切换完成之后删除旧的索引，失败只记录日志
+func (s *service) cleanupAfterReindex(ctx context.Context, kb *model.KnowledgeBase, job *model.ReindexJob) {
+	ctx = context.WithoutCancel(ctx)
+	//kb 还是切换之前的配置，对应的是旧的索引
+	old, err := s.openVectorStore(ctx, kb, job.SourceIndex, kb.EmbeddingModelProvider, kb.EmbeddingModelName, kb.EmbeddingDimension)
+	if err != nil {
+		logs.Errorf("open old vector store error: %v", err)
+	} else if err := old.DropIndex(ctx); err != nil {
+		logs.Errorf("drop old vector index error: %v", err)
+	}
+}
+
+// recoverReindexLoop 启动时和之后定期恢复中断的重建任务
+func (s *service) recoverReindexLoop(ctx context.Context) {
+	ticker := time.NewTicker(reindexStaleTimeout)
+	defer ticker.Stop()
+	for {
+		jobs, err := s.repo.claimStaleReindexJobs(ctx, time.Now().Add(-reindexStaleTimeout))
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logs.Errorf("claim stale reindex jobs error: %v", err)
+		}
+		for _, job := range jobs {
+			logs.Infof("resume reindex job %s", job.ID)
+			s.runReindexAsync(job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
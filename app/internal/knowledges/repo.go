@@ -3,6 +3,7 @@ package knowledges
 import (
 	"context"
 	"model"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -18,10 +19,39 @@ type repository interface {
 	listDocuments(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, filter DocumentFilter) ([]*model.Document, int64, error)
 	createDocument(ctx context.Context, doc *model.Document) error
 	updateDocumentStatus(ctx context.Context, id uuid.UUID, status model.DocumentStatus) error
+	updateDocumentEnabled(ctx context.Context, id uuid.UUID, enabled bool) error
 	createDocumentChunks(ctx context.Context, chunks []*model.DocumentChunk) error
 	getDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) (*model.Document, error)
 	transaction(ctx context.Context, f func(tx *gorm.DB) error) error
 	deleteDocuments(ctx context.Context, tx *gorm.DB, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) error
 	deleteDocumentChunks(ctx context.Context, tx *gorm.DB, kbId uuid.UUID, documentId uuid.UUID) error
 	getDocumentChunksByIds(ctx context.Context, ids []string) ([]*model.DocumentChunk, error)
+	getDocumentById(ctx context.Context, id uuid.UUID) (*model.Document, error)
+	getDocumentsByIds(ctx context.Context, ids []uuid.UUID) ([]*model.Document, error)
+	getDocumentByHash(ctx context.Context, kbId uuid.UUID, fileHash string) (*model.Document, error)
+	countDocumentsByStorageKey(ctx context.Context, storageKey string) (int64, error)
+	completeDocument(ctx context.Context, tx *gorm.DB, id uuid.UUID) error
+	updateDocumentResult(ctx context.Context, id uuid.UUID, status model.DocumentStatus, errMsg string) error
+	listDocumentsWithoutJob(ctx context.Context, statuses []model.DocumentStatus, before time.Time) ([]*model.Document, error)
+	createIngestionJob(ctx context.Context, job *model.IngestionJob) error
+	claimIngestionJob(ctx context.Context) (*model.IngestionJob, error)
+	touchIngestionJob(ctx context.Context, id uuid.UUID) error
+	updateIngestionJob(ctx context.Context, job *model.IngestionJob) error
+	requeueStaleIngestionJobs(ctx context.Context, before time.Time) (int64, error)
+	countChildChunks(ctx context.Context, kbId uuid.UUID) (int64, error)
+	listChildChunks(ctx context.Context, kbId uuid.UUID, after *model.DocumentChunk, limit int) ([]*model.DocumentChunk, error)
+	listDocumentsWithoutChildren(ctx context.Context, kbId uuid.UUID) ([]*model.Document, error)
+	createReindexJob(ctx context.Context, job *model.ReindexJob) error
+	updateReindexJob(ctx context.Context, tx *gorm.DB, job *model.ReindexJob) error
+	getLatestReindexJob(ctx context.Context, kbId uuid.UUID) (*model.ReindexJob, error)
+	claimStaleReindexJobs(ctx context.Context, before time.Time) ([]*model.ReindexJob, error)
+	switchVectorIndex(ctx context.Context, tx *gorm.DB, job *model.ReindexJob) error
+	updateEmbeddingDimension(ctx context.Context, id uuid.UUID, dimension int) error
+	listDocumentChunks(ctx context.Context, documentId uuid.UUID, filter ChunkFilter) ([]*model.DocumentChunk, int64, error)
+	getDocumentChunk(ctx context.Context, documentId uuid.UUID, id uuid.UUID) (*model.DocumentChunk, error)
+	listChunkChildren(ctx context.Context, parentId uuid.UUID) ([]*model.DocumentChunk, error)
+	replaceChunkChildren(ctx context.Context, parent *model.DocumentChunk, children []*model.DocumentChunk) error
+	updateChunkStatus(ctx context.Context, id uuid.UUID, status model.ChunkStatus) error
+	nextChunkIndex(ctx context.Context, documentId uuid.UUID) (int, error)
+	listMetadataKeys(ctx context.Context, kbId uuid.UUID, limit int) ([]*MetadataKey, error)
 }
@@ -1,5 +1,7 @@
 package knowledges
 
+import "model"
+
 type createKnowledgeBaseReq struct {
 	Name                   string   `json:"name"`
 	Description            string   `json:"description"`
@@ -8,6 +10,14 @@ type createKnowledgeBaseReq struct {
 	ChatModelName          string   `json:"chatModelName"`
 	ChatModelProvider      string   `json:"chatModelProvider"`
 	Tags                   []string `json:"tags"`
+	// StorageType 向量存储类型 es/milvus/pgvector/local，创建之后不能修改
+	StorageType model.StorageType `json:"storageType"`
+	// StorageConfig 存储配置，比如 es 的 {"analyzer": "ik_smart"}，索引名称由知识库ID生成，不能指定
+	StorageConfig model.JSON `json:"storageConfig"`
+	// RetrievalConfig 检索配置，不传时只使用向量检索
+	RetrievalConfig *model.RetrievalConfig `json:"retrievalConfig"`
+	// ChunkingConfig 切分配置，不传时按固定长度切分
+	ChunkingConfig *model.ChunkingConfig `json:"chunkingConfig"`
 }
 type updateKnowledgeBaseReq struct {
 	Name                   string   `json:"name"`
@@ -17,6 +27,10 @@ type updateKnowledgeBaseReq struct {
 	ChatModelName          string   `json:"chatModelName"`
 	ChatModelProvider      string   `json:"chatModelProvider"`
 	Tags                   []string `json:"tags"`
+	// RetrievalConfig 不为空时替换知识库的检索配置
+	RetrievalConfig *model.RetrievalConfig `json:"retrievalConfig"`
+	// ChunkingConfig 不为空时替换知识库的切分配置，已经入库的文档不会重新切分
+	ChunkingConfig *model.ChunkingConfig `json:"chunkingConfig"`
 }
 type listReq struct {
 	Page     int    `json:"page"`
@@ -29,6 +43,8 @@ type searchReq struct {
 
 type searchParams struct {
 	Query string `json:"query"`
+	// QueryMode 问题改写方式 multi_query/hyde，为空时使用知识库的配置，none 表示不改写
+	QueryMode string `json:"queryMode"`
 }
 type listDocumentReq struct {
 	Page      int    `json:"page" form:"page"`
@@ -38,3 +54,47 @@ type listDocumentReq struct {
 	Status    string `json:"status" form:"status"`
 	SortOrder string `json:"sortOrder" form:"sortOrder"`
 }
+
+// reindexReq 重建索引使用的向量模型，不传时使用知识库当前的模型
+type reindexReq struct {
+	EmbeddingModelName     string `json:"embeddingModelName"`
+	EmbeddingModelProvider string `json:"embeddingModelProvider"`
+}
+
+// previewReq 预览切分结果的参数，和文件一起以表单的形式提交
+type previewReq struct {
+	// ChunkingConfig 不为空时使用这个切分配置预览，不修改知识库的配置
+	ChunkingConfig *model.ChunkingConfig `json:"chunkingConfig"`
+	// PricePer1KTokens 向量模型的价格(美元/1K tokens)，不传时按模型名称估算
+	PricePer1KTokens float64 `json:"pricePer1kTokens"`
+}
+
+type listChunkReq struct {
+	Page     int    `json:"page" form:"page"`
+	PageSize int    `json:"pageSize" form:"pageSize"`
+	Status   string `json:"status" form:"status"`
+}
+
+type updateChunkReq struct {
+	Content string `json:"content"`
+	// MetaInfo 不为空时替换分段的元数据
+	MetaInfo model.JSON `json:"metaInfo"`
+}
+
+type updateChunkStatusReq struct {
+	Enabled bool `json:"enabled"`
+}
+
+// createChunkReq 手动添加分段，传 question 和 answer 时按问答对保存，否则使用 content
+type createChunkReq struct {
+	Question string     `json:"question"`
+	Answer   string     `json:"answer"`
+	Content  string     `json:"content"`
+	MetaInfo model.JSON `json:"metaInfo"`
+}
+
+// updateDocumentReq 只修改传入的字段
+type updateDocumentReq struct {
+	// Enabled 关闭之后文档不能被检索到
+	Enabled *bool `json:"enabled"`
+}
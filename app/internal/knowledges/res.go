@@ -12,21 +12,23 @@ type ListResp struct {
 }
 
 type KnowledgeBaseResponse struct {
-	Id                     uuid.UUID         `json:"id"`
-	Name                   string            `json:"name"`
-	Tags                   []string          `json:"tags"`
-	Description            string            `json:"description"`
-	EmbeddingModelName     string            `json:"embeddingModelName"`
-	EmbeddingModelProvider string            `json:"embeddingModelProvider"`
-	ChatModelName          string            `json:"chatModelName"`
-	ChatModelProvider      string            `json:"chatModelProvider"`
-	StorageType            model.StorageType `json:"storageType"`
-	StorageConfig          model.JSON        `json:"storageConfig"`
-	DocumentCount          int               `json:"documentCount"`
-	TotalSize              int64             `json:"totalSize"`
-	CreatedAt              int64             `json:"createdAt"`
-	UpdatedAt              int64             `json:"updatedAt"`
-	CreatorId              uuid.UUID         `json:"creatorId"`
+	Id                     uuid.UUID             `json:"id"`
+	Name                   string                `json:"name"`
+	Tags                   []string              `json:"tags"`
+	Description            string                `json:"description"`
+	EmbeddingModelName     string                `json:"embeddingModelName"`
+	EmbeddingModelProvider string                `json:"embeddingModelProvider"`
+	ChatModelName          string                `json:"chatModelName"`
+	ChatModelProvider      string                `json:"chatModelProvider"`
+	StorageType            model.StorageType     `json:"storageType"`
+	StorageConfig          model.JSON            `json:"storageConfig"`
+	RetrievalConfig        model.RetrievalConfig `json:"retrievalConfig"`
+	ChunkingConfig         model.ChunkingConfig  `json:"chunkingConfig"`
+	DocumentCount          int                   `json:"documentCount"`
+	TotalSize              int64                 `json:"totalSize"`
+	CreatedAt              int64                 `json:"createdAt"`
+	UpdatedAt              int64                 `json:"updatedAt"`
+	CreatorId              uuid.UUID             `json:"creatorId"`
 }
 
 type ListDocumentsResp struct {
@@ -51,3 +53,39 @@ type SearchResult struct {
 	Position   int             `json:"position"`
 	Document   *model.Document `json:"document"`
 }
+
+// PreviewResponse 文档切分的预览结果
+type PreviewResponse struct {
+	DocumentName   string               `json:"documentName"`
+	FileType       string               `json:"fileType"`
+	ChunkingConfig model.ChunkingConfig `json:"chunkingConfig"`
+	Parents        []*PreviewChunk      `json:"parents"`
+	ParentCount    int                  `json:"parentCount"`
+	ChildCount     int                  `json:"childCount"`
+	// TokenCount 父分段的 token 总数，EmbeddingTokens 需要向量化的子分段的 token 总数
+	TokenCount         int     `json:"tokenCount"`
+	EmbeddingTokens    int     `json:"embeddingTokens"`
+	EmbeddingModelName string  `json:"embeddingModelName"`
+	PricePer1KTokens   float64 `json:"pricePer1kTokens"`
+	EstimatedCost      float64 `json:"estimatedCost"`
+	Currency           string  `json:"currency"`
+}
+
+type PreviewChunk struct {
+	Id         uuid.UUID       `json:"id"`
+	Index      int             `json:"index"`
+	Content    string          `json:"content"`
+	Metadata   model.JSON      `json:"metadata"`
+	TokenCount int             `json:"tokenCount"`
+	Children   []*PreviewChunk `json:"children,omitempty"`
+}
+
+type ListChunksResp struct {
+	Chunks []*model.DocumentChunk `json:"items"`
+	Total  int64                  `json:"total"`
+}
+
+type ChunkResponse struct {
+	*model.DocumentChunk
+	Children []*model.DocumentChunk `json:"children"`
+}
@@ -3,11 +3,14 @@ package knowledges
 import (
 	"app/shared"
 	"bufio"
-	"bytes"
 	"common/biz"
 	"common/utils"
 	"context"
+	"core/ai"
 	"core/ai/kbs"
+	"core/storage"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +21,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -25,9 +29,6 @@ import (
 	"github.com/cloudwego/eino-ext/components/document/parser/docx"
 	"github.com/cloudwego/eino-ext/components/document/parser/html"
 	"github.com/cloudwego/eino-ext/components/document/parser/pdf"
-	"github.com/cloudwego/eino-ext/components/model/ollama"
-	"github.com/cloudwego/eino-ext/components/model/openai"
-	"github.com/cloudwego/eino-ext/components/model/qwen"
 	"github.com/cloudwego/eino/components/document"
 	"github.com/cloudwego/eino/components/document/parser"
 	"github.com/cloudwego/eino/components/embedding"
@@ -50,9 +51,56 @@ type service struct {
 	repo         repository
 	esClient     *elasticsearch.Client
 	milvusClient client.Client
+	// localDir 本地向量存储的持久化目录
+	localDir string
+	// storage 保存上传的原始文件
+	storage  storage.Storage
+	ingest   *ingestQueue
+	progress *progressHub
+	// loadEmbedder 按模型配置创建向量模型，默认通过事件查询模型配置
+	loadEmbedder func(provider string, modelName string, creatorId uuid.UUID) (embedding.Embedder, error)
+	// reindexCtx 重建索引任务使用的上下文，Close 时取消
+	reindexCtx    context.Context
+	reindexCancel context.CancelFunc
+	reindexWg     sync.WaitGroup
 }
 
 func (s *service) createKnowledgeBase(ctx context.Context, userId uuid.UUID, req createKnowledgeBaseReq) (any, error) {
+	//不传存储类型默认使用es
+	storageType := req.StorageType
+	if storageType == "" {
+		storageType = model.StorageTypeElasticSearch
+	}
+	if !kbs.IsSupportedStorageType(string(storageType)) {
+		return nil, biz.ErrStorageType
+	}
+	storageConfig := req.StorageConfig
+	if storageConfig == nil {
+		storageConfig = model.JSON{}
+	}
+	//索引名称由知识库ID生成，存储配置只能包含存储类型支持的字段
+	if !kbs.ValidStorageConfig(string(storageType), storageConfig) {
+		return nil, biz.ErrStorageType
+	}
+	var retrievalConfig model.RetrievalConfig
+	if req.RetrievalConfig != nil {
+		if !validRetrievalConfig(req.RetrievalConfig) {
+			return nil, biz.ErrRetrievalConfig
+		}
+		retrievalConfig = *req.RetrievalConfig
+	}
+	var chunkingConfig model.ChunkingConfig
+	if req.ChunkingConfig != nil {
+		if !validChunkingConfig(req.ChunkingConfig) {
+			return nil, biz.ErrChunkingConfig
+		}
+		chunkingConfig = *req.ChunkingConfig
+	}
+	//创建索引(集合)时需要向量的维度，这里调用一次向量模型得到维度
+	dimension, err := s.probeDimension(ctx, req.EmbeddingModelProvider, req.EmbeddingModelName, userId)
+	if err != nil {
+		return nil, err
+	}
 	kb := model.KnowledgeBase{
 		BaseModel: model.BaseModel{
 			ID: uuid.New(),
@@ -64,12 +112,15 @@ func (s *service) createKnowledgeBase(ctx context.Context, userId uuid.UUID, req
 		ChatModelProvider:      req.ChatModelProvider,
 		EmbeddingModelName:     req.EmbeddingModelName,
 		EmbeddingModelProvider: req.EmbeddingModelProvider,
-		StorageType:            model.StorageTypeElasticSearch,
-		StorageConfig:          model.JSON{},
+		EmbeddingDimension:     dimension,
+		StorageType:            storageType,
+		StorageConfig:          storageConfig,
+		RetrievalConfig:        retrievalConfig,
+		ChunkingConfig:         chunkingConfig,
 		DocumentCount:          0,
 		Tags:                   req.Tags,
 	}
-	err := s.repo.createKnowledgeBase(ctx, &kb)
+	err = s.repo.createKnowledgeBase(ctx, &kb)
 	if err != nil {
 		logs.Errorf("create knowledge base error: %v", err)
 		return nil, errs.DBError
@@ -124,6 +175,8 @@ func (s *service) getKnowledgeBase(ctx context.Context, userId uuid.UUID, id uui
 		ChatModelProvider:      kb.ChatModelProvider,
 		StorageType:            kb.StorageType,
 		StorageConfig:          kb.StorageConfig,
+		RetrievalConfig:        kb.RetrievalConfig,
+		ChunkingConfig:         kb.ChunkingConfig,
 		Tags:                   kb.Tags,
 		TotalSize:              totalSize,
 		DocumentCount:          int(docCount),
@@ -148,23 +201,45 @@ func (s *service) updateKnowledgeBase(ctx context.Context, userId uuid.UUID, id
 	if req.Description != "" {
 		kb.Description = req.Description
 	}
-	if req.EmbeddingModelName != "" {
-		kb.EmbeddingModelName = req.EmbeddingModelName
-	}
+	//更换向量模型之后旧的向量和问题的向量不匹配，需要重建索引，重建完成后才切换到新的模型
+	provider := kb.EmbeddingModelProvider
 	if req.EmbeddingModelProvider != "" {
-		kb.EmbeddingModelProvider = req.EmbeddingModelProvider
+		provider = req.EmbeddingModelProvider
+	}
+	modelName := kb.EmbeddingModelName
+	if req.EmbeddingModelName != "" {
+		modelName = req.EmbeddingModelName
 	}
+	embeddingChanged := provider != kb.EmbeddingModelProvider || modelName != kb.EmbeddingModelName
 	if req.ChatModelName != "" {
 		kb.ChatModelName = req.ChatModelName
 	}
 	if req.ChatModelProvider != "" {
 		kb.ChatModelProvider = req.ChatModelProvider
 	}
+	if req.RetrievalConfig != nil {
+		if !validRetrievalConfig(req.RetrievalConfig) {
+			return nil, biz.ErrRetrievalConfig
+		}
+		kb.RetrievalConfig = *req.RetrievalConfig
+	}
+	if req.ChunkingConfig != nil {
+		if !validChunkingConfig(req.ChunkingConfig) {
+			return nil, biz.ErrChunkingConfig
+		}
+		kb.ChunkingConfig = *req.ChunkingConfig
+	}
 	err = s.repo.updateKnowledgeBase(ctx, kb)
 	if err != nil {
 		logs.Errorf("update knowledge base error: %v", err)
 		return nil, errs.DBError
 	}
+	if embeddingChanged {
+		_, err = s.startReindex(ctx, kb, provider, modelName)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return kb, nil
 }
 
@@ -211,12 +286,9 @@ func (s *service) listDocuments(ctx context.Context, userId uuid.UUID, kbId uuid
 	}, nil
 }
 
-func (s *service) uploadDocuments(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, uploadFile *multipart.FileHeader) (any, error) {
-	//读取文件信息，创建Document对象
-	//读取文件内容，进行向量化和索引，其中要进行切分，切分后的数据存入documentchunk表中
-	//同时将切分后的内容，向量化后存入向量数据库中
-	//文件可以存入云存储中
-	//我们先写读取文件信息，创建Document对象，并存入数据库中这个步骤
+func (s *service) uploadDocuments(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, uploadFile *multipart.FileHeader, replace bool) (any, error) {
+	//读取文件信息，创建Document对象，文件保存下来之后交给入库任务处理
+	//入库任务负责切分、向量化和索引，切分后的数据存入documentchunk表中，向量存入向量数据库中
 	//先检查知识库是否存在
 	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
 	if err != nil {
@@ -227,57 +299,9 @@ func (s *service) uploadDocuments(ctx context.Context, userId uuid.UUID, kbId uu
 		return nil, biz.ErrKnowledgeBaseNotFound
 	}
 	ext := strings.ToLower(filepath.Ext(uploadFile.Filename))
-	fileType := kbs.FromExtension(ext)
-	var selectParser parser.Parser
-	switch fileType {
-	case kbs.Markdown:
-		selectParser = parser.TextParser{}
-	case kbs.Docx:
-		selectParser, err = kbs.DocxParser(&docx.Config{
-			ToSections:     true,
-			IncludeTables:  true,
-			IncludeFooters: true,
-			IncludeHeaders: true,
-		})
-		if err != nil {
-			logs.Errorf("new docx parser error: %v", err)
-			return nil, biz.FileLoadError
-		}
-	case kbs.PDF:
-		selectParser, err = kbs.PDFParser(&pdf.Config{
-			//不按分页 获取全部内容
-			ToPages: false,
-		})
-		if err != nil {
-			logs.Errorf("new pdf parser error: %v", err)
-			return nil, biz.FileLoadError
-		}
-	case kbs.Html:
-		selectParser, err = kbs.HtmlParser(&kbs.HtmlConfig{
-			Selector: &html.BodySelector,
-		})
-		if err != nil {
-			logs.Errorf("new html parser error: %v", err)
-			return nil, biz.FileLoadError
-		}
-	case kbs.Epub:
-		selectParser, err = kbs.EpubParser(&epub.Config{
-			StripHTML: true,
-		})
-		if err != nil {
-			logs.Errorf("new epub parser error: %v", err)
-			return nil, biz.FileLoadError
-		}
-
-	default:
-		selectParser = parser.TextParser{}
-	}
-	//读取文件内容
-	loader, err := file.NewFileLoader(ctx, &file.FileLoaderConfig{
-		Parser: selectParser,
-	})
-	if err != nil {
-		logs.Errorf("new file loader error: %v", err)
+	//先确认能创建对应的解析器，不支持的文件直接返回错误
+	if _, err = s.newParser(kbs.FromExtension(ext)); err != nil {
+		logs.Errorf("new parser error: %v", err)
 		return nil, biz.FileLoadError
 	}
 	src, err := uploadFile.Open()
@@ -286,85 +310,278 @@ func (s *service) uploadDocuments(ctx context.Context, userId uuid.UUID, kbId uu
 		return nil, biz.FileLoadError
 	}
 	defer src.Close()
-	tempFile, err := s.createTempFileFromUploadFile(src, uploadFile.Filename)
+	//文件需要保存下来，任务重试或者进程重启之后还要重新读取，下载时也需要原始文件
+	storageKey, fileHash, err := s.storeUploadFile(ctx, src, ext, uploadFile.Header.Get("Content-Type"))
 	if err != nil {
-		logs.Errorf("create temp file error: %v", err)
+		logs.Errorf("store upload file error: %v", err)
 		return nil, biz.FileLoadError
 	}
-	defer tempFile.Close()
-	defer os.Remove(tempFile.Name())
-	//这个URL是文件的地址，正常我们应该上传到云存储中，这里我们先创建一个本地临时文件来获取内容
-	docs, err := loader.Load(ctx, document.Source{
-		URI: tempFile.Name(),
-	})
+	//相同内容的文件已经上传过，没有要求替换时直接返回已有的文档
+	existing, err := s.repo.getDocumentByHash(ctx, kb.ID, fileHash)
 	if err != nil {
-		logs.Errorf("load file error: %v", err)
-		return nil, biz.FileLoadError
+		logs.Errorf("get document by hash error: %v", err)
+		s.removeStoredFile(ctx, storageKey)
+		return nil, errs.DBError
+	}
+	var replacesId *uuid.UUID
+	if existing != nil {
+		processing := existing.Status == model.DocumentStatusPending || existing.Status == model.DocumentStatusProcessing
+		if !replace && existing.Status != model.DocumentStatusFailed {
+			return existing, nil
+		}
+		if processing {
+			return nil, biz.ErrDocumentProcessing
+		}
+		//失败的文档重新上传时也直接替换
+		replacesId = &existing.ID
 	}
-	//文件后轴
 	doc := &model.Document{
+		BaseModel: model.BaseModel{
+			ID: uuid.New(),
+		},
 		KnowledgeBaseID: kb.ID,
 		CreatorID:       userId,
 		Name:            uploadFile.Filename,
 		FileType:        ext,
 		Size:            uploadFile.Size,
-		StorageKey:      uploadFile.Filename,
-		FileHash:        "",
+		StorageKey:      storageKey,
+		FileHash:        fileHash,
 		Status:          model.DocumentStatusPending,
 		ErrorMessage:    "",
+		ReplacesID:      replacesId,
 	}
 	err = s.repo.createDocument(ctx, doc)
 	if err != nil {
 		logs.Errorf("create document error: %v", err)
+		s.removeStoredFile(ctx, storageKey)
 		return nil, errs.DBError
 	}
-	//对文件内容的处理，切分+向量化+索引 放入go协程中，进行处理过程比较长
-	go func() {
-		//做状态更新
-		//这个执行时间长，不能用上面的上下文
-		ctx = context.Background()
-		err = s.repo.updateDocumentStatus(ctx, doc.ID, model.DocumentStatusProcessing)
+	//对文件内容的处理，切分+向量化+索引 放入入库队列中，处理过程比较长
+	//任务创建失败也没有关系，队列会定期把没有任务的文档重新入队
+	err = s.ingest.enqueue(ctx, doc)
+	if err != nil {
+		logs.Errorf("enqueue document error: %v", err)
+	}
+	return doc, nil
+}
+
+// ingestDocument 入库任务的处理函数，读取保存的文件，解析之后切分、向量化并存储
+func (s *service) ingestDocument(ctx context.Context, doc *model.Document) error {
+	kb, err := s.repo.getKnowledgeBase(ctx, doc.CreatorID, doc.KnowledgeBaseID)
+	if err != nil {
+		return err
+	}
+	if kb == nil {
+		return biz.ErrKnowledgeBaseNotFound
+	}
+	//重试时先清理上一次写入了一部分的数据
+	err = s.repo.deleteDocumentChunks(ctx, nil, kb.ID, doc.ID)
+	if err != nil {
+		return err
+	}
+	store, err := s.newVectorStore(ctx, kb)
+	if err != nil {
+		return err
+	}
+	err = store.Delete(ctx, doc.ID.String())
+	if err != nil {
+		return err
+	}
+	s.publishProgress(doc, &IngestProgress{Stage: IngestStageParsing})
+	docs, err := s.loadDocument(ctx, doc)
+	if err != nil {
+		return err
+	}
+	err = s.processDocumentAndVectorAndStore(ctx, doc, docs, kb)
+	if err != nil {
+		return err
+	}
+	//处理过程中重建索引完成切换到了新的索引，写入旧索引的数据检索不到，需要重新处理
+	latest, err := s.repo.getKnowledgeBase(ctx, doc.CreatorID, kb.ID)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.VectorIndex != kb.VectorIndex {
+		return fmt.Errorf("知识库的向量索引已经切换，需要重新处理")
+	}
+	if doc.ReplacesID != nil {
+		return s.replaceDocument(ctx, kb, store, doc)
+	}
+	return nil
+}
+
+// replaceDocument 新文档处理完成后替换旧文档
+// 检索时只返回处理完成的文档，所以在一个事务中把新文档标记为完成并删除旧文档，检索不会看到处理了一半的数据
+func (s *service) replaceDocument(ctx context.Context, kb *model.KnowledgeBase, store kbs.VectorStore, doc *model.Document) error {
+	old, err := s.repo.getDocumentById(ctx, *doc.ReplacesID)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		//旧文档已经被删除了
+		return nil
+	}
+	err = s.repo.transaction(ctx, func(tx *gorm.DB) error {
+		err := s.repo.completeDocument(ctx, tx, doc.ID)
 		if err != nil {
-			logs.Errorf("update document status error: %v", err)
-			return
+			return err
 		}
-		//处理文件
-		err = s.processDocumentAndVectorAndStore(ctx, doc, docs, kb)
+		err = s.repo.deleteDocuments(ctx, tx, old.CreatorID, kb.ID, old.ID)
 		if err != nil {
-			logs.Errorf("process file error: %v", err)
-			//更新状态为失败
-			err = s.repo.updateDocumentStatus(ctx, doc.ID, model.DocumentStatusFailed)
-			if err != nil {
-				logs.Errorf("update document status error: %v", err)
-				return
-			}
-			return
+			return err
 		}
-		//最后更新状态
-		err = s.repo.updateDocumentStatus(ctx, doc.ID, model.DocumentStatusCompleted)
-		if err != nil {
-			logs.Errorf("update document status error: %v", err)
-			return
+		return s.repo.deleteDocumentChunks(ctx, tx, kb.ID, old.ID)
+	})
+	if err != nil {
+		return err
+	}
+	//旧文档的父分段已经删除，残留的向量也检索不到内容，这里删除失败只记录日志
+	err = store.Delete(ctx, old.ID.String())
+	if err != nil {
+		logs.Errorf("delete replaced document vectors error: %v", err)
+	}
+	s.removeStoredFile(ctx, old.StorageKey)
+	return nil
+}
+
+// loadDocument 从对象存储中取出原始文件，使用文件类型对应的解析器读取文件内容
+func (s *service) loadDocument(ctx context.Context, doc *model.Document) ([]*schema.Document, error) {
+	src, err := s.openStoredFile(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return s.parseFile(ctx, doc.FileType, src)
+}
+
+// parseFile 使用扩展名对应的解析器读取文件内容
+func (s *service) parseFile(ctx context.Context, ext string, src io.Reader) ([]*schema.Document, error) {
+	selectParser, err := s.newParser(kbs.FromExtension(ext))
+	if err != nil {
+		return nil, err
+	}
+	loader, err := file.NewFileLoader(ctx, &file.FileLoaderConfig{
+		Parser: selectParser,
+	})
+	if err != nil {
+		return nil, err
+	}
+	//解析器需要本地文件，对象存储可能不在本地，先下载到临时文件
+	tempFile, err := os.CreateTemp("", "document-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = io.Copy(tempFile, src)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loader.Load(ctx, document.Source{
+		URI: tempFile.Name(),
+	})
+}
+
+// openStoredFile 读取文档的原始文件
+// 使用对象存储之前上传的文档，StorageKey 是本地的文件路径，找不到对象时按本地路径读取
+func (s *service) openStoredFile(ctx context.Context, doc *model.Document) (io.ReadCloser, error) {
+	reader, err := s.storage.Get(ctx, doc.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		localFile, openErr := os.Open(doc.StorageKey)
+		if openErr != nil {
+			return nil, err
 		}
-	}()
-	return doc, nil
+		return localFile, nil
+	}
+	return reader, err
 }
 
-const (
-	maxChildSize     = 500 //子块最大的长度
-	childOverlapSize = 150 // 子块重叠的长度
-)
+func (s *service) newParser(fileType kbs.FileType) (parser.Parser, error) {
+	switch fileType {
+	case kbs.Markdown:
+		return parser.TextParser{}, nil
+	case kbs.Docx:
+		return kbs.DocxParser(&docx.Config{
+			ToSections:     true,
+			IncludeTables:  true,
+			IncludeFooters: true,
+			IncludeHeaders: true,
+		})
+	case kbs.PDF:
+		return kbs.PDFParser(&pdf.Config{
+			//不按分页 获取全部内容
+			ToPages: false,
+		})
+	case kbs.Html:
+		return kbs.HtmlParser(&kbs.HtmlConfig{
+			Selector: &html.BodySelector,
+		})
+	case kbs.Epub:
+		return kbs.EpubParser(&epub.Config{
+			StripHTML: true,
+		})
+	case kbs.Excel:
+		return kbs.SpreadsheetParser(&kbs.SpreadsheetConfig{
+			Format: kbs.SpreadsheetXLSX,
+		})
+	case kbs.CSV:
+		return kbs.SpreadsheetParser(&kbs.SpreadsheetConfig{
+			Format: kbs.SpreadsheetCSV,
+		})
+	default:
+		return parser.TextParser{}, nil
+	}
+}
+
+// chunkers 按知识库的切分配置创建父分段和子分段的切分器，没有配置时按字符数固定长度切分
+func chunkers(kb *model.KnowledgeBase) (parent *kbs.Chunker, child *kbs.Chunker) {
+	config := kb.ChunkingConfig.WithDefaults()
+	parent = &kbs.Chunker{
+		Strategy: config.Strategy,
+		Size:     config.ParentSize,
+		Overlap:  config.ParentOverlap,
+		Unit:     config.Unit,
+	}
+	child = &kbs.Chunker{
+		Strategy: config.Strategy,
+		Size:     config.ChildSize,
+		Overlap:  config.ChildOverlap,
+		Unit:     config.Unit,
+	}
+	return parent, child
+}
 
 func (s *service) processDocumentAndVectorAndStore(ctx context.Context, doc *model.Document, docs []*schema.Document, kb *model.KnowledgeBase) error {
+	parentModels, childSchemaDocs := s.splitDocument(doc, docs, kb)
+	//如果文档内容为空 直接返回
+	if len(parentModels) == 0 {
+		logs.Warnf("document content is empty")
+		return nil
+	}
+	tokenCount := 0
+	for _, parent := range parentModels {
+		tokenCount += parent.TokenCount
+	}
+	s.publishProgress(doc, &IngestProgress{
+		Stage:           IngestStageChunking,
+		ChunkCount:      len(parentModels),
+		ChildChunkCount: len(childSchemaDocs),
+		TokenCount:      tokenCount,
+	})
+	return s.saveToStores(ctx, doc, kb, parentModels, childSchemaDocs)
+}
+
+// splitDocument 按文件类型把解析出的内容切分成父分段和子分段，不写入任何存储
+func (s *service) splitDocument(doc *model.Document, docs []*schema.Document, kb *model.KnowledgeBase) ([]*model.DocumentChunk, []*schema.Document) {
 	//获取文档内容
 	var content string
 	if len(docs) > 0 && docs[0] != nil {
 		content = docs[0].Content
 	}
-	//如果文档内容为空 直接返回
 	if content == "" {
-		logs.Warnf("document content is empty")
-		return nil
+		return nil, nil
 	}
 	var parentModels []*model.DocumentChunk
 	var childSchemaDocs []*schema.Document
@@ -392,9 +609,12 @@ func (s *service) processDocumentAndVectorAndStore(ctx context.Context, doc *mod
 		parentModels, childSchemaDocs = s.processHtml(docs, doc, parentModels, kb, childSchemaDocs)
 	} else if fileType == kbs.Epub {
 		parentModels, childSchemaDocs = s.processEpub(docs, doc, parentModels, kb, childSchemaDocs)
+	} else if fileType == kbs.Excel || fileType == kbs.CSV {
+		parentModels, childSchemaDocs = s.processSpreadsheet(docs, doc, parentModels, kb, childSchemaDocs)
 	} else {
-		//这个通用的处理，我们按照长度进行切分
-		parentTexts := utils.SplitByWindow(content, 1200, 200)
+		//这个通用的处理，我们按照知识库的切分配置进行切分
+		parentChunker, childChunker := chunkers(kb)
+		parentTexts := parentChunker.Split(content)
 		for i, pText := range parentTexts {
 			parentModels = append(parentModels, &model.DocumentChunk{
 				BaseModel:       model.BaseModel{ID: uuid.New()},
@@ -411,86 +631,150 @@ func (s *service) processDocumentAndVectorAndStore(ctx context.Context, doc *mod
 				Status:     model.ChunkStatusEmbedded,
 			})
 			pathPrefix := fmt.Sprintf("【文档:%s】【片段:%d】\n", doc.Name, i+1)
-			childTexts := utils.SplitByWindow(content, 400, 50)
+			childTexts := childChunker.Split(pText)
 			for j, cText := range childTexts {
 				childSchemaDocs = append(childSchemaDocs, s.buildChildSchemaDoc(parentModels[i].ID, doc, kb, pathPrefix+cText, i, j, 0, nil))
 			}
 		}
 	}
-	return s.saveToStores(ctx, kb, parentModels, childSchemaDocs)
+	return parentModels, childSchemaDocs
+}
+
+// publishProgress 推送文档的入库进度
+func (s *service) publishProgress(doc *model.Document, progress *IngestProgress) {
+	progress.KbId = doc.KnowledgeBaseID
+	progress.DocumentId = doc.ID
+	progress.DocumentName = doc.Name
+	s.progress.publish(progress)
 }
 
 func (s *service) processMarkdown(content string, doc *model.Document, parentModels []*model.DocumentChunk, kb *model.KnowledgeBase, childSchemaDocs []*schema.Document) ([]*model.DocumentChunk, []*schema.Document) {
+	parentChunker, childChunker := chunkers(kb)
 	h1Title := utils.ExtractTitle(content, "#")
 	if h1Title == "" {
 		h1Title = doc.Name
 	}
 	//获取h2的内容
 	h2Block := utils.SplitByHeading(content, "##")
-	for i, h2 := range h2Block {
-		parentId := uuid.New()
+	for _, h2 := range h2Block {
 		h2Title := utils.ExtractTitle(h2, "##")
 		if h2Title == "" {
 			h2Title = "概览"
 		}
-		//h2的内容是parent
-		parentModels = append(parentModels, &model.DocumentChunk{
-			BaseModel:       model.BaseModel{ID: parentId},
-			DocumentID:      doc.ID,
-			KnowledgeBaseID: kb.ID,
-			Content:         h2,
-			ChunkIndex:      i,
-			MetaInfo: map[string]interface{}{
-				"h1": h1Title,
-				"h2": h2Title,
-			},
-			TokenCount: utils.GetTokenCount(h2),
-			Status:     model.ChunkStatusEmbedded,
-		})
-		//获取h3的内容 这部分做为child
-		h3Block := utils.SplitByHeading(h2, "###")
-		for j, h3 := range h3Block {
-			h3Title := utils.ExtractTitle(h3, "###")
-			//这里我们给child的内容 添加一个前缀 表明所属的上级
-			pathPrefix := fmt.Sprintf("【文档:%s】 > 【主题:%s】", h1Title, h2Title)
-			if h3Title != "" {
-				h3Title += " > 【子题: " + h3Title + "】"
-			}
-			//添加一个换行
-			pathPrefix += "\n"
-			//为了防止子内容过长，我们设定一个长度，做一次切分
-			subTexts := utils.SplitTextByLength(h3, maxChildSize-len(pathPrefix), childOverlapSize)
-			for k, text := range subTexts {
-				//text就是最终子块的内容
-				childSchemaDocs = append(childSchemaDocs, s.buildChildSchemaDoc(parentId, doc, kb, pathPrefix+text, i, j, k, nil))
-			}
+		//h2的内容是parent，超过父分段长度的章节按知识库的切分配置继续切分
+		for _, section := range parentChunker.Split(h2) {
+			parentModels, childSchemaDocs = s.appendMarkdownSection(section, h1Title, h2Title, doc, kb, childChunker, parentModels, childSchemaDocs)
+		}
+	}
+	return parentModels, childSchemaDocs
+}
+
+// appendMarkdownSection 把一段h2的内容作为父分段，其中的h3再切分成子分段
+func (s *service) appendMarkdownSection(section string, h1Title string, h2Title string, doc *model.Document, kb *model.KnowledgeBase, childChunker *kbs.Chunker, parentModels []*model.DocumentChunk, childSchemaDocs []*schema.Document) ([]*model.DocumentChunk, []*schema.Document) {
+	i := len(parentModels)
+	parentId := uuid.New()
+	parentModels = append(parentModels, &model.DocumentChunk{
+		BaseModel:       model.BaseModel{ID: parentId},
+		DocumentID:      doc.ID,
+		KnowledgeBaseID: kb.ID,
+		Content:         section,
+		ChunkIndex:      i,
+		MetaInfo: map[string]interface{}{
+			"h1": h1Title,
+			"h2": h2Title,
+		},
+		TokenCount: utils.GetTokenCount(section),
+		Status:     model.ChunkStatusEmbedded,
+	})
+	//获取h3的内容 这部分做为child
+	h3Block := utils.SplitByHeading(section, "###")
+	for j, h3 := range h3Block {
+		h3Title := utils.ExtractTitle(h3, "###")
+		//这里我们给child的内容 添加一个前缀 表明所属的上级
+		pathPrefix := fmt.Sprintf("【文档:%s】 > 【主题:%s】", h1Title, h2Title)
+		if h3Title != "" {
+			h3Title += " > 【子题: " + h3Title + "】"
+		}
+		//添加一个换行
+		pathPrefix += "\n"
+		//为了防止子内容过长，按子分段的长度做一次切分
+		subTexts := childChunker.Split(h3)
+		for k, text := range subTexts {
+			//text就是最终子块的内容
+			childSchemaDocs = append(childSchemaDocs, s.buildChildSchemaDoc(parentId, doc, kb, pathPrefix+text, i, j, k, nil))
 		}
 	}
 	return parentModels, childSchemaDocs
 }
 
-func (s *service) createTempFileFromUploadFile(src multipart.File, fileName string) (*os.File, error) {
-	//创建一个临时文件
-	tempFile, err := os.CreateTemp("", "upload-*.tmp")
+// documentEvents 订阅知识库中文档的入库进度，客户端断开连接之后取消订阅
+func (s *service) documentEvents(ctx context.Context, userId uuid.UUID, kbId uuid.UUID) (<-chan string, error) {
+	kb, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
 	if err != nil {
-		return nil, err
+		logs.Errorf("get knowledge base error: %v", err)
+		return nil, errs.DBError
 	}
-	defer tempFile.Close()
-	//复制文件内容到临时文件
-	_, err = io.Copy(tempFile, src)
+	if kb == nil || kb.CreatorID != userId {
+		return nil, biz.ErrKnowledgeBaseNotFound
+	}
+	events, unsubscribe := s.progress.subscribe(kbId)
+	datachan := make(chan string)
+	go func() {
+		defer close(datachan)
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					logs.Errorf("marshal progress error: %v", err)
+					continue
+				}
+				select {
+				case datachan <- string(data):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return datachan, nil
+}
+
+// storeUploadFile 把上传的文件保存到对象存储中，对象的key由文件内容的SHA-256决定
+// 内容相同的文件只保存一份，返回对象的key和SHA-256
+func (s *service) storeUploadFile(ctx context.Context, src multipart.File, ext string, contentType string) (string, string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, src)
 	if err != nil {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
-		return nil, err
+		return "", "", err
 	}
-	//重置文件指针到开始位置
-	_, err = tempFile.Seek(0, io.SeekStart)
+	fileHash := hex.EncodeToString(hash.Sum(nil))
+	storageKey := documentStorageKey(fileHash, ext)
+	exist, err := s.storage.Exists(ctx, storageKey)
 	if err != nil {
-		tempFile.Close()
-		os.Remove(tempFile.Name())
-		return nil, err
+		return "", "", err
+	}
+	if exist {
+		return storageKey, fileHash, nil
+	}
+	//计算hash时已经读完了，重新从头读取
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", "", err
 	}
-	return tempFile, nil
+	err = s.storage.Put(ctx, storageKey, src, size, contentType)
+	if err != nil {
+		return "", "", err
+	}
+	return storageKey, fileHash, nil
+}
+
+// documentStorageKey 按hash的前两位分目录，避免一个目录下的文件太多
+func documentStorageKey(fileHash string, ext string) string {
+	return fmt.Sprintf("documents/%s/%s%s", fileHash[:2], fileHash, ext)
 }
 
 func (s *service) getEmbeddingConfig(provider string, embeddingModelName string, creatorID uuid.UUID) (embedding.Embedder, error) {
@@ -542,16 +826,16 @@ func (s *service) deleteDocuments(ctx context.Context, userId uuid.UUID, kbId uu
 			logs.Errorf("delete document chunks error: %v", err)
 			return err
 		}
-		//删除es的索引
-		if knowledgeBase.StorageType == model.StorageTypeElasticSearch {
-			err = s.deleteEsIndex(ctx, kbId, documentId)
-			if err != nil {
-				logs.Errorf("delete es index error: %v", err)
-				return err
-			}
+		//删除向量库中的切片
+		store, err := s.newVectorStore(ctx, knowledgeBase)
+		if err != nil {
+			logs.Errorf("new vector store error: %v", err)
+			return err
 		}
-		if knowledgeBase.StorageType == model.StorageTypeMilvus {
-			err = s.deleteMilvusIndex(ctx, kbId, documentId)
+		err = store.Delete(ctx, documentId.String())
+		if err != nil {
+			logs.Errorf("delete vector index error: %v", err)
+			return err
 		}
 		return nil
 	})
@@ -559,42 +843,65 @@ func (s *service) deleteDocuments(ctx context.Context, userId uuid.UUID, kbId uu
 		logs.Errorf("delete documents error: %v", err)
 		return errs.DBError
 	}
+	s.removeStoredFile(ctx, doc.StorageKey)
 	return nil
 }
 
-func (s *service) deleteEsIndex(ctx context.Context, kbId uuid.UUID, documentId uuid.UUID) error {
-	index := s.buildIndex(kbId)
-	//需要删除doc_id这个字段匹配的文档
-	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			"term": map[string]interface{}{
-				"doc_id.keyword": documentId.String(), //使用keyword精确匹配
-			},
-		},
+// removeStoredFile 没有文档使用这个对象时从对象存储中删除，删除失败只记录日志
+func (s *service) removeStoredFile(ctx context.Context, storageKey string) {
+	count, err := s.repo.countDocumentsByStorageKey(ctx, storageKey)
+	if err != nil {
+		logs.Errorf("count documents by storage key error: %v", err)
+		return
+	}
+	if count > 0 {
+		return
 	}
-	//查询条件转换为json
-	var buf bytes.Buffer
-	encoder := json.NewEncoder(&buf)
-	err := encoder.Encode(query)
+	if err := s.storage.Delete(ctx, storageKey); err != nil {
+		logs.Errorf("delete stored file error: %v", err)
+	}
+}
+
+// updateDocument 修改文档的启用状态，检索时过滤禁用的文档，向量不需要删除，重新启用时立即生效
+func (s *service) updateDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID, req updateDocumentReq) (*model.Document, error) {
+	doc, err := s.repo.getDocument(ctx, userId, kbId, documentId)
 	if err != nil {
-		logs.Errorf("encode query error: %v", err)
-		return err
+		logs.Errorf("get document error: %v", err)
+		return nil, errs.DBError
 	}
-	res, err := s.esClient.DeleteByQuery(
-		[]string{index},
-		&buf,
-		s.esClient.DeleteByQuery.WithContext(ctx),
-	)
+	if doc == nil {
+		return nil, biz.ErrDocumentNotFound
+	}
+	if req.Enabled != nil && *req.Enabled != doc.Enabled {
+		err = s.repo.updateDocumentEnabled(ctx, doc.ID, *req.Enabled)
+		if err != nil {
+			logs.Errorf("update document enabled error: %v", err)
+			return nil, errs.DBError
+		}
+		doc.Enabled = *req.Enabled
+	}
+	return doc, nil
+}
+
+// downloadDocument 返回文档和原始文件的内容，使用完需要关闭
+func (s *service) downloadDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) (*model.Document, io.ReadCloser, error) {
+	doc, err := s.repo.getDocument(ctx, userId, kbId, documentId)
 	if err != nil {
-		logs.Errorf("delete by query error: %v", err)
-		return err
+		logs.Errorf("get document error: %v", err)
+		return nil, nil, errs.DBError
 	}
-	defer res.Body.Close()
-	if res.IsError() {
-		logs.Errorf("delete by query error: %v", res)
-		return err
+	if doc == nil {
+		return nil, nil, biz.ErrDocumentNotFound
 	}
-	return nil
+	reader, err := s.openStoredFile(ctx, doc)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, biz.ErrDocumentFileNotFound
+	}
+	if err != nil {
+		logs.Errorf("open stored file error: %v", err)
+		return nil, nil, biz.FileLoadError
+	}
+	return doc, reader, nil
 }
 
 func (s *service) buildIndex(kbId uuid.UUID) string {
@@ -603,14 +910,166 @@ func (s *service) buildIndex(kbId uuid.UUID) string {
 	return sprintf
 }
 
+// newVectorStore 根据知识库的存储类型和存储配置创建向量存储
+func (s *service) newVectorStore(ctx context.Context, kb *model.KnowledgeBase) (kbs.VectorStore, error) {
+	if kb.EmbeddingDimension <= 0 {
+		//之前创建的知识库没有保存维度，第一次使用时补上
+		dimension, err := s.probeDimension(ctx, kb.EmbeddingModelProvider, kb.EmbeddingModelName, kb.CreatorID)
+		if err != nil {
+			return nil, err
+		}
+		err = s.repo.updateEmbeddingDimension(ctx, kb.ID, dimension)
+		if err != nil {
+			logs.Errorf("update embedding dimension error: %v", err)
+			return nil, errs.DBError
+		}
+		kb.EmbeddingDimension = dimension
+	}
+	return s.openVectorStore(ctx, kb, kb.VectorIndex, kb.EmbeddingModelProvider, kb.EmbeddingModelName, kb.EmbeddingDimension)
+}
+
+// probeDimension 调用一次向量模型得到向量的维度
+func (s *service) probeDimension(ctx context.Context, provider string, modelName string, creatorId uuid.UUID) (int, error) {
+	embedder, err := s.loadEmbedder(provider, modelName, creatorId)
+	if err != nil {
+		logs.Errorf("get embedding config error: %v", err)
+		return 0, biz.ErrEmbeddingConfigNotFound
+	}
+	dimension, err := kbs.ProbeDimension(ctx, embedder)
+	if err != nil {
+		logs.Errorf("probe embedding dimension error: %v", err)
+		return 0, biz.ErrEmbedding
+	}
+	return dimension, nil
+}
+
+// openVectorStore 使用指定的索引和向量模型创建向量存储，index 为空时使用默认的索引
+func (s *service) openVectorStore(ctx context.Context, kb *model.KnowledgeBase, index string, provider string, modelName string, dimension int) (kbs.VectorStore, error) {
+	embedder, err := s.loadEmbedder(provider, modelName, kb.CreatorID)
+	if err != nil {
+		logs.Errorf("get embedding config error: %v", err)
+		return nil, biz.ErrEmbeddingConfigNotFound
+	}
+	if index == "" {
+		index = s.buildIndex(kb.ID)
+	}
+	clients := &kbs.Clients{
+		ES:       s.esClient,
+		Milvus:   s.milvusClient,
+		LocalDir: s.localDir,
+	}
+	//只有 pgvector 需要数据库连接
+	if kb.StorageType == model.StorageTypePgVector {
+		clients.Postgres = database.GetPostgresDB().GormDB
+	}
+	return kbs.NewVectorStore(ctx, clients, &kbs.StoreConfig{
+		StorageType:   string(kb.StorageType),
+		StorageConfig: kb.StorageConfig,
+		Index:         index,
+		Dimension:     dimension,
+		Embedder:      embedder,
+	})
+}
+
+// baseIndex 知识库没有重建过索引时使用的索引名称
+func (s *service) baseIndex(kb *model.KnowledgeBase) string {
+	return s.buildIndex(kb.ID)
+}
+
+// retrieve 按照知识库的检索配置查询子分段，混合检索时同时进行全文检索并融合结果
+func (s *service) retrieve(ctx context.Context, kb *model.KnowledgeBase, store kbs.VectorStore, query string, filter kbs.SearchFilter) ([]*schema.Document, error) {
+	config := kb.RetrievalConfig
+	if config.SearchMode != kbs.SearchModeHybrid {
+		return store.Search(ctx, query, maxChildResult, filter)
+	}
+	return kbs.HybridSearch(ctx, store, query, maxChildResult, filter, kbs.HybridConfig{
+		Fusion:        config.Fusion,
+		RRFK:          config.RRFK,
+		VectorWeight:  config.VectorWeight,
+		KeywordWeight: config.KeywordWeight,
+	})
+}
+
+// rerank 按照知识库配置的重排序方式重新打分
+func (s *service) rerank(ctx context.Context, kb *model.KnowledgeBase, query string, docs []*schema.Document) ([]*schema.Document, error) {
+	var reranker kbs.Reranker
+	switch kb.RetrievalConfig.Reranker {
+	case kbs.RerankerNone:
+		return docs, nil
+	case kbs.RerankerLexical:
+		reranker = kbs.NewLexicalReranker()
+	case kbs.RerankerLLM:
+		chatModel, err := s.getChatModel(kb.ChatModelName, kb.ChatModelProvider)
+		if err != nil {
+			logs.Errorf("getChatModel 获取对话模型失败: %v", err)
+			return nil, err
+		}
+		reranker = kbs.NewLLMReranker(chatModel)
+	}
+	result, err := kbs.Rerank(ctx, reranker, query, docs, kb.RetrievalConfig.MinScore)
+	if err != nil && kb.RetrievalConfig.Reranker == kbs.RerankerLLM {
+		//模型返回的格式不对时降级为词汇重叠的重排序
+		logs.Warnf("llm rerank error, fallback to lexical: %v", err)
+		return kbs.Rerank(ctx, kbs.NewLexicalReranker(), query, docs, kb.RetrievalConfig.MinScore)
+	}
+	return result, err
+}
+
+func validRetrievalConfig(config *model.RetrievalConfig) bool {
+	switch config.SearchMode {
+	case "", kbs.SearchModeVector, kbs.SearchModeHybrid:
+	default:
+		return false
+	}
+	switch config.Fusion {
+	case "", kbs.FusionRRF, kbs.FusionWeighted:
+	default:
+		return false
+	}
+	switch config.Reranker {
+	case kbs.RerankerNone, kbs.RerankerLexical, kbs.RerankerLLM:
+	default:
+		return false
+	}
+	switch config.IntentExtractor {
+	case kbs.IntentExtractorNone, kbs.IntentExtractorNovel, kbs.IntentExtractorMetadata:
+	default:
+		return false
+	}
+	if !validQueryMode(config.QueryMode) {
+		return false
+	}
+	return config.RRFK >= 0 && config.VectorWeight >= 0 && config.KeywordWeight >= 0 &&
+		config.MinScore >= 0 && config.MinScore <= 1
+}
+
+func validChunkingConfig(config *model.ChunkingConfig) bool {
+	switch config.Strategy {
+	case "", kbs.ChunkStrategyFixed, kbs.ChunkStrategyRecursive, kbs.ChunkStrategySentence, kbs.ChunkStrategyHeading, kbs.ChunkStrategyToken:
+	default:
+		return false
+	}
+	switch config.Unit {
+	case "", kbs.ChunkUnitChars, kbs.ChunkUnitTokens:
+	default:
+		return false
+	}
+	if config.ParentSize < 0 || config.ChildSize < 0 || config.ParentOverlap < 0 || config.ChildOverlap < 0 {
+		return false
+	}
+	//重叠的长度要小于分段的长度，子分段不能比父分段长
+	c := config.WithDefaults()
+	return c.ParentOverlap < c.ParentSize && c.ChildOverlap < c.ChildSize && c.ChildSize <= c.ParentSize
+}
+
 const (
-	maxSearchResult = 5 //设置一个最大搜索结果数量
+	maxChildResult  = 10 //向量库中查询子分段的数量
+	maxSearchResult = 5  //设置一个最大搜索结果数量
 )
 
 func (s *service) searchKnowledgeBase(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, params searchParams) (*SearchResponse, error) {
 	//记录开始时间
 	startTime := time.Now()
-	index := s.buildIndex(kbId)
 	//验证知识库是否存在
 	knowledgeBase, err := s.repo.getKnowledgeBase(ctx, userId, kbId)
 	if err != nil {
@@ -630,33 +1089,44 @@ func (s *service) searchKnowledgeBase(ctx context.Context, userId uuid.UUID, kbI
 			Total:   0,
 		}, nil
 	}
-	//我们这里调用大模型对用户的问题进行关键的信息提取，比如一百章讲了什么，提取到100这个章节数，可以通过元数据进行精确匹配
-	intent, _ := s.parseQueryIntent(ctx, knowledgeBase, params.Query)
-	//获取到向量模型配置
-	embedder, err := s.getEmbeddingConfig(knowledgeBase.EmbeddingModelProvider, knowledgeBase.EmbeddingModelName, userId)
-	if err != nil {
-		logs.Errorf("get embedding config error: %v", err)
-		return nil, biz.ErrEmbeddingConfigNotFound
+	//按知识库配置的方式提取问题中的关键词和过滤条件，比如一百章讲了什么，提取到100这个章节数，可以通过元数据进行精确匹配
+	intent := s.extractQueryIntent(ctx, knowledgeBase, params.Query)
+	//请求中指定的改写方式优先于知识库的配置
+	queryMode := knowledgeBase.RetrievalConfig.QueryMode
+	if params.QueryMode != "" {
+		queryMode = params.QueryMode
+	}
+	if !validQueryMode(queryMode) {
+		return nil, biz.ErrRetrievalConfig
 	}
-	//这里正常需要根据知识库中的存储类型判断，因为前端没有修改的地方 我们就以写死的方式进行替换不同的存储
-	//store, err := kbs.NewESVectorStore(ctx, s.esClient, index, embedder)
-	store, err := kbs.NewMilvusVectorStore(ctx, s.milvusClient, index, embedder)
+	//根据知识库的存储类型获取向量存储
+	store, err := s.newVectorStore(ctx, knowledgeBase)
 	if err != nil {
 		logs.Errorf("new vector store error: %v", err)
 		return nil, err
 	}
-	filter := make(kbs.SearchFilter)
-	if intent.ChapterNum > 0 {
-		filter["chapter_num"] = intent.ChapterNum
+	//短的或者含糊的问题按配置改写成多个检索文本，并行检索之后合并，按父分段去重
+	queries := s.expandQuery(ctx, knowledgeBase, queryMode, intent.Keywords)
+	search := func(filter kbs.SearchFilter) ([]*schema.Document, error) {
+		return kbs.MultiSearch(ctx, queries, maxChildResult, func(ctx context.Context, query string) ([]*schema.Document, error) {
+			return s.retrieve(ctx, knowledgeBase, store, query, filter)
+		})
 	}
-	if intent.VolumeNum > 0 {
-		filter["volume_num"] = intent.VolumeNum
+	childDocs, err := search(intent.Filter)
+	if err == nil && len(childDocs) == 0 && len(intent.Filter) > 0 {
+		//提取的过滤条件可能不准确，没有结果时去掉过滤条件再检索一次
+		childDocs, err = search(kbs.SearchFilter{})
 	}
-	childDocs, err := store.Search(ctx, intent.Keywords, 10, filter)
 	if err != nil {
 		logs.Errorf("search error: %v", err)
 		return nil, err
 	}
+	//重排序并去掉不相关的切片，使用用户原始的问题判断相关性
+	childDocs, err = s.rerank(ctx, knowledgeBase, params.Query, childDocs)
+	if err != nil {
+		logs.Errorf("rerank error: %v", err)
+		return nil, err
+	}
 	//我们需要查找匹配的子分段文档对应的父分段内容
 	parentIdMap := make(map[string]float64) //doc_chunk_id:score
 	var orderedParentIds []string
@@ -680,16 +1150,30 @@ func (s *service) searchKnowledgeBase(ctx context.Context, userId uuid.UUID, kbI
 			Total: 0,
 		}, nil
 	}
-	if len(orderedParentIds) > maxSearchResult {
-		//这里主要是为了防止知识库查询出来的内容过多，相似度太低的没有必要提供给大模型
-		orderedParentIds = orderedParentIds[:maxSearchResult]
-	}
-	//获取父分段内容
+	//获取父分段内容，禁用的文档和分段在这里被过滤掉，所以先查询全部再截取
 	parentChunks, err := s.repo.getDocumentChunksByIds(ctx, orderedParentIds)
 	if err != nil {
 		logs.Errorf("get document chunks error: %v", err)
 		return nil, errs.DBError
 	}
+	if len(parentChunks) > maxSearchResult {
+		//这里主要是为了防止知识库查询出来的内容过多，相似度太低的没有必要提供给大模型
+		parentChunks = parentChunks[:maxSearchResult]
+	}
+	//查询分段所属的文档，引用来源时需要展示文档名称
+	documentIds := make([]uuid.UUID, 0, len(parentChunks))
+	for _, chunk := range parentChunks {
+		documentIds = append(documentIds, chunk.DocumentID)
+	}
+	documents, err := s.repo.getDocumentsByIds(ctx, documentIds)
+	if err != nil {
+		logs.Errorf("get documents error: %v", err)
+		return nil, errs.DBError
+	}
+	documentMap := make(map[uuid.UUID]*model.Document, len(documents))
+	for _, doc := range documents {
+		documentMap[doc.ID] = doc
+	}
 	results := make([]*SearchResult, 0, len(parentChunks))
 	for i, chunk := range parentChunks {
 		results = append(results, &SearchResult{
@@ -699,6 +1183,7 @@ func (s *service) searchKnowledgeBase(ctx context.Context, userId uuid.UUID, kbI
 			Metadata:   chunk.MetaInfo,
 			Position:   i,
 			Score:      parentIdMap[chunk.ID.String()],
+			Document:   documentMap[chunk.DocumentID],
 		})
 	}
 	return &SearchResponse{
@@ -795,42 +1280,82 @@ func (s *service) buildChildSchemaDoc(parentId uuid.UUID, doc *model.Document, k
 	}
 }
 
-func (s *service) saveToStores(ctx context.Context, kb *model.KnowledgeBase, parentModels []*model.DocumentChunk, docs []*schema.Document) error {
+const embeddingBatchSize = 50 //每一批向量化的子分段数量
+
+func (s *service) saveToStores(ctx context.Context, doc *model.Document, kb *model.KnowledgeBase, parentModels []*model.DocumentChunk, docs []*schema.Document) error {
 	//父分段直接存入数据库pg
+	s.publishProgress(doc, &IngestProgress{Stage: IngestStageStoring, ChunkCount: len(parentModels)})
 	err := s.repo.createDocumentChunks(ctx, parentModels)
 	if err != nil {
 		logs.Errorf("create document chunks error: %v", err)
 		return err
 	}
-	//子分段存入向量数据库，这里我们存入es中
-	embedder, err := s.getEmbeddingConfig(kb.EmbeddingModelProvider, kb.EmbeddingModelName, kb.CreatorID)
-	if err != nil {
-		logs.Errorf("get embedding config error: %v", err)
-		return biz.ErrEmbeddingConfigNotFound
-	}
-	//store, err := kbs.NewESVectorStore(ctx, s.esClient, s.buildIndex(kb.ID), embedder)
-	store, err := kbs.NewMilvusVectorStore(ctx, s.milvusClient, s.buildIndex(kb.ID), embedder)
+	//子分段存入知识库配置的向量数据库中
+	store, err := s.newVectorStore(ctx, kb)
 	if err != nil {
 		logs.Errorf("new indexer error: %v", err)
 		return err
 	}
-	err = store.Store(ctx, docs)
-	if err != nil {
-		logs.Errorf("store documents error: %v", err)
-		return err
+	//分批向量化，方便推送进度
+	totalBatches := (len(docs) + embeddingBatchSize - 1) / embeddingBatchSize
+	for i := 0; i < totalBatches; i++ {
+		end := min((i+1)*embeddingBatchSize, len(docs))
+		s.publishProgress(doc, &IngestProgress{
+			Stage:           IngestStageEmbedding,
+			Batch:           i + 1,
+			TotalBatches:    totalBatches,
+			ChildChunkCount: len(docs),
+		})
+		batch := docs[i*embeddingBatchSize : end]
+		//子分段也保存到数据库中，更换向量模型重建索引时不需要重新解析文档
+		err = s.repo.createDocumentChunks(ctx, s.buildChildChunks(doc, kb, batch, i*embeddingBatchSize))
+		if err != nil {
+			logs.Errorf("create child chunks error: %v", err)
+			return err
+		}
+		err = store.Store(ctx, batch)
+		if err != nil {
+			logs.Errorf("store documents error: %v", err)
+			return err
+		}
 	}
 	return nil
 }
 
+// buildChildChunks 子分段转换成数据库的记录，ID和向量库中的ID保持一致
+func (s *service) buildChildChunks(doc *model.Document, kb *model.KnowledgeBase, docs []*schema.Document, offset int) []*model.DocumentChunk {
+	chunks := make([]*model.DocumentChunk, 0, len(docs))
+	for i, child := range docs {
+		var parentId *uuid.UUID
+		if id, err := uuid.Parse(fmt.Sprint(child.MetaData["parent_id"])); err == nil {
+			parentId = &id
+		}
+		chunks = append(chunks, &model.DocumentChunk{
+			BaseModel:       model.BaseModel{ID: uuid.MustParse(child.ID)},
+			DocumentID:      doc.ID,
+			KnowledgeBaseID: kb.ID,
+			ParentID:        parentId,
+			ElasticSearchID: child.ID,
+			ChunkIndex:      offset + i,
+			Content:         child.Content,
+			TokenCount:      utils.GetTokenCount(child.Content),
+			MetaInfo:        child.MetaData,
+			Status:          model.ChunkStatusEmbedded,
+		})
+	}
+	return chunks
+}
+
 func (s *service) processDocx(sections []*schema.Document, doc *model.Document, parentModels []*model.DocumentChunk, kb *model.KnowledgeBase, childSchemaDocs []*schema.Document) ([]*model.DocumentChunk, []*schema.Document) {
+	parentChunker, childChunker := chunkers(kb)
 	for _, sec := range sections {
 		//main header footers tables
 		sectionType := sec.MetaData["sectionType"].(string)
 		//构建一个面包屑的前缀，放在内容的前面
 		sectionLabel := s.mapSectionToChinese(sectionType)
 		breadcrumb := fmt.Sprintf("【文档：%s】> 【%s】", doc.Name, sectionLabel)
-		//父分段，这里word文档是直接全部读出来的，我们按照字符进行切分
-		parentTexts := utils.SplitByWindow(sec.Content, 1200, 200)
+		//父分段，这里word文档是直接全部读出来的，按照知识库的切分配置进行切分
+		parentTexts := parentChunker.Split(sec.Content)
 		for i, text := range parentTexts {
 			endContent := breadcrumb + "> " + text
 			parentId := uuid.New()
@@ -847,9 +1372,8 @@ func (s *service) processDocx(sections []*schema.Document, doc *model.Document,
 				Status:          model.ChunkStatusEmbedded,
 			}
 			parentModels = append(parentModels, parentModel)
-			//子分段 这个数值 可以做成可配置的
 			pathPrefix := breadcrumb + "\n"
-			childTexts := utils.SplitByWindow(text, 400, 50)
+			childTexts := childChunker.Split(text)
 			for j, childText := range childTexts {
 				childSchemaDoc := s.buildChildSchemaDoc(parentId, doc, kb, pathPrefix+childText, i, j, 0, nil)
 				childSchemaDocs = append(childSchemaDocs, childSchemaDoc)
@@ -878,10 +1402,12 @@ func (s *service) processPDF(pages []*schema.Document, doc *model.Document, pare
 	if len(pages) == 0 {
 		return parentModels, childSchemaDocs
 	}
-	//自定义去处理整个内容，切分为父分段
-	parentTexts := s.cleanPDFText(pages[0].Content)
+	parentChunker, childChunker := chunkers(kb)
+	//先按标题、章节和句子清洗出段落，再按知识库的切分配置合并或切分成父分段
+	blocks := s.cleanPDFText(pages[0].Content)
+	parentTexts := parentChunker.Split(strings.Join(blocks, "\n\n"))
 	for j, text := range parentTexts {
-		breadcrumb := fmt.Sprintf("【文档：%s】> 【第%d页】", doc.Name, j+1)
+		breadcrumb := fmt.Sprintf("【文档：%s】> 【片段%d】", doc.Name, j+1)
 		endContent := breadcrumb + "\n" + text
 		parentId := uuid.New()
 		parentModel := &model.DocumentChunk{
@@ -893,15 +1419,14 @@ func (s *service) processPDF(pages []*schema.Document, doc *model.Document, pare
 			KnowledgeBaseID: kb.ID,
 			ChunkIndex:      j,
 			MetaInfo: map[string]interface{}{
-				"page": j + 1,
+				"part": j + 1,
 			},
 			TokenCount: utils.GetTokenCount(endContent),
 			Status:     model.ChunkStatusEmbedded,
 		}
 		parentModels = append(parentModels, parentModel)
-		//子分段 这个数值 可以做成可配置的
 		pathPrefix := breadcrumb + "\n"
-		childTexts := utils.SplitByWindow(text, 400, 50)
+		childTexts := childChunker.Split(text)
 		for k, childText := range childTexts {
 			childSchemaDoc := s.buildChildSchemaDoc(parentId, doc, kb, pathPrefix+childText, j, k, 0, nil)
 			childSchemaDocs = append(childSchemaDocs, childSchemaDoc)
@@ -963,6 +1488,7 @@ func (s *service) processHtml(docs []*schema.Document, doc *model.Document, pare
 	if len(docs) == 0 {
 		return parentModels, childSchemaDocs
 	}
+	parentChunker, childChunker := chunkers(kb)
 	htmlDoc := docs[0]
 	htmlContent := htmlDoc.Content
 	if htmlContent == "" {
@@ -1084,7 +1610,7 @@ func (s *service) processHtml(docs []*schema.Document, doc *model.Document, pare
 		parentModels = append(parentModels, parentModel)
 		//子分段切分
 		pathPrefix := breadcrumb + "\n"
-		childTexts := utils.SplitByWindow(content, 400, 50)
+		childTexts := childChunker.Split(content)
 		for k, childText := range childTexts {
 			childSchemaDoc := s.buildChildSchemaDoc(parentId, doc, kb, pathPrefix+childText, parentIndex, k, 0, nil)
 			childSchemaDocs = append(childSchemaDocs, childSchemaDoc)
@@ -1114,8 +1640,8 @@ func (s *service) processHtml(docs []*schema.Document, doc *model.Document, pare
 				buf.WriteString("\n")
 			}
 		}
-		//父块的理想长度
-		if buf.Len() >= 1200 {
+		//达到父分段的长度之后开始新的父分段
+		if parentChunker.Measure(buf.String()) >= parentChunker.Size {
 			flush()
 		}
 	}
@@ -1127,7 +1653,8 @@ func (s *service) processEpub(chapters []*schema.Document, doc *model.Document,
 	if len(chapters) == 0 {
 		return parentModels, childSchemaDocs
 	}
-	for i, chapter := range chapters {
+	parentChunker, childChunker := chunkers(kb)
+	for _, chapter := range chapters {
 		//提取元数据
 		bookTitle := chapter.MetaData["book_title"].(string)
 		if bookTitle == "" {
@@ -1139,108 +1666,113 @@ func (s *service) processEpub(chapters []*schema.Document, doc *model.Document,
 			chapterTitle = "未定义章节"
 		}
 		breadcrumb := fmt.Sprintf("【书名:%s】 > 【章节:%s】", bookTitle, chapterTitle)
-		//章节的内容 可能很多，这里正常是需要进行一下切分，也可以不切分
-		//如果要切分 尽量切的大一些，一般比如小说 字数大概在2000-3000字
-		//parentTexts := utils.SplitByWindow(chapter.Content, 2500, 300)
-		fullParentContent := breadcrumb + "\n" + chapter.Content
-		parentId := uuid.New()
 		//解析复杂标题，比如卷名，章节号 卷号 标题等等
 		parsed := utils.ParseComplexTitle(chapterTitle)
-		parentModel := &model.DocumentChunk{
-			BaseModel: model.BaseModel{
-				ID: parentId,
-			},
-			Content:         fullParentContent,
+		//章节是父分段的边界，超过父分段长度的章节按知识库的切分配置继续切分
+		for _, text := range parentChunker.Split(chapter.Content) {
+			i := len(parentModels)
+			fullParentContent := breadcrumb + "\n" + text
+			parentId := uuid.New()
+			parentModel := &model.DocumentChunk{
+				BaseModel: model.BaseModel{
+					ID: parentId,
+				},
+				Content:         fullParentContent,
+				DocumentID:      doc.ID,
+				KnowledgeBaseID: kb.ID,
+				ChunkIndex:      i,
+				MetaInfo: map[string]interface{}{
+					"chapter_num": parsed.ChapterNum,
+					"volume_num":  parsed.VolumeNum,
+					"volume_name": parsed.VolumeName,
+					"raw_title":   parsed.RawTitle,
+					"full_title":  chapterTitle,
+				},
+				TokenCount: utils.GetTokenCount(fullParentContent),
+				Status:     model.ChunkStatusEmbedded,
+			}
+			parentModels = append(parentModels, parentModel)
+			//生成child
+			childTexts := childChunker.Split(text)
+			for k, childText := range childTexts {
+				childSchemaDoc := s.buildChildSchemaDoc(parentId, doc, kb, breadcrumb+"\n"+childText, i, k, 0, parentModel.MetaInfo)
+				childSchemaDocs = append(childSchemaDocs, childSchemaDoc)
+			}
+		}
+	}
+	return parentModels, childSchemaDocs
+}
+
+// processSpreadsheet 表格的每一行是一个子分段，同一个工作表中相邻的多行合并成一个父分段
+// 元数据中记录工作表和行号，回答时可以引用到具体的行
+func (s *service) processSpreadsheet(rows []*schema.Document, doc *model.Document, parentModels []*model.DocumentChunk, kb *model.KnowledgeBase, childSchemaDocs []*schema.Document) ([]*model.DocumentChunk, []*schema.Document) {
+	parentChunker, childChunker := chunkers(kb)
+	var group []*schema.Document
+	var groupSize int
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		i := len(parentModels)
+		parentId := uuid.New()
+		sheet := fmt.Sprint(group[0].MetaData[kbs.MetaKeySheet])
+		rowStart := group[0].MetaData[kbs.MetaKeyRow]
+		rowEnd := group[len(group)-1].MetaData[kbs.MetaKeyRow]
+		breadcrumb := fmt.Sprintf("【文档:%s】 > 【工作表:%s】", doc.Name, sheet)
+		texts := make([]string, 0, len(group))
+		for _, row := range group {
+			texts = append(texts, fmt.Sprintf("【第%v行】\n%s", row.MetaData[kbs.MetaKeyRow], row.Content))
+		}
+		parentContent := fmt.Sprintf("%s 第%v-%v行\n%s", breadcrumb, rowStart, rowEnd, strings.Join(texts, "\n\n"))
+		parentModels = append(parentModels, &model.DocumentChunk{
+			BaseModel:       model.BaseModel{ID: parentId},
 			DocumentID:      doc.ID,
 			KnowledgeBaseID: kb.ID,
+			Content:         parentContent,
 			ChunkIndex:      i,
 			MetaInfo: map[string]interface{}{
-				"chapter_num": parsed.ChapterNum,
-				"volume_num":  parsed.VolumeNum,
-				"volume_name": parsed.VolumeName,
-				"raw_title":   parsed.RawTitle,
-				"full_title":  chapterTitle,
+				"source":    doc.Name,
+				"file_type": doc.FileType,
+				"type":      "spreadsheet",
+				"sheet":     sheet,
+				"row_start": rowStart,
+				"row_end":   rowEnd,
 			},
-			TokenCount: utils.GetTokenCount(fullParentContent),
+			TokenCount: utils.GetTokenCount(parentContent),
 			Status:     model.ChunkStatusEmbedded,
+		})
+		for j, row := range group {
+			prefix := fmt.Sprintf("%s > 【第%v行】\n", breadcrumb, row.MetaData[kbs.MetaKeyRow])
+			//单行内容过长时再按长度切分
+			subTexts := childChunker.Split(row.Content)
+			for k, text := range subTexts {
+				childSchemaDocs = append(childSchemaDocs, s.buildChildSchemaDoc(parentId, doc, kb, prefix+text, i, j, k, map[string]any{
+					"sheet": sheet,
+					"row":   row.MetaData[kbs.MetaKeyRow],
+				}))
+			}
 		}
-		parentModels = append(parentModels, parentModel)
-		//生成child
-		childTexts := utils.SplitByWindow(chapter.Content, 400, 50)
-		for k, childText := range childTexts {
-			childSchemaDoc := s.buildChildSchemaDoc(parentId, doc, kb, breadcrumb+"\n"+childText, i, k, 0, parentModel.MetaInfo)
-			childSchemaDocs = append(childSchemaDocs, childSchemaDoc)
+		group = nil
+		groupSize = 0
+	}
+	for _, row := range rows {
+		if row.Content == "" {
+			continue
 		}
+		//换了工作表或者超过长度时开始新的父分段
+		if len(group) > 0 && (group[0].MetaData[kbs.MetaKeySheet] != row.MetaData[kbs.MetaKeySheet] ||
+			groupSize+parentChunker.Measure(row.Content) > parentChunker.Size) {
+			flush()
+		}
+		group = append(group, row)
+		groupSize += parentChunker.Measure(row.Content)
 	}
+	flush()
 	return parentModels, childSchemaDocs
 }
 
-type QueryIntent struct {
-	Keywords   string `json:"keywords"`
-	VolumeNum  int    `json:"volume_num"`  //卷号 0 表示未指定
-	ChapterNum int    `json:"chapter_num"` //章节号 0 表示未指定
-	DocName    string `json:"doc_name"`
-}
-
-func (s *service) parseQueryIntent(ctx context.Context, kb *model.KnowledgeBase, query string) (*QueryIntent, error) {
-	//构建提示词
-	prompt := `你是一个结构化数据提取助手。请从用户的提问中提取查询关键词、卷号和章节号。
-规则：
-1. volume_num: 提取"卷"的信息（如：第四卷、卷4）。若未提取到则返回 0。
-2. chapter_num: 提取"章/回/节"的信息（如：第500章、五百回）。若未提取到则返回 0。
-3. keywords: 除去卷和章信息后的核心查询关键词。
-4. 所有的中文数字（如：第四卷、第五百回）必须转换为阿拉伯数字整数（4, 500）。
-5. 必须仅返回 JSON 格式数据。
-
-示例：
-问题："凡人修仙传第四卷风起海外第五百章讲了什么？"
-输出：{"keywords": "讲了什么", "volume_num": 4, "chapter_num": 500}
-
-问题："斗罗大陆第10章唐三的魂环"
-输出：{"keywords": "唐三的魂环", "volume_num": 0, "chapter_num": 10}`
-	//调用知识库关联的对话模型 进行解析
-	chatModel, err := s.getChatModel(kb.ChatModelName, kb.ChatModelProvider)
-	if err != nil {
-		logs.Errorf("getChatModel 获取对话模型失败: %v", err)
-		return nil, err
-	}
-	message, err := chatModel.Generate(ctx, []*schema.Message{
-		{
-			Role:    schema.System,
-			Content: prompt,
-		},
-		{
-			Role:    schema.User,
-			Content: query,
-		},
-	})
-	if err != nil {
-		logs.Errorf("generate 模型生成失败: %v", err)
-		return nil, err
-	}
-	//我们对返回的内容做一些特殊处理，防止返回的内容有md的代码块标签
-	rawJSON := message.Content
-	rawJSON = strings.TrimPrefix(rawJSON, "```json")
-	rawJSON = strings.TrimPrefix(rawJSON, "```")
-	rawJSON = strings.TrimSuffix(rawJSON, "```")
-	rawJSON = strings.TrimSpace(rawJSON)
-	var intent QueryIntent
-	if err := json.Unmarshal([]byte(rawJSON), &intent); err != nil {
-		logs.Errorf("json.Unmarshal 解析失败: %v", err)
-		//兜底 降级处理 返回默认
-		return &QueryIntent{Keywords: query, VolumeNum: 0, ChapterNum: 0}, nil
-	}
-	//如果关键词为空 返回原有的内容
-	if intent.Keywords == "" {
-		intent.Keywords = query
-	}
-	return &intent, nil
-}
-
 func (s *service) getChatModel(modelName string, modelProvider string) (aiModel.ToolCallingChatModel, error) {
 	ctx := context.Background()
-	var chatModel aiModel.ToolCallingChatModel
-	var err error
 	//获取提供商以及模型信息
 	chatProviderConfig, err := s.getProviderConfig(ctx, model.LLMTypeChat, modelProvider, modelName)
 	if err != nil {
@@ -1250,25 +1782,7 @@ func (s *service) getChatModel(modelName string, modelProvider string) (aiModel.
 	if chatProviderConfig == nil {
 		return nil, biz.ErrProviderConfigNotFound
 	}
-	if chatProviderConfig.Provider == model.OllamaProvider {
-		chatModel, err = ollama.NewChatModel(ctx, &ollama.ChatModelConfig{
-			Model:   modelName,
-			BaseURL: chatProviderConfig.APIBase,
-		})
-	} else if chatProviderConfig.Provider == model.QwenProvider {
-		chatModel, err = qwen.NewChatModel(ctx, &qwen.ChatModelConfig{
-			Model:   modelName,
-			BaseURL: chatProviderConfig.APIBase,
-			APIKey:  chatProviderConfig.APIKey,
-		})
-	} else {
-		chatModel, err = openai.NewChatModel(ctx, &openai.ChatModelConfig{
-			Model:   modelName,
-			BaseURL: chatProviderConfig.APIBase,
-			APIKey:  chatProviderConfig.APIKey,
-		})
-	}
-	return chatModel, err
+	return ai.NewChatModel(ctx, chatProviderConfig, modelName, nil)
 }
 
 func (s *service) getProviderConfig(ctx context.Context, llmType model.LLMType, provider string, name string) (*model.ProviderConfig, error) {
@@ -1285,20 +1799,6 @@ func (s *service) getProviderConfig(ctx context.Context, llmType model.LLMType,
 	return result, nil
 }
 
-func (s *service) deleteMilvusIndex(ctx context.Context, kbId uuid.UUID, docId uuid.UUID) error {
-	index := s.buildIndex(kbId)
-	expr := fmt.Sprintf("doc_id=='%s'", docId.String())
-	err := s.milvusClient.Delete(ctx, index, "", expr)
-	if err != nil {
-		if errors.Is(err, client.ErrCollectionNotExists{}) {
-			return nil
-		}
-		logs.Errorf("删除milvus索引失败: %v", err)
-		return err
-	}
-	return nil
-}
-
 func deduplicateParents(parents []string) []string {
 	seen := make(map[string]struct{})
 	var result []string
@@ -1335,6 +1835,39 @@ func looksLikeCode(block string) bool {
 		strings.Contains(block, "{ ") ||
 		strings.Contains(block, "}")
 }
+
+const (
+	localVectorDir = "data/vectors"
+	uploadDir      = "data/uploads"
+)
+
+// storageConfig 原始文件的存储配置，使用 MinIO 时改成 s3 驱动
+var storageConfig = storage.Config{
+	Driver:   storage.DriverLocal,
+	LocalDir: uploadDir,
+	S3: storage.S3Config{
+		Endpoint:        "http://localhost:9000",
+		Region:          "us-east-1",
+		Bucket:          "faber-ai",
+		AccessKeyID:     "minioadmin",
+		SecretAccessKey: "minioadmin",
+	},
+}
+
+var (
+	sharedService     *service
+	sharedServiceOnce sync.Once
+)
+
+// getService 接口和事件共用同一个服务，入库队列和重建索引的恢复任务只启动一次
+func getService() *service {
+	sharedServiceOnce.Do(func() {
+		sharedService = newService()
+		sharedService.start()
+	})
+	return sharedService
+}
+
 func newService() *service {
 	esClient, err := elasticsearch.NewClient(elasticsearch.Config{
 		Addresses: []string{
@@ -1353,14 +1886,42 @@ func newService() *service {
 	if err != nil {
 		panic(err)
 	}
-	return &service{
+	fileStorage, err := storage.New(&storageConfig)
+	if err != nil {
+		panic(err)
+	}
+	s := &service{
 		repo:         newModels(database.GetPostgresDB().GormDB),
 		esClient:     esClient,
 		milvusClient: milvusClient,
-	}
+		localDir:     localVectorDir,
+		storage:      fileStorage,
+		progress:     newProgressHub(),
+	}
+	s.loadEmbedder = s.getEmbeddingConfig
+	s.ingest = newIngestQueue(s.repo, ingestSettings, s.progress, s.ingestDocument)
+	s.reindexCtx, s.reindexCancel = context.WithCancel(context.Background())
+	return s
+}
+
+// start 启动入库队列和重建索引的恢复任务
+func (s *service) start() {
+	s.ingest.start()
+	s.reindexWg.Add(1)
+	go func() {
+		defer s.reindexWg.Done()
+		s.recoverReindexLoop(s.reindexCtx)
+	}()
 }
 
 func (s *service) Close() error {
+	if s.reindexCancel != nil {
+		s.reindexCancel()
+		s.reindexWg.Wait()
+	}
+	if s.ingest != nil {
+		s.ingest.close()
+	}
 	if s.milvusClient != nil {
 		return s.milvusClient.Close()
 	}
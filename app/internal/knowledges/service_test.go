@@ -0,0 +1,518 @@
+package knowledges
+
+import (
+	"bytes"
+	"common/biz"
+	"context"
+	"core/storage"
+	"errors"
+	"mime/multipart"
+	"model"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/config"
+	"github.com/mszlu521/thunder/logs"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	//失败的分支会打印日志，需要先初始化
+	logs.Init(&config.LogConfig{})
+	os.Exit(m.Run())
+}
+
+// fakeEmbedder 按关键词出现的次数生成向量，err 不为空时模拟向量模型调用失败
+type fakeEmbedder struct {
+	err error
+}
+
+var fakeKeywords = []string{"退款", "发票", "物流"}
+
+func (f *fakeEmbedder) EmbedStrings(ctx context.Context, texts []string, opts ...embedding.Option) ([][]float64, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vector := make([]float64, len(fakeKeywords))
+		for j, keyword := range fakeKeywords {
+			vector[j] = float64(strings.Count(text, keyword))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// fakeRepo 内存中的数据，只实现了上传、入库、检索和删除用到的方法
+type fakeRepo struct {
+	repository
+	mu        sync.Mutex
+	kbs       map[uuid.UUID]*model.KnowledgeBase
+	documents map[uuid.UUID]*model.Document
+	chunks    map[uuid.UUID]*model.DocumentChunk
+	jobs      []*model.IngestionJob
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{
+		kbs:       make(map[uuid.UUID]*model.KnowledgeBase),
+		documents: make(map[uuid.UUID]*model.Document),
+		chunks:    make(map[uuid.UUID]*model.DocumentChunk),
+	}
+}
+
+func (r *fakeRepo) getKnowledgeBase(ctx context.Context, userId uuid.UUID, id uuid.UUID) (*model.KnowledgeBase, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kb, ok := r.kbs[id]
+	if !ok || kb.CreatorID != userId {
+		return nil, nil
+	}
+	copied := *kb
+	return &copied, nil
+}
+
+func (r *fakeRepo) createDocument(ctx context.Context, doc *model.Document) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	//数据库中 enabled 的默认值是 true
+	doc.Enabled = true
+	copied := *doc
+	r.documents[doc.ID] = &copied
+	return nil
+}
+
+func (r *fakeRepo) getDocument(ctx context.Context, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) (*model.Document, error) {
+	doc, _ := r.getDocumentById(ctx, documentId)
+	if doc == nil || doc.CreatorID != userId || doc.KnowledgeBaseID != kbId {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+func (r *fakeRepo) getDocumentById(ctx context.Context, id uuid.UUID) (*model.Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc, ok := r.documents[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *doc
+	return &copied, nil
+}
+
+func (r *fakeRepo) getDocumentsByIds(ctx context.Context, ids []uuid.UUID) ([]*model.Document, error) {
+	var docs []*model.Document
+	for _, id := range ids {
+		if doc, _ := r.getDocumentById(ctx, id); doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (r *fakeRepo) getDocumentByHash(ctx context.Context, kbId uuid.UUID, fileHash string) (*model.Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, doc := range r.documents {
+		if doc.KnowledgeBaseID == kbId && doc.FileHash == fileHash {
+			copied := *doc
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) countDocumentsByStorageKey(ctx context.Context, storageKey string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, doc := range r.documents {
+		if doc.StorageKey == storageKey {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeRepo) updateDocumentResult(ctx context.Context, id uuid.UUID, status model.DocumentStatus, errMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if doc, ok := r.documents[id]; ok {
+		doc.Status = status
+		doc.ErrorMessage = errMsg
+	}
+	return nil
+}
+
+func (r *fakeRepo) transaction(ctx context.Context, f func(tx *gorm.DB) error) error {
+	return f(nil)
+}
+
+func (r *fakeRepo) deleteDocuments(ctx context.Context, tx *gorm.DB, userId uuid.UUID, kbId uuid.UUID, documentId uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.documents, documentId)
+	return nil
+}
+
+func (r *fakeRepo) createDocumentChunks(ctx context.Context, chunks []*model.DocumentChunk) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, chunk := range chunks {
+		copied := *chunk
+		r.chunks[chunk.ID] = &copied
+	}
+	return nil
+}
+
+func (r *fakeRepo) deleteDocumentChunks(ctx context.Context, tx *gorm.DB, kbId uuid.UUID, documentId uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, chunk := range r.chunks {
+		if chunk.DocumentID == documentId && chunk.KnowledgeBaseID == kbId {
+			delete(r.chunks, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepo) getDocumentChunksByIds(ctx context.Context, ids []string) ([]*model.DocumentChunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var chunks []*model.DocumentChunk
+	for _, id := range ids {
+		chunk, ok := r.chunks[uuid.MustParse(id)]
+		if !ok || chunk.Status != model.ChunkStatusEmbedded {
+			continue
+		}
+		doc, ok := r.documents[chunk.DocumentID]
+		if !ok || doc.Status != model.DocumentStatusCompleted || !doc.Enabled {
+			continue
+		}
+		copied := *chunk
+		chunks = append(chunks, &copied)
+	}
+	return chunks, nil
+}
+
+func (r *fakeRepo) getDocumentChunk(ctx context.Context, documentId uuid.UUID, id uuid.UUID) (*model.DocumentChunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chunk, ok := r.chunks[id]
+	if !ok || chunk.DocumentID != documentId {
+		return nil, nil
+	}
+	copied := *chunk
+	return &copied, nil
+}
+
+func (r *fakeRepo) listChunkChildren(ctx context.Context, parentId uuid.UUID) ([]*model.DocumentChunk, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var children []*model.DocumentChunk
+	for _, chunk := range r.chunks {
+		if chunk.ParentID != nil && *chunk.ParentID == parentId {
+			copied := *chunk
+			children = append(children, &copied)
+		}
+	}
+	return children, nil
+}
+
+func (r *fakeRepo) replaceChunkChildren(ctx context.Context, parent *model.DocumentChunk, children []*model.DocumentChunk) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *parent
+	r.chunks[parent.ID] = &copied
+	for id, chunk := range r.chunks {
+		if chunk.ParentID != nil && *chunk.ParentID == parent.ID {
+			delete(r.chunks, id)
+		}
+	}
+	for _, child := range children {
+		copied := *child
+		r.chunks[child.ID] = &copied
+	}
+	return nil
+}
+
+func (r *fakeRepo) updateChunkStatus(ctx context.Context, id uuid.UUID, status model.ChunkStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, chunk := range r.chunks {
+		if chunk.ID == id || (chunk.ParentID != nil && *chunk.ParentID == id) {
+			chunk.Status = status
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepo) createIngestionJob(ctx context.Context, job *model.IngestionJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.ID = uuid.New()
+	r.jobs = append(r.jobs, job)
+	return nil
+}
+
+func (r *fakeRepo) claimIngestionJob(ctx context.Context) (*model.IngestionJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range r.jobs {
+		if job.Status == model.IngestionJobPending {
+			now := time.Now()
+			job.Status = model.IngestionJobRunning
+			job.Attempts++
+			job.LockedAt = &now
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) updateIngestionJob(ctx context.Context, job *model.IngestionJob) error {
+	return nil
+}
+
+func (r *fakeRepo) touchIngestionJob(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func newTestService(t *testing.T, repo *fakeRepo) *service {
+	fileStorage, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error = %v", err)
+	}
+	s := &service{
+		repo:     repo,
+		localDir: t.TempDir(),
+		storage:  fileStorage,
+		progress: newProgressHub(),
+		loadEmbedder: func(provider string, modelName string, creatorId uuid.UUID) (embedding.Embedder, error) {
+			return &fakeEmbedder{}, nil
+		},
+	}
+	s.ingest = newIngestQueue(repo, defaultIngestConfig, s.progress, s.ingestDocument)
+	return s
+}
+
+func newUploadFile(t *testing.T, name string, content string) *multipart.FileHeader {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatalf("CreateFormFile() error = %v", err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+	form, err := multipart.NewReader(&buf, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("ReadForm() error = %v", err)
+	}
+	return form.File["file"][0]
+}
+
+// ingestTestDocument 创建使用本地向量存储的知识库，上传并入库一个售后说明文档
+func ingestTestDocument(t *testing.T, ctx context.Context, repo *fakeRepo, s *service) (*model.KnowledgeBase, *model.Document) {
+	userId := uuid.New()
+	kb := &model.KnowledgeBase{
+		BaseModel:          model.BaseModel{ID: uuid.New()},
+		CreatorID:          userId,
+		Name:               "售后",
+		StorageType:        model.StorageTypeLocal,
+		EmbeddingDimension: len(fakeKeywords),
+	}
+	repo.kbs[kb.ID] = kb
+
+	content := "# 退款\n退款需要在收货后七天内申请，退款会原路返回。\n\n# 物流\n物流信息可以在订单详情中查看，物流异常请联系客服。\n"
+	uploaded, err := s.uploadDocuments(ctx, userId, kb.ID, newUploadFile(t, "售后说明.md", content), false)
+	if err != nil {
+		t.Fatalf("uploadDocuments() error = %v", err)
+	}
+	doc := uploaded.(*model.Document)
+	if doc.Status != model.DocumentStatusPending {
+		t.Fatalf("document status = %s, want pending", doc.Status)
+	}
+
+	//入库队列没有启动，这里直接领取任务并执行
+	job, err := repo.claimIngestionJob(ctx)
+	if err != nil || job == nil || job.DocumentID != doc.ID {
+		t.Fatalf("claimIngestionJob() = %v, %v", job, err)
+	}
+	s.ingest.runJob(ctx, job)
+	stored, _ := repo.getDocumentById(ctx, doc.ID)
+	if stored.Status != model.DocumentStatusCompleted {
+		t.Fatalf("document status = %s, error = %s", stored.Status, stored.ErrorMessage)
+	}
+	return kb, stored
+}
+
+// TestDocumentLifecycle 使用本地向量存储走一遍上传、入库、检索和删除
+func TestDocumentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	s := newTestService(t, repo)
+	kb, doc := ingestTestDocument(t, ctx, repo, s)
+	userId := kb.CreatorID
+	children := 0
+	for _, chunk := range repo.chunks {
+		if chunk.ParentID != nil {
+			children++
+		}
+	}
+	if children == 0 {
+		t.Fatal("child chunks are not saved")
+	}
+
+	response, err := s.searchKnowledgeBase(ctx, userId, kb.ID, searchParams{Query: "怎么退款"})
+	if err != nil {
+		t.Fatalf("searchKnowledgeBase() error = %v", err)
+	}
+	if len(response.Results) == 0 || !strings.Contains(response.Results[0].Content, "退款") {
+		t.Fatalf("unexpected search results: %+v", response.Results)
+	}
+	if response.Results[0].Document == nil || response.Results[0].Document.Name != "售后说明.md" {
+		t.Fatalf("search result document = %+v", response.Results[0].Document)
+	}
+
+	err = s.deleteDocuments(ctx, userId, kb.ID, doc.ID)
+	if err != nil {
+		t.Fatalf("deleteDocuments() error = %v", err)
+	}
+	response, err = s.searchKnowledgeBase(ctx, userId, kb.ID, searchParams{Query: "怎么退款"})
+	if err != nil || len(response.Results) != 0 {
+		t.Fatalf("search after delete = %+v, %v", response, err)
+	}
+	exists, err := s.storage.Exists(ctx, doc.StorageKey)
+	if err != nil || exists {
+		t.Fatalf("stored file exists = %v, %v", exists, err)
+	}
+}
+
+// TestSplitDocumentParentConfig markdown、pdf、epub 的父分段也要按知识库的切分配置切分
+func TestSplitDocumentParentConfig(t *testing.T) {
+	s := &service{}
+	kb := &model.KnowledgeBase{
+		BaseModel: model.BaseModel{ID: uuid.New()},
+		ChunkingConfig: model.ChunkingConfig{
+			Strategy:   "recursive",
+			ParentSize: 100,
+			ChildSize:  40,
+		},
+	}
+	paragraph := strings.Repeat("退款需要在收货后七天内申请。", 4)
+	body := strings.Join([]string{paragraph, paragraph, paragraph, paragraph}, "\n\n")
+	tests := []struct {
+		name     string
+		fileType string
+		docs     []*schema.Document
+		//pdf 和 epub 的父分段第一行是面包屑
+		breadcrumb bool
+	}{
+		{
+			name:     "markdown",
+			fileType: ".md",
+			docs:     []*schema.Document{{Content: "## 退款\n" + body}},
+		},
+		{
+			name:       "pdf",
+			fileType:   ".pdf",
+			docs:       []*schema.Document{{Content: body}},
+			breadcrumb: true,
+		},
+		{
+			name:     "epub",
+			fileType: ".epub",
+			docs: []*schema.Document{{
+				Content:  body,
+				MetaData: map[string]any{"book_title": "售后手册", "chapter": "第一章 退款"},
+			}},
+			breadcrumb: true,
+		},
+	}
+	parentChunker, _ := chunkers(kb)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &model.Document{BaseModel: model.BaseModel{ID: uuid.New()}, Name: "售后" + tt.fileType, FileType: tt.fileType}
+			parents, children := s.splitDocument(doc, tt.docs, kb)
+			if len(parents) < 2 || len(children) < len(parents) {
+				t.Fatalf("got %d parents and %d children, want the section split by parent size", len(parents), len(children))
+			}
+			for i, parent := range parents {
+				if parent.ChunkIndex != i {
+					t.Fatalf("parent %d has chunk index %d", i, parent.ChunkIndex)
+				}
+				content := parent.Content
+				if tt.breadcrumb {
+					_, content, _ = strings.Cut(content, "\n")
+				}
+				size := parentChunker.Measure(content)
+				if size > kb.ChunkingConfig.ParentSize {
+					t.Fatalf("parent %d size = %d, want <= %d", i, size, kb.ChunkingConfig.ParentSize)
+				}
+				//相邻的短段落要合并，不能一句话一个父分段
+				if i < len(parents)-1 && size < kb.ChunkingConfig.ParentSize/2 {
+					t.Fatalf("parent %d size = %d, want >= %d", i, size, kb.ChunkingConfig.ParentSize/2)
+				}
+			}
+		})
+	}
+}
+
+// TestUpdateChunkReplacesVectors 修改分段时先写入新的向量，失败时还能检索到修改之前的内容
+func TestUpdateChunkReplacesVectors(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	s := newTestService(t, repo)
+	kb, doc := ingestTestDocument(t, ctx, repo, s)
+	var parent *model.DocumentChunk
+	for _, chunk := range repo.chunks {
+		if chunk.ParentID == nil && strings.Contains(chunk.Content, "退款") {
+			parent = chunk
+		}
+	}
+	if parent == nil {
+		t.Fatal("parent chunk not found")
+	}
+
+	loadEmbedder := s.loadEmbedder
+	s.loadEmbedder = func(provider string, modelName string, creatorId uuid.UUID) (embedding.Embedder, error) {
+		return &fakeEmbedder{err: errors.New("embedding failed")}, nil
+	}
+	_, err := s.updateChunk(ctx, kb.CreatorID, kb.ID, doc.ID, parent.ID, updateChunkReq{Content: "发票在订单完成之后申请。"})
+	if !errors.Is(err, biz.ErrEmbedding) {
+		t.Fatalf("updateChunk() error = %v, want ErrEmbedding", err)
+	}
+	s.loadEmbedder = loadEmbedder
+	response, err := s.searchKnowledgeBase(ctx, kb.CreatorID, kb.ID, searchParams{Query: "怎么退款"})
+	if err != nil || len(response.Results) == 0 || !strings.Contains(response.Results[0].Content, "退款") {
+		t.Fatalf("search after failed update = %+v, %v", response, err)
+	}
+
+	_, err = s.updateChunk(ctx, kb.CreatorID, kb.ID, doc.ID, parent.ID, updateChunkReq{Content: "发票在订单完成之后申请。"})
+	if err != nil {
+		t.Fatalf("updateChunk() error = %v", err)
+	}
+	response, err = s.searchKnowledgeBase(ctx, kb.CreatorID, kb.ID, searchParams{Query: "怎么开发票"})
+	if err != nil || len(response.Results) == 0 || !strings.Contains(response.Results[0].Content, "发票") {
+		t.Fatalf("search after update = %+v, %v", response, err)
+	}
+	//旧的子分段的向量已经删除
+	response, err = s.searchKnowledgeBase(ctx, kb.CreatorID, kb.ID, searchParams{Query: "怎么退款"})
+	if err != nil {
+		t.Fatalf("searchKnowledgeBase() error = %v", err)
+	}
+	for _, result := range response.Results {
+		if strings.Contains(result.Content, "退款") {
+			t.Fatalf("old chunk is still searchable: %+v", result)
+		}
+	}
+}
@@ -21,5 +21,11 @@ func (u *AgentRouter) Register(engine *gin.Engine) {
 		agentsGroup.POST("/:id/tools/batch", agentsHandler.UpdateAgentTool)
 		agentsGroup.POST("/:id/knowledge-bases", agentsHandler.AddAgentKnowledgeBase)
 		agentsGroup.DELETE("/:id/knowledge-bases/:kbId", agentsHandler.DeleteAgentKnowledgeBase)
+		agentsGroup.POST("/:id/sub-agents", agentsHandler.AddAgentSubAgent)
+		agentsGroup.DELETE("/:id/sub-agents/:subAgentId", agentsHandler.DeleteAgentSubAgent)
+		agentsGroup.GET("/:id/conversations", agentsHandler.ListConversations)
+		agentsGroup.GET("/:id/conversations/:conversationId", agentsHandler.GetConversation)
+		agentsGroup.PUT("/:id/conversations/:conversationId", agentsHandler.UpdateConversation)
+		agentsGroup.DELETE("/:id/conversations/:conversationId", agentsHandler.DeleteConversation)
 	}
 }
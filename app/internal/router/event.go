@@ -1,6 +1,8 @@
 package router
 
 import (
+	"app/internal/agents"
+	"app/internal/auths"
 	"app/internal/knowledges"
 	"app/internal/llms"
 	"app/internal/tools"
@@ -21,4 +23,8 @@ func (u *Event) Register() {
 	knowledgeService := knowledges.NewPublicService()
 	event.Register("getKnowledgeBase", knowledgeService.GetKnowledgeBase)
 	event.Register("searchKnowledgeBase", knowledgeService.SearchKnowledgeBase)
+	authService := auths.NewPublicService()
+	event.Register("getUserPlan", authService.GetUserPlan)
+	agentService := agents.NewPublicService()
+	event.Register("runAgent", agentService.RunAgent)
 }
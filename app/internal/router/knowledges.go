@@ -21,9 +21,20 @@ func (u *KnowledgeBaseRouter) Register(engine *gin.Engine) {
 		knowledgesGroup.PUT("/:id", knowledgesHandler.UpdateKnowledgeBase)
 		knowledgesGroup.POST("/:id/search", knowledgesHandler.SearchKnowledgeBase)
 		knowledgesGroup.DELETE("/:id", knowledgesHandler.DeleteKnowledgeBase)
+		knowledgesGroup.POST("/:id/reindex", knowledgesHandler.ReindexKnowledgeBase)
+		knowledgesGroup.GET("/:id/reindex", knowledgesHandler.GetReindexJob)
 		knowledgesGroup.GET("/:id/documents", knowledgesHandler.ListDocuments)
+		knowledgesGroup.GET("/:id/documents/events", knowledgesHandler.DocumentEvents)
 		knowledgesGroup.POST("/:id/documents", knowledgesHandler.UploadDocuments)
+		knowledgesGroup.POST("/:id/documents/preview", knowledgesHandler.PreviewDocument)
+		knowledgesGroup.GET("/:id/documents/:documentId/download", knowledgesHandler.DownloadDocument)
+		knowledgesGroup.PATCH("/:id/documents/:documentId", knowledgesHandler.UpdateDocument)
 		knowledgesGroup.DELETE("/:id/documents/:documentId", knowledgesHandler.DeleteDocuments)
+		knowledgesGroup.GET("/:id/documents/:documentId/chunks", knowledgesHandler.ListChunks)
+		knowledgesGroup.POST("/:id/documents/:documentId/chunks", knowledgesHandler.CreateChunk)
+		knowledgesGroup.GET("/:id/documents/:documentId/chunks/:chunkId", knowledgesHandler.GetChunk)
+		knowledgesGroup.PUT("/:id/documents/:documentId/chunks/:chunkId", knowledgesHandler.UpdateChunk)
+		knowledgesGroup.PUT("/:id/documents/:documentId/chunks/:chunkId/status", knowledgesHandler.UpdateChunkStatus)
 	}
 }
 
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"app/internal/workflows"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WorkflowRouter struct {
+}
+
+func (u *WorkflowRouter) Register(engine *gin.Engine) {
+	workflowsGroup := engine.Group("/api/v1/workflows")
+	{
+		workflowsHandler := workflows.NewHandler()
+		workflowsGroup.POST("/create", workflowsHandler.CreateWorkflow)
+		workflowsGroup.POST("/list", workflowsHandler.ListWorkflows)
+		workflowsGroup.GET("/:id", workflowsHandler.GetWorkflow)
+		workflowsGroup.PUT("/:id", workflowsHandler.UpdateWorkflow)
+		workflowsGroup.DELETE("/:id", workflowsHandler.DeleteWorkflow)
+		workflowsGroup.POST("/:id/run", workflowsHandler.RunWorkflow)
+		workflowsGroup.GET("/:id/runs", workflowsHandler.ListWorkflowRuns)
+		workflowsGroup.GET("/:id/runs/:runId", workflowsHandler.GetWorkflowRun)
+		workflowsGroup.POST("/:id/runs/:runId/replay", workflowsHandler.ReplayWorkflowRun)
+	}
+}
@@ -14,11 +14,7 @@ type Handler struct {
 
 func (h Handler) GetUserSubscription(c *gin.Context) {
 	res.Success(c, &SubscriptionResponse{
-		Configs: &model.PlanConfig{
-			MaxAgents:            10,
-			MaxKnowledgeBaseSize: 10,
-			MaxWorkflows:         10,
-		},
+		Configs:       model.GetPlanConfig(model.FreePlan),
 		Plan:          string(model.FreePlan),
 		ID:            uuid.New(),
 		UserID:        uuid.New(),
@@ -0,0 +1,562 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"model"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/compose"
+)
+
+const (
+	elseHandle = "else" //条件节点没有命中任何条件时走的出口
+	varKey     = "var"  //变量赋值节点写入的命名空间，用 {{var.name}} 引用
+)
+
+// nodeRunner 执行需要调用外部能力的节点，比如大模型、工具、知识库、智能体以及HTTP请求
+type nodeRunner interface {
+	runNode(ctx context.Context, node *model.WorkflowNode, config model.JSON) (*nodeResult, error)
+}
+
+// nodeResult 节点的执行结果
+type nodeResult struct {
+	Output map[string]any
+	// Tokens 节点调用大模型消耗的token数
+	Tokens int
+}
+
+// nodeEvent 节点的执行情况，运行时推送给前端并记录到运行历史
+type nodeEvent struct {
+	Node    *model.WorkflowNode
+	Status  model.WorkflowRunStatus
+	Input   map[string]any
+	Output  map[string]any
+	Tokens  int
+	Err     error
+	Elapsed time.Duration
+}
+
+// runState 一次运行的全局状态，保存每个节点的输出，后面的节点通过模板引用
+type runState struct {
+	Vars map[string]any
+}
+
+// engine 将工作流定义编译成eino的compose.Graph执行
+type engine struct {
+	definition *model.WorkflowDefinition
+	runner     nodeRunner
+	emit       func(event *nodeEvent)
+	// cached 重新运行时复用的节点输出，这些节点不再执行
+	cached map[string]map[string]any
+}
+
+func newEngine(definition *model.WorkflowDefinition, runner nodeRunner, emit func(event *nodeEvent)) *engine {
+	if emit == nil {
+		emit = func(event *nodeEvent) {}
+	}
+	return &engine{
+		definition: definition,
+		runner:     runner,
+		emit:       emit,
+	}
+}
+
+// withCached 设置需要复用输出的节点
+func (e *engine) withCached(cached map[string]map[string]any) *engine {
+	e.cached = cached
+	return e
+}
+
+// run 编译并执行工作流，返回结束节点的输出
+func (e *engine) run(ctx context.Context, inputs map[string]any) (map[string]any, error) {
+	if err := validateDefinition(e.definition); err != nil {
+		return nil, err
+	}
+	runnable, err := e.compile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if inputs == nil {
+		inputs = map[string]any{}
+	}
+	output, err := runnable.Invoke(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	end := findNode(e.definition, model.WorkflowNodeEnd)
+	result, _ := output[end.ID].(map[string]any)
+	return result, nil
+}
+
+func (e *engine) compile(ctx context.Context) (compose.Runnable[map[string]any, map[string]any], error) {
+	g := compose.NewGraph[map[string]any, map[string]any](compose.WithGenLocalState(func(ctx context.Context) *runState {
+		return &runState{Vars: map[string]any{}}
+	}))
+	for _, node := range e.definition.Nodes {
+		err := g.AddLambdaNode(graphKey(node.ID), compose.InvokableLambda(e.buildNode(node)), compose.WithNodeName(node.Name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	//条件节点的出边通过分支连接，其他节点直接连接
+	targets := make(map[string][]*model.WorkflowEdge)
+	for _, edge := range e.definition.Edges {
+		targets[edge.Source] = append(targets[edge.Source], edge)
+	}
+	for _, node := range e.definition.Nodes {
+		switch node.Type {
+		case model.WorkflowNodeStart:
+			if err := g.AddEdge(compose.START, graphKey(node.ID)); err != nil {
+				return nil, err
+			}
+		case model.WorkflowNodeEnd:
+			if err := g.AddEdge(graphKey(node.ID), compose.END); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if node.Type == model.WorkflowNodeCondition {
+			if err := g.AddBranch(graphKey(node.ID), e.buildBranch(node, targets[node.ID])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, target := range uniqueTargets(targets[node.ID]) {
+			if err := g.AddEdge(graphKey(node.ID), graphKey(target)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	//工作流是有向无环图，节点等待所有前驱执行完成(或者因为分支没有选中而跳过)后再执行
+	return g.Compile(ctx, compose.WithNodeTriggerMode(compose.AllPredecessor))
+}
+
+// buildNode 每个节点都包装成一个lambda，输入输出都是 节点ID -> 节点输出
+// 真正的数据通过runState传递，这样多个前驱的输出合并时不会冲突
+func (e *engine) buildNode(node *model.WorkflowNode) func(ctx context.Context, in map[string]any) (map[string]any, error) {
+	return func(ctx context.Context, in map[string]any) (map[string]any, error) {
+		start := time.Now()
+		var vars map[string]any
+		err := compose.ProcessState(ctx, func(ctx context.Context, state *runState) error {
+			if node.Type == model.WorkflowNodeStart {
+				state.Vars[node.ID] = in
+			}
+			vars = copyVars(state.Vars)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		var input map[string]any
+		if node.Type == model.WorkflowNodeStart {
+			input = in
+		} else {
+			input, _ = renderValue(map[string]any(node.Config), vars).(map[string]any)
+		}
+		status := model.WorkflowRunSucceeded
+		result := &nodeResult{}
+		if output, ok := e.cached[node.ID]; ok {
+			//重新运行时，上游已经成功的节点直接使用上一次的输出
+			status = model.WorkflowRunCached
+			result.Output = output
+		} else {
+			e.emit(&nodeEvent{Node: node, Status: model.WorkflowRunRunning, Input: input})
+			result, err = e.execute(ctx, node, vars, input)
+			if err != nil {
+				e.emit(&nodeEvent{Node: node, Status: model.WorkflowRunFailed, Input: input, Err: err, Elapsed: time.Since(start)})
+				return nil, fmt.Errorf("节点[%s]执行失败: %w", node.Name, err)
+			}
+		}
+		err = compose.ProcessState(ctx, func(ctx context.Context, state *runState) error {
+			state.Vars[node.ID] = result.Output
+			if node.Type == model.WorkflowNodeVariable {
+				assigned, _ := state.Vars[varKey].(map[string]any)
+				if assigned == nil {
+					assigned = map[string]any{}
+				}
+				for k, v := range result.Output {
+					assigned[k] = v
+				}
+				state.Vars[varKey] = assigned
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		e.emit(&nodeEvent{Node: node, Status: status, Input: input, Output: result.Output, Tokens: result.Tokens, Elapsed: time.Since(start)})
+		return map[string]any{node.ID: result.Output}, nil
+	}
+}
+
+// execute 执行节点，config是已经渲染过变量的节点配置
+func (e *engine) execute(ctx context.Context, node *model.WorkflowNode, vars map[string]any, config map[string]any) (*nodeResult, error) {
+	var output map[string]any
+	var err error
+	switch node.Type {
+	case model.WorkflowNodeStart:
+		output, err = e.executeStart(node, vars)
+	case model.WorkflowNodeEnd:
+		output, _ = config["outputs"].(map[string]any)
+		if output == nil {
+			output = map[string]any{}
+		}
+	case model.WorkflowNodeVariable:
+		output, err = executeVariable(node, vars)
+	case model.WorkflowNodeCondition:
+		var c conditionConfig
+		if err := decodeConfig(node.Config, &c); err != nil {
+			return nil, err
+		}
+		output = map[string]any{"branch": c.evaluate(vars)}
+	default:
+		//其他节点的配置中可以引用变量，渲染后交给执行器
+		return e.runner.runNode(ctx, node, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &nodeResult{Output: output}, nil
+}
+
+// executeStart 校验必填的输入并补上默认值
+func (e *engine) executeStart(node *model.WorkflowNode, vars map[string]any) (map[string]any, error) {
+	inputs, _ := vars[node.ID].(map[string]any)
+	output := make(map[string]any, len(inputs))
+	for k, v := range inputs {
+		output[k] = v
+	}
+	var config startConfig
+	if err := decodeConfig(node.Config, &config); err != nil {
+		return nil, err
+	}
+	for _, v := range config.Variables {
+		if _, ok := output[v.Name]; ok {
+			continue
+		}
+		if v.Default != nil {
+			output[v.Name] = v.Default
+			continue
+		}
+		if v.Required {
+			return nil, fmt.Errorf("缺少必填的输入: %s", v.Name)
+		}
+	}
+	return output, nil
+}
+
+func executeVariable(node *model.WorkflowNode, vars map[string]any) (map[string]any, error) {
+	var config variableConfig
+	if err := decodeConfig(node.Config, &config); err != nil {
+		return nil, err
+	}
+	output := make(map[string]any, len(config.Assignments))
+	for _, v := range config.Assignments {
+		if v.Name == "" {
+			continue
+		}
+		output[v.Name] = renderValue(v.Value, vars)
+	}
+	return output, nil
+}
+
+// buildBranch 条件节点根据命中的条件选择出口，同一个出口可以连接多个节点
+func (e *engine) buildBranch(node *model.WorkflowNode, edges []*model.WorkflowEdge) *compose.GraphBranch {
+	endNodes := make(map[string]bool)
+	for _, edge := range edges {
+		endNodes[graphKey(edge.Target)] = true
+	}
+	return compose.NewGraphMultiBranch(func(ctx context.Context, in map[string]any) (map[string]bool, error) {
+		output, _ := in[node.ID].(map[string]any)
+		handle, _ := output["branch"].(string)
+		selected := make(map[string]bool)
+		for _, edge := range edges {
+			if edge.SourceHandle == handle || (edge.SourceHandle == "" && handle == elseHandle) {
+				selected[graphKey(edge.Target)] = true
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("条件节点[%s]的出口[%s]没有连接下一个节点", node.Name, handle)
+		}
+		return selected, nil
+	}, endNodes)
+}
+
+type startConfig struct {
+	Variables []struct {
+		Name     string `json:"name"`
+		Required bool   `json:"required"`
+		Default  any    `json:"default"`
+	} `json:"variables"`
+}
+
+type variableConfig struct {
+	Assignments []struct {
+		Name  string `json:"name"`
+		Value any    `json:"value"`
+	} `json:"assignments"`
+}
+
+type conditionConfig struct {
+	Conditions []*condition `json:"conditions"`
+}
+
+// condition 条件分支中的一个条件，Variable通常是 {{节点ID.字段}} 的引用
+type condition struct {
+	ID       string `json:"id"`
+	Variable string `json:"variable"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// evaluate 按顺序判断条件，返回第一个命中的条件ID，都没有命中返回else
+func (c *conditionConfig) evaluate(vars map[string]any) string {
+	for _, cond := range c.Conditions {
+		if cond.match(vars) {
+			return cond.ID
+		}
+	}
+	return elseHandle
+}
+
+func (c *condition) match(vars map[string]any) bool {
+	left := toString(renderValue(c.Variable, vars))
+	right := toString(renderValue(c.Value, vars))
+	switch c.Operator {
+	case "eq":
+		return left == right
+	case "ne":
+		return left != right
+	case "contains":
+		return strings.Contains(left, right)
+	case "not_contains":
+		return !strings.Contains(left, right)
+	case "empty":
+		return strings.TrimSpace(left) == ""
+	case "not_empty":
+		return strings.TrimSpace(left) != ""
+	case "gt", "gte", "lt", "lte":
+		l, err1 := strconv.ParseFloat(strings.TrimSpace(left), 64)
+		r, err2 := strconv.ParseFloat(strings.TrimSpace(right), 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		switch c.Operator {
+		case "gt":
+			return l > r
+		case "gte":
+			return l >= r
+		case "lt":
+			return l < r
+		default:
+			return l <= r
+		}
+	}
+	return false
+}
+
+// validateDefinition 校验工作流定义：有且只有一个开始和结束节点，连线合法，并且不能有环
+func validateDefinition(definition *model.WorkflowDefinition) error {
+	if definition == nil || len(definition.Nodes) == 0 {
+		return errors.New("工作流没有节点")
+	}
+	nodes := make(map[string]*model.WorkflowNode)
+	counts := make(map[model.WorkflowNodeType]int)
+	for _, node := range definition.Nodes {
+		if node.ID == "" {
+			return errors.New("节点ID不能为空")
+		}
+		if _, ok := nodes[node.ID]; ok {
+			return fmt.Errorf("节点ID重复: %s", node.ID)
+		}
+		switch node.Type {
+		case model.WorkflowNodeStart, model.WorkflowNodeEnd, model.WorkflowNodeLLM, model.WorkflowNodeTool,
+			model.WorkflowNodeKnowledge, model.WorkflowNodeCondition, model.WorkflowNodeVariable,
+			model.WorkflowNodeAgent, model.WorkflowNodeHttp:
+		default:
+			return fmt.Errorf("未知的节点类型: %s", node.Type)
+		}
+		nodes[node.ID] = node
+		counts[node.Type]++
+	}
+	if counts[model.WorkflowNodeStart] != 1 || counts[model.WorkflowNodeEnd] != 1 {
+		return errors.New("工作流必须有且只有一个开始节点和一个结束节点")
+	}
+	successors := make(map[string][]string)
+	edgeSet := make(map[string]bool)
+	for _, edge := range definition.Edges {
+		source, ok1 := nodes[edge.Source]
+		target, ok2 := nodes[edge.Target]
+		if !ok1 || !ok2 {
+			return fmt.Errorf("连线的节点不存在: %s -> %s", edge.Source, edge.Target)
+		}
+		if source.Type == model.WorkflowNodeEnd || target.Type == model.WorkflowNodeStart {
+			return fmt.Errorf("非法的连线: %s -> %s", edge.Source, edge.Target)
+		}
+		key := edge.Source + "->" + edge.Target
+		if edgeSet[key] {
+			continue
+		}
+		edgeSet[key] = true
+		successors[edge.Source] = append(successors[edge.Source], edge.Target)
+	}
+	//从开始节点出发，所有节点都要能到达，同时检查是否有环
+	start := findNode(definition, model.WorkflowNodeStart)
+	visited := make(map[string]int) //1 访问中 2 已完成
+	var visit func(id string) error
+	visit = func(id string) error {
+		visited[id] = 1
+		for _, next := range successors[id] {
+			switch visited[next] {
+			case 1:
+				return fmt.Errorf("工作流中存在环: %s -> %s", id, next)
+			case 0:
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+		visited[id] = 2
+		return nil
+	}
+	if err := visit(start.ID); err != nil {
+		return err
+	}
+	for _, node := range definition.Nodes {
+		if visited[node.ID] == 0 {
+			return fmt.Errorf("节点[%s]没有连接到开始节点", node.Name)
+		}
+		if node.Type != model.WorkflowNodeEnd && len(successors[node.ID]) == 0 {
+			return fmt.Errorf("节点[%s]没有连接下一个节点", node.Name)
+		}
+	}
+	return nil
+}
+
+// uniqueTargets 普通节点的出口没有区分，同一个目标节点只连接一次
+func uniqueTargets(edges []*model.WorkflowEdge) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, edge := range edges {
+		if !seen[edge.Target] {
+			seen[edge.Target] = true
+			result = append(result, edge.Target)
+		}
+	}
+	return result
+}
+
+// graphKey 图中节点的key，加上前缀避免和compose保留的start、end冲突
+func graphKey(id string) string {
+	return "node_" + id
+}
+
+func findNode(definition *model.WorkflowDefinition, nodeType model.WorkflowNodeType) *model.WorkflowNode {
+	for _, node := range definition.Nodes {
+		if node.Type == nodeType {
+			return node
+		}
+	}
+	return nil
+}
+
+func decodeConfig(config model.JSON, v any) error {
+	if config == nil {
+		return nil
+	}
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+func copyVars(vars map[string]any) map[string]any {
+	copied := make(map[string]any, len(vars))
+	for k, v := range vars {
+		copied[k] = v
+	}
+	return copied
+}
+
+var templateRegexp = regexp.MustCompile(`\{\{\s*([\w\-]+(?:\.[\w\-]+)*)\s*\}\}`)
+
+// renderValue 替换配置中的 {{节点ID.字段}} 引用，map和数组会递归处理
+// 如果字符串只有一个引用，直接返回引用的原始值，这样数字、对象等类型不会变成字符串
+func renderValue(value any, vars map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if match := templateRegexp.FindStringSubmatch(v); match != nil && match[0] == strings.TrimSpace(v) {
+			return lookup(vars, match[1])
+		}
+		return templateRegexp.ReplaceAllStringFunc(v, func(s string) string {
+			path := templateRegexp.FindStringSubmatch(s)[1]
+			return toString(lookup(vars, path))
+		})
+	case map[string]any:
+		rendered := make(map[string]any, len(v))
+		for k, item := range v {
+			rendered[k] = renderValue(item, vars)
+		}
+		return rendered
+	case []any:
+		rendered := make([]any, len(v))
+		for i, item := range v {
+			rendered[i] = renderValue(item, vars)
+		}
+		return rendered
+	default:
+		return value
+	}
+}
+
+// lookup 按照 a.b.0.c 的路径查找变量，找不到返回nil
+func lookup(vars map[string]any, path string) any {
+	var current any = vars
+	for _, key := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]any:
+			current = v[key]
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			current = v[index]
+		case []string:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			current = v[index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int64, bool:
+		return fmt.Sprint(v)
+	default:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(bytes)
+	}
+}
@@ -0,0 +1,113 @@
+package workflows
+
+import (
+	"context"
+	"model"
+	"sync"
+	"testing"
+)
+
+type fakeRunner struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeRunner) runNode(ctx context.Context, node *model.WorkflowNode, config model.JSON) (*nodeResult, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, node.ID)
+	f.mu.Unlock()
+	return &nodeResult{Output: map[string]any{"text": config["prompt"]}, Tokens: 10}, nil
+}
+
+func buildDefinition() *model.WorkflowDefinition {
+	return &model.WorkflowDefinition{
+		Nodes: []*model.WorkflowNode{
+			{ID: "start", Type: model.WorkflowNodeStart, Name: "开始", Config: model.JSON{
+				"variables": []any{map[string]any{"name": "query", "required": true}},
+			}},
+			{ID: "var_1", Type: model.WorkflowNodeVariable, Name: "变量", Config: model.JSON{
+				"assignments": []any{map[string]any{"name": "greeting", "value": "你好，{{start.query}}"}},
+			}},
+			{ID: "cond_1", Type: model.WorkflowNodeCondition, Name: "条件", Config: model.JSON{
+				"conditions": []any{map[string]any{"id": "refund", "variable": "{{start.query}}", "operator": "contains", "value": "退款"}},
+			}},
+			{ID: "llm_refund", Type: model.WorkflowNodeLLM, Name: "退款", Config: model.JSON{"prompt": "退款:{{var.greeting}}"}},
+			{ID: "llm_other", Type: model.WorkflowNodeLLM, Name: "其他", Config: model.JSON{"prompt": "其他:{{var.greeting}}"}},
+			{ID: "end", Type: model.WorkflowNodeEnd, Name: "结束", Config: model.JSON{
+				"outputs": map[string]any{"answer": "{{llm_refund.text}}{{llm_other.text}}"},
+			}},
+		},
+		Edges: []*model.WorkflowEdge{
+			{Source: "start", Target: "var_1"},
+			{Source: "var_1", Target: "cond_1"},
+			{Source: "cond_1", Target: "llm_refund", SourceHandle: "refund"},
+			{Source: "cond_1", Target: "llm_other", SourceHandle: "else"},
+			{Source: "llm_refund", Target: "end"},
+			{Source: "llm_other", Target: "end"},
+		},
+	}
+}
+
+func TestEngineRun(t *testing.T) {
+	runner := &fakeRunner{}
+	var mu sync.Mutex
+	statuses := make(map[string]model.WorkflowRunStatus)
+	e := newEngine(buildDefinition(), runner, func(event *nodeEvent) {
+		mu.Lock()
+		statuses[event.Node.ID] = event.Status
+		mu.Unlock()
+	})
+	output, err := e.run(context.Background(), map[string]any{"query": "我要退款"})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if output["answer"] != "退款:你好，我要退款" {
+		t.Fatalf("unexpected output: %v", output)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "llm_refund" {
+		t.Fatalf("only the selected branch should run, got %v", runner.calls)
+	}
+	if statuses["end"] != model.WorkflowRunSucceeded || statuses["llm_other"] != "" {
+		t.Fatalf("unexpected node statuses: %v", statuses)
+	}
+
+	//复用上游节点的输出重新运行，只执行被选中的分支
+	runner.calls = nil
+	cached := map[string]map[string]any{
+		"start":  {"query": "我要退款"},
+		"var_1":  {"greeting": "缓存"},
+		"cond_1": {"branch": "refund"},
+	}
+	output, err = newEngine(buildDefinition(), runner, nil).withCached(cached).run(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatalf("replay error = %v", err)
+	}
+	if output["answer"] != "退款:缓存" || len(runner.calls) != 1 {
+		t.Fatalf("unexpected replay output: %v calls=%v", output, runner.calls)
+	}
+
+	//缺少必填的输入
+	_, err = newEngine(buildDefinition(), runner, nil).run(context.Background(), nil)
+	if err == nil {
+		t.Fatalf("expected error for missing required input")
+	}
+}
+
+func TestValidateDefinition(t *testing.T) {
+	definition := buildDefinition()
+	if err := validateDefinition(definition); err != nil {
+		t.Fatalf("validateDefinition() error = %v", err)
+	}
+	//形成环
+	definition.Edges = append(definition.Edges, &model.WorkflowEdge{Source: "llm_other", Target: "var_1"})
+	if err := validateDefinition(definition); err == nil {
+		t.Fatalf("expected cycle error")
+	}
+	//没有结束节点
+	definition = buildDefinition()
+	definition.Nodes = definition.Nodes[:len(definition.Nodes)-1]
+	definition.Edges = definition.Edges[:4]
+	if err := validateDefinition(definition); err == nil {
+		t.Fatalf("expected missing end node error")
+	}
+}
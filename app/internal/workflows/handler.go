@@ -0,0 +1,256 @@
+package workflows
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/logs"
+	"github.com/mszlu521/thunder/req"
+	"github.com/mszlu521/thunder/res"
+)
+
+type Handler struct {
+	service *service
+}
+
+func (h *Handler) CreateWorkflow(c *gin.Context) {
+	var createReq createWorkflowReq
+	if err := req.JsonParam(c, &createReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.createWorkflow(c.Request.Context(), userID, createReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) ListWorkflows(c *gin.Context) {
+	var listReq searchWorkflowReq
+	if err := req.JsonParam(c, &listReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.listWorkflows(c.Request.Context(), userID, listReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) GetWorkflow(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.getWorkflow(c.Request.Context(), userID, id)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) UpdateWorkflow(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	var updateReq updateWorkflowReq
+	if err := req.JsonParam(c, &updateReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.updateWorkflow(c.Request.Context(), userID, id, updateReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) DeleteWorkflow(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	err := h.service.deleteWorkflow(c.Request.Context(), userID, id)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, nil)
+}
+
+func (h *Handler) RunWorkflow(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	var runReq runWorkflowReq
+	if err := req.JsonParam(c, &runReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	datachan, errchan := h.service.runWorkflow(ctx, userID, id, runReq)
+	h.writeStream(c, ctx, cancel, datachan, errchan)
+}
+
+func (h *Handler) ListWorkflowRuns(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	var listReq listWorkflowRunReq
+	if err := req.QueryParam(c, &listReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.listWorkflowRuns(c.Request.Context(), userID, id, listReq)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) GetWorkflowRun(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	var runId uuid.UUID
+	if err := req.Path(c, "runId", &runId); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	resp, err := h.service.getWorkflowRun(c.Request.Context(), userID, id, runId)
+	if err != nil {
+		res.Error(c, err)
+		return
+	}
+	res.Success(c, resp)
+}
+
+func (h *Handler) ReplayWorkflowRun(c *gin.Context) {
+	var id uuid.UUID
+	if err := req.Path(c, "id", &id); err != nil {
+		return
+	}
+	var runId uuid.UUID
+	if err := req.Path(c, "runId", &runId); err != nil {
+		return
+	}
+	var replayReq replayWorkflowRunReq
+	if err := req.JsonParam(c, &replayReq); err != nil {
+		return
+	}
+	userID, ok := req.GetUserIdUUID(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	datachan, errchan := h.service.replayWorkflowRun(ctx, userID, id, runId, replayReq)
+	h.writeStream(c, ctx, cancel, datachan, errchan)
+}
+
+// writeStream 将运行过程通过SSE推送给客户端，格式和智能体对话保持一致
+func (h *Handler) writeStream(c *gin.Context, ctx context.Context, cancel context.CancelFunc, datachan <-chan string, errchan <-chan error) {
+	//工作流运行时间比较长，不能使用全局的超时
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logs.Warnf("SetWriteDeadline error: %v", err)
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	heartbeat := time.NewTicker(time.Second * 5)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logs.Warnf("context done, 客户端断开连接")
+			return
+		case <-heartbeat.C:
+			_, err := c.Writer.Write([]byte(": keep-alive\n\n"))
+			if err != nil {
+				logs.Warnf("write heartbeat error: %v", err)
+				cancel()
+				return
+			}
+			c.Writer.Flush()
+		case data, ok := <-datachan:
+			if !ok {
+				_, err := c.Writer.Write([]byte("data: [DONE]\n\n"))
+				if err != nil {
+					logs.Warnf("write done error: %v", err)
+				}
+				c.Writer.Flush()
+				return
+			}
+			_, err := c.Writer.Write([]byte("data: " + data + "\n\n"))
+			if err != nil {
+				logs.Errorf("write data error: %v", err)
+				cancel()
+				return
+			}
+			c.Writer.Flush()
+		case err, ok := <-errchan:
+			if !ok {
+				errchan = nil
+				continue
+			}
+			if err != nil {
+				_, err := c.Writer.Write([]byte("data: [ERROR]" + err.Error() + "\n\n"))
+				if err != nil {
+					logs.Errorf("write error error: %v", err)
+					cancel()
+					return
+				}
+				c.Writer.Flush()
+				return
+			}
+		}
+	}
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		service: newService(),
+	}
+}
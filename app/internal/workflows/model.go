@@ -0,0 +1,119 @@
+package workflows
+
+import (
+	"context"
+	"model"
+
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/gorms"
+	"gorm.io/gorm"
+)
+
+type models struct {
+	db *gorm.DB
+}
+
+func (m *models) createWorkflow(ctx context.Context, workflow *model.Workflow) error {
+	return m.db.WithContext(ctx).Create(workflow).Error
+}
+
+func (m *models) countWorkflows(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&model.Workflow{}).Where("creator_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (m *models) listWorkflows(ctx context.Context, userID uuid.UUID, filter WorkflowFilter) ([]*model.Workflow, int64, error) {
+	var workflows []*model.Workflow
+	var count int64
+	query := m.db.WithContext(ctx).Model(&model.Workflow{})
+	query = query.Where("creator_id = ?", userID)
+	if filter.Name != "" {
+		query = query.Where("name like ?", "%"+filter.Name+"%")
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	query = query.Count(&count)
+	//列表不需要返回节点定义
+	query = query.Omit("definition").Order("updated_at desc").Limit(filter.Limit).Offset(filter.Offset)
+	return workflows, count, query.Find(&workflows).Error
+}
+
+type WorkflowFilter struct {
+	Name   string
+	Status model.WorkflowStatus
+	Limit  int
+	Offset int
+}
+
+func (m *models) getWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID) (*model.Workflow, error) {
+	var workflow model.Workflow
+	err := m.db.WithContext(ctx).Where("id = ? and creator_id = ?", id, userID).First(&workflow).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &workflow, err
+}
+
+func (m *models) updateWorkflow(ctx context.Context, workflow *model.Workflow) error {
+	return m.db.WithContext(ctx).Updates(workflow).Error
+}
+
+func (m *models) deleteWorkflow(ctx context.Context, id uuid.UUID) error {
+	return m.db.WithContext(ctx).Where("id = ?", id).Delete(&model.Workflow{}).Error
+}
+
+func (m *models) createWorkflowRun(ctx context.Context, run *model.WorkflowRun) error {
+	return m.db.WithContext(ctx).Create(run).Error
+}
+
+func (m *models) updateWorkflowRun(ctx context.Context, run *model.WorkflowRun) error {
+	return m.db.WithContext(ctx).Updates(run).Error
+}
+
+func (m *models) getWorkflowRun(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, id uuid.UUID) (*model.WorkflowRun, error) {
+	var run model.WorkflowRun
+	err := m.db.WithContext(ctx).
+		Where("id = ? and workflow_id = ? and creator_id = ?", id, workflowId, userID).
+		First(&run).Error
+	if gorms.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	return &run, err
+}
+
+func (m *models) listWorkflowRuns(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, filter WorkflowRunFilter) ([]*model.WorkflowRun, int64, error) {
+	var runs []*model.WorkflowRun
+	var count int64
+	query := m.db.WithContext(ctx).Model(&model.WorkflowRun{})
+	query = query.Where("workflow_id = ? and creator_id = ?", workflowId, userID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	query = query.Count(&count)
+	query = query.Omit("definition").Order("created_at desc").Limit(filter.Limit).Offset(filter.Offset)
+	return runs, count, query.Find(&runs).Error
+}
+
+type WorkflowRunFilter struct {
+	Status model.WorkflowRunStatus
+	Limit  int
+	Offset int
+}
+
+func (m *models) createNodeRun(ctx context.Context, nodeRun *model.NodeRun) error {
+	return m.db.WithContext(ctx).Create(nodeRun).Error
+}
+
+func (m *models) listNodeRuns(ctx context.Context, runId uuid.UUID) ([]*model.NodeRun, error) {
+	var nodeRuns []*model.NodeRun
+	err := m.db.WithContext(ctx).Where("run_id = ?", runId).Order("created_at asc").Find(&nodeRuns).Error
+	return nodeRuns, err
+}
+
+func newModels(db *gorm.DB) *models {
+	return &models{
+		db: db,
+	}
+}
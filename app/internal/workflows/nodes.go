@@ -0,0 +1,404 @@
+package workflows
+
+import (
+	"app/shared"
+	"bytes"
+	"common/biz"
+	"context"
+	"core/ai"
+	"core/ai/mcps"
+	"core/ai/tools"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"model"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	aiModel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/ai/einos"
+	"github.com/mszlu521/thunder/event"
+	"github.com/mszlu521/thunder/logs"
+)
+
+const (
+	defaultHttpTimeout = 30 * time.Second
+	maxHttpTimeout     = 2 * time.Minute //节点配置的超时时间不能超过这个值
+	maxHttpBodySize    = 1 << 20         //HTTP节点最多读取1M的响应内容
+	maxHttpRedirects   = 5               //最多跟随的重定向次数
+)
+
+type llmConfig struct {
+	Provider        string     `json:"provider"`
+	ModelName       string     `json:"modelName"`
+	ModelParameters model.JSON `json:"modelParameters"`
+	SystemPrompt    string     `json:"systemPrompt"`
+	Prompt          string     `json:"prompt"`
+}
+
+type toolConfig struct {
+	ToolID uuid.UUID `json:"toolId"`
+	// ToolName mcp工具需要指定具体调用哪一个工具
+	ToolName  string         `json:"toolName"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+type knowledgeConfig struct {
+	KnowledgeBaseIDs []uuid.UUID `json:"knowledgeBaseIds"`
+	Query            string      `json:"query"`
+}
+
+type agentConfig struct {
+	AgentID uuid.UUID `json:"agentId"`
+	Input   string    `json:"input"`
+}
+
+type httpConfig struct {
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    any               `json:"body"`
+	// Timeout 超时时间，单位秒
+	Timeout int `json:"timeout"`
+}
+
+// nodeExecutor 执行需要调用其他模块或者外部服务的节点
+type nodeExecutor struct {
+	service *service
+	userId  uuid.UUID
+}
+
+func (n *nodeExecutor) runNode(ctx context.Context, node *model.WorkflowNode, config model.JSON) (*nodeResult, error) {
+	switch node.Type {
+	case model.WorkflowNodeLLM:
+		return n.runLLM(ctx, config)
+	case model.WorkflowNodeTool:
+		return n.runTool(ctx, config)
+	case model.WorkflowNodeKnowledge:
+		return n.runKnowledge(ctx, config)
+	case model.WorkflowNodeAgent:
+		return n.runAgent(ctx, config)
+	case model.WorkflowNodeHttp:
+		return n.runHttp(ctx, config)
+	}
+	return nil, fmt.Errorf("不支持的节点类型: %s", node.Type)
+}
+
+func (n *nodeExecutor) runLLM(ctx context.Context, config model.JSON) (*nodeResult, error) {
+	var c llmConfig
+	if err := decodeConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if c.Prompt == "" {
+		return nil, errors.New("提示词不能为空")
+	}
+	chatModel, err := n.service.getChatModel(ctx, c.Provider, c.ModelName, c.ModelParameters.ToModelParams())
+	if err != nil {
+		return nil, err
+	}
+	var messages []*schema.Message
+	if c.SystemPrompt != "" {
+		messages = append(messages, schema.SystemMessage(c.SystemPrompt))
+	}
+	messages = append(messages, schema.UserMessage(c.Prompt))
+	message, err := chatModel.Generate(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	result := &nodeResult{
+		Output: map[string]any{
+			"text":             message.Content,
+			"reasoningContent": message.ReasoningContent,
+		},
+	}
+	if message.ResponseMeta != nil && message.ResponseMeta.Usage != nil {
+		result.Tokens = message.ResponseMeta.Usage.TotalTokens
+	}
+	return result, nil
+}
+
+func (n *nodeExecutor) runTool(ctx context.Context, config model.JSON) (*nodeResult, error) {
+	var c toolConfig
+	if err := decodeConfig(config, &c); err != nil {
+		return nil, err
+	}
+	trigger, err := event.Trigger("getToolsByIds", &shared.GetToolsByIdsRequest{
+		Ids: []uuid.UUID{c.ToolID},
+	})
+	if err != nil {
+		logs.Errorf("触发getToolsByIds事件失败: %v", err)
+		return nil, err
+	}
+	toolsList := trigger.([]*model.Tool)
+	if len(toolsList) == 0 {
+		return nil, biz.ErrToolNotExisted
+	}
+	t, err := n.loadTool(ctx, toolsList[0], c.ToolName)
+	if err != nil {
+		return nil, err
+	}
+	invokable, ok := t.(tool.InvokableTool)
+	if !ok {
+		return nil, fmt.Errorf("工具不支持调用: %s", toolsList[0].Name)
+	}
+	if c.Arguments == nil {
+		c.Arguments = map[string]any{}
+	}
+	arguments, err := json.Marshal(c.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	result, err := invokable.InvokableRun(ctx, string(arguments))
+	if err != nil {
+		return nil, err
+	}
+	output := map[string]any{"result": result}
+	//工具返回json的时候解析出来，方便后面的节点引用具体的字段
+	var parsed any
+	if json.Unmarshal([]byte(result), &parsed) == nil {
+		output["json"] = parsed
+	}
+	return &nodeResult{Output: output}, nil
+}
+
+func (n *nodeExecutor) loadTool(ctx context.Context, t *model.Tool, toolName string) (tool.BaseTool, error) {
+	switch t.ToolType {
+	case model.SystemToolType:
+		systemTool := tools.FindTool(t.Name)
+		if systemTool == nil {
+			return nil, biz.ErrToolNotExisted
+		}
+		return systemTool, nil
+	case model.McpToolType:
+		if t.McpConfig == nil {
+			return nil, biz.ErrMcpConfigNotExisted
+		}
+		baseTools, err := mcps.GetEinoBaseTools(ctx, &einos.McpConfig{
+			BaseUrl: t.McpConfig.Url,
+			Token:   t.McpConfig.CredentialType,
+			Name:    "mszlu-AI",
+			Version: "1.0.0",
+		})
+		if err != nil {
+			logs.Errorf("获取mcp tools失败: %v", err)
+			return nil, biz.ErrGetMcpTools
+		}
+		if toolName == "" {
+			toolName = t.Name
+		}
+		for _, v := range baseTools {
+			info, err := v.Info(ctx)
+			if err == nil && info.Name == toolName {
+				return v, nil
+			}
+		}
+		return nil, biz.ErrToolNotExisted
+	}
+	return nil, fmt.Errorf("未知的工具类型: %v", t.ToolType)
+}
+
+func (n *nodeExecutor) runKnowledge(ctx context.Context, config model.JSON) (*nodeResult, error) {
+	var c knowledgeConfig
+	if err := decodeConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(c.Query) == "" {
+		return nil, errors.New("检索内容不能为空")
+	}
+	var results []any
+	var contents []string
+	for _, id := range c.KnowledgeBaseIDs {
+		trigger, err := event.Trigger("searchKnowledgeBase", &shared.SearchKnowledgeBaseRequest{
+			UserId:          n.userId,
+			KnowledgeBaseId: id,
+			Query:           c.Query,
+		})
+		if err != nil {
+			logs.Errorf("searchKnowledgeBase 搜索知识库失败: %v", err)
+			return nil, err
+		}
+		response := trigger.(*shared.SearchKnowledgeBaseResponse)
+		for _, v := range response.Results {
+			results = append(results, v.Content)
+			contents = append(contents, v.Content)
+		}
+	}
+	return &nodeResult{
+		Output: map[string]any{
+			"results": results,
+			"text":    strings.Join(contents, "\n\n"),
+		},
+	}, nil
+}
+
+func (n *nodeExecutor) runAgent(ctx context.Context, config model.JSON) (*nodeResult, error) {
+	var c agentConfig
+	if err := decodeConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(c.Input) == "" {
+		return nil, errors.New("智能体的输入不能为空")
+	}
+	trigger, err := event.Trigger("runAgent", &shared.RunAgentRequest{
+		Ctx:     ctx,
+		UserId:  n.userId,
+		AgentId: c.AgentID,
+		Message: c.Input,
+	})
+	if err != nil {
+		logs.Errorf("触发runAgent事件失败: %v", err)
+		return nil, err
+	}
+	response := trigger.(*shared.RunAgentResponse)
+	return &nodeResult{Output: map[string]any{"text": response.Content}}, nil
+}
+
+func (n *nodeExecutor) runHttp(ctx context.Context, config model.JSON) (*nodeResult, error) {
+	var c httpConfig
+	if err := decodeConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(c.Url, "http://") && !strings.HasPrefix(c.Url, "https://") {
+		return nil, fmt.Errorf("不支持的请求地址: %s", c.Url)
+	}
+	method := strings.ToUpper(c.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	timeout := defaultHttpTimeout
+	if c.Timeout > 0 {
+		timeout = min(time.Duration(c.Timeout)*time.Second, maxHttpTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var body io.Reader
+	switch v := c.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(v)
+	default:
+		//对象按照json发送
+		marshal, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(marshal)
+		if c.Headers == nil {
+			c.Headers = map[string]string{}
+		}
+		if _, ok := c.Headers["Content-Type"]; !ok {
+			c.Headers["Content-Type"] = "application/json"
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		request.Header.Set(k, v)
+	}
+	response, err := httpNodeClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxHttpBodySize))
+	if err != nil {
+		return nil, err
+	}
+	output := map[string]any{
+		"status": float64(response.StatusCode),
+		"body":   string(data),
+	}
+	var parsed any
+	if json.Unmarshal(data, &parsed) == nil {
+		output["json"] = parsed
+	}
+	return &nodeResult{Output: output}, nil
+}
+
+// httpNodeClient HTTP节点使用的客户端，请求地址是用户配置的，不能访问本机、内网和云服务器的元数据地址
+var httpNodeClient = newHttpNodeClient()
+
+func newHttpNodeClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		//在域名解析之后、建立连接之前检查IP，防止域名解析到内网地址
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkHttpIP(net.ParseIP(host))
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	//不使用环境变量中的代理，否则连接的是代理的地址，检查不到真正访问的地址
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   maxHttpTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxHttpRedirects {
+				return fmt.Errorf("重定向次数超过 %d 次", maxHttpRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("不支持的重定向地址: %s", req.URL)
+			}
+			addrs, err := net.DefaultResolver.LookupIPAddr(req.Context(), req.URL.Hostname())
+			if err != nil {
+				return err
+			}
+			for _, addr := range addrs {
+				if err := checkHttpIP(addr.IP); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// checkHttpIP 只允许访问公网地址
+func checkHttpIP(ip net.IP) error {
+	if ip == nil {
+		return errors.New("无效的请求地址")
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("不允许访问内网地址: %s", ip)
+	}
+	return nil
+}
+
+func (s *service) getChatModel(ctx context.Context, provider string, modelName string, params model.ModelsParams) (aiModel.ToolCallingChatModel, error) {
+	providerConfig, err := s.getProviderConfig(model.LLMTypeChat, provider, modelName)
+	if err != nil {
+		return nil, err
+	}
+	if providerConfig == nil {
+		return nil, biz.ErrProviderConfigNotFound
+	}
+	return ai.NewChatModel(ctx, providerConfig, modelName, &params)
+}
+
+func (s *service) getProviderConfig(llmType model.LLMType, provider string, modelName string) (*model.ProviderConfig, error) {
+	trigger, err := event.Trigger("getProviderConfig", &shared.GetProviderConfigsRequest{
+		Provider:  provider,
+		ModelName: modelName,
+		LLMType:   llmType,
+	})
+	if err != nil {
+		logs.Errorf("触发getProviderConfig事件失败: %v", err)
+		return nil, err
+	}
+	return trigger.(*model.ProviderConfig), nil
+}
@@ -0,0 +1,46 @@
+package workflows
+
+import (
+	"context"
+	"model"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckHttpIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.0.0.8", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		err := checkHttpIP(net.ParseIP(tt.ip))
+		if (err != nil) != tt.blocked {
+			t.Errorf("checkHttpIP(%s) = %v, blocked = %v", tt.ip, err, tt.blocked)
+		}
+	}
+}
+
+func TestRunHttpBlocksLocalAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	n := &nodeExecutor{}
+	_, err := n.runHttp(context.Background(), model.JSON{"url": server.URL})
+	if err == nil {
+		t.Fatal("runHttp() should not access loopback address")
+	}
+}
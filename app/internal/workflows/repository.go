@@ -0,0 +1,23 @@
+package workflows
+
+import (
+	"context"
+	"model"
+
+	"github.com/google/uuid"
+)
+
+type repository interface {
+	createWorkflow(ctx context.Context, workflow *model.Workflow) error
+	countWorkflows(ctx context.Context, userID uuid.UUID) (int64, error)
+	listWorkflows(ctx context.Context, userID uuid.UUID, filter WorkflowFilter) ([]*model.Workflow, int64, error)
+	getWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID) (*model.Workflow, error)
+	updateWorkflow(ctx context.Context, workflow *model.Workflow) error
+	deleteWorkflow(ctx context.Context, id uuid.UUID) error
+	createWorkflowRun(ctx context.Context, run *model.WorkflowRun) error
+	updateWorkflowRun(ctx context.Context, run *model.WorkflowRun) error
+	getWorkflowRun(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, id uuid.UUID) (*model.WorkflowRun, error)
+	listWorkflowRuns(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, filter WorkflowRunFilter) ([]*model.WorkflowRun, int64, error)
+	createNodeRun(ctx context.Context, nodeRun *model.NodeRun) error
+	listNodeRuns(ctx context.Context, runId uuid.UUID) ([]*model.NodeRun, error)
+}
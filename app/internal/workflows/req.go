@@ -0,0 +1,41 @@
+package workflows
+
+import "model"
+
+type createWorkflowReq struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+type searchWorkflowReq struct {
+	Params struct {
+		Name     string               `json:"name"`
+		Status   model.WorkflowStatus `json:"status"`
+		Page     int                  `json:"page"`
+		PageSize int                  `json:"pageSize"`
+	} `json:"params"`
+}
+
+type updateWorkflowReq struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	Icon        string                    `json:"icon"`
+	Status      model.WorkflowStatus      `json:"status"`
+	Definition  *model.WorkflowDefinition `json:"definition"`
+}
+
+type runWorkflowReq struct {
+	Inputs map[string]any `json:"inputs"`
+}
+
+type listWorkflowRunReq struct {
+	Status   model.WorkflowRunStatus `json:"status" form:"status"`
+	Page     int                     `json:"page" form:"page"`
+	PageSize int                     `json:"pageSize" form:"pageSize"`
+}
+
+type replayWorkflowRunReq struct {
+	// NodeID 从哪个失败的节点开始重新运行，为空时使用运行记录中失败的节点
+	NodeID string `json:"nodeId"`
+}
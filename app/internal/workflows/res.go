@@ -0,0 +1,13 @@
+package workflows
+
+import "model"
+
+type ListWorkflowResponse struct {
+	Workflows []*model.Workflow `json:"workflows"`
+	Total     int64             `json:"total"`
+}
+
+type ListWorkflowRunResponse struct {
+	Runs  []*model.WorkflowRun `json:"runs"`
+	Total int64                `json:"total"`
+}
@@ -0,0 +1,475 @@
+package workflows
+
+import (
+	"app/shared"
+	"common/biz"
+	"context"
+	"core/ai"
+	"errors"
+	"model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mszlu521/thunder/database"
+	"github.com/mszlu521/thunder/errs"
+	"github.com/mszlu521/thunder/event"
+	"github.com/mszlu521/thunder/logs"
+)
+
+type service struct {
+	repo repository
+}
+
+func (s *service) createWorkflow(ctx context.Context, userID uuid.UUID, req createWorkflowReq) (*model.Workflow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errs.ErrParam
+	}
+	//检查当前订阅的工作流数量上限
+	plan, err := s.getUserPlan(userID)
+	if err != nil {
+		return nil, errs.DBError
+	}
+	count, err := s.repo.countWorkflows(ctx, userID)
+	if err != nil {
+		logs.Errorf("查询工作流数量失败: %v", err)
+		return nil, errs.DBError
+	}
+	if count >= model.GetPlanConfig(plan).MaxWorkflows {
+		return nil, biz.ErrWorkflowLimit
+	}
+	workflow := &model.Workflow{
+		BaseModel: model.BaseModel{
+			ID: uuid.New(),
+		},
+		CreatorID:   userID,
+		Name:        name,
+		Description: req.Description,
+		Icon:        req.Icon,
+		Status:      model.WorkflowStatusDraft,
+		Definition:  defaultDefinition(),
+		Version:     1,
+	}
+	err = s.repo.createWorkflow(ctx, workflow)
+	if err != nil {
+		logs.Errorf("创建工作流失败: %v", err)
+		return nil, errs.DBError
+	}
+	return workflow, nil
+}
+
+// defaultDefinition 新建的工作流默认只有开始和结束两个节点
+func defaultDefinition() model.WorkflowDefinition {
+	return model.WorkflowDefinition{
+		Nodes: []*model.WorkflowNode{
+			{
+				ID:     "start",
+				Type:   model.WorkflowNodeStart,
+				Name:   "开始",
+				Config: model.JSON{},
+			},
+			{
+				ID:     "end",
+				Type:   model.WorkflowNodeEnd,
+				Name:   "结束",
+				Config: model.JSON{},
+			},
+		},
+		Edges: []*model.WorkflowEdge{
+			{
+				ID:     "start-end",
+				Source: "start",
+				Target: "end",
+			},
+		},
+	}
+}
+
+func (s *service) listWorkflows(ctx context.Context, userID uuid.UUID, req searchWorkflowReq) (*ListWorkflowResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	page := req.Params.Page
+	if page <= 0 {
+		page = 1
+	}
+	size := req.Params.PageSize
+	if size <= 0 {
+		size = 20
+	}
+	filter := WorkflowFilter{
+		Name:   req.Params.Name,
+		Status: req.Params.Status,
+		Limit:  size,
+		Offset: (page - 1) * size,
+	}
+	list, total, err := s.repo.listWorkflows(ctx, userID, filter)
+	if err != nil {
+		logs.Errorf("查询工作流列表失败: %v", err)
+		return nil, errs.DBError
+	}
+	return &ListWorkflowResponse{
+		Workflows: list,
+		Total:     total,
+	}, nil
+}
+
+func (s *service) getWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID) (*model.Workflow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	workflow, err := s.repo.getWorkflow(ctx, userID, id)
+	if err != nil {
+		logs.Errorf("查询工作流失败: %v", err)
+		return nil, errs.DBError
+	}
+	if workflow == nil {
+		return nil, biz.ErrWorkflowNotFound
+	}
+	return workflow, nil
+}
+
+func (s *service) updateWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID, req updateWorkflowReq) (*model.Workflow, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	workflow, err := s.repo.getWorkflow(ctx, userID, id)
+	if err != nil {
+		logs.Errorf("查询工作流失败: %v", err)
+		return nil, errs.DBError
+	}
+	if workflow == nil {
+		return nil, biz.ErrWorkflowNotFound
+	}
+	if req.Name != "" {
+		workflow.Name = req.Name
+	}
+	if req.Description != "" {
+		workflow.Description = req.Description
+	}
+	if req.Icon != "" {
+		workflow.Icon = req.Icon
+	}
+	if req.Status != "" {
+		workflow.Status = req.Status
+	}
+	if req.Definition != nil {
+		//草稿允许保存不完整的画布，运行和发布时才校验
+		workflow.Definition = *req.Definition
+		workflow.Version++
+	}
+	if workflow.Status == model.WorkflowStatusPublished {
+		if err := validateDefinition(&workflow.Definition); err != nil {
+			return nil, errs.NewError(biz.ErrWorkflowInvalid.Code, err.Error())
+		}
+	}
+	err = s.repo.updateWorkflow(ctx, workflow)
+	if err != nil {
+		logs.Errorf("更新工作流失败: %v", err)
+		return nil, errs.DBError
+	}
+	return workflow, nil
+}
+
+func (s *service) deleteWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	workflow, err := s.repo.getWorkflow(ctx, userID, id)
+	if err != nil {
+		logs.Errorf("查询工作流失败: %v", err)
+		return errs.DBError
+	}
+	if workflow == nil {
+		return biz.ErrWorkflowNotFound
+	}
+	err = s.repo.deleteWorkflow(ctx, workflow.ID)
+	if err != nil {
+		logs.Errorf("删除工作流失败: %v", err)
+		return errs.DBError
+	}
+	return nil
+}
+
+func (s *service) runWorkflow(ctx context.Context, userID uuid.UUID, id uuid.UUID, req runWorkflowReq) (<-chan string, <-chan error) {
+	dataChan := make(chan string)
+	errChan := make(chan error)
+	go func() {
+		defer s.recoverRun(ctx, dataChan, errChan)
+		workflow, err := s.repo.getWorkflow(ctx, userID, id)
+		if err != nil {
+			logs.Errorf("查询工作流失败: %v", err)
+			s.sendError(ctx, errChan, errs.DBError)
+			return
+		}
+		if workflow == nil {
+			s.sendError(ctx, errChan, biz.ErrWorkflowNotFound)
+			return
+		}
+		if err := validateDefinition(&workflow.Definition); err != nil {
+			s.sendError(ctx, errChan, errs.NewError(biz.ErrWorkflowInvalid.Code, err.Error()))
+			return
+		}
+		run := &model.WorkflowRun{
+			BaseModel: model.BaseModel{
+				ID: uuid.New(),
+			},
+			WorkflowID:      workflow.ID,
+			CreatorID:       userID,
+			WorkflowVersion: workflow.Version,
+			Definition:      workflow.Definition,
+			Status:          model.WorkflowRunRunning,
+			Inputs:          req.Inputs,
+		}
+		s.executeRun(ctx, run, nil, dataChan, errChan)
+	}()
+	return dataChan, errChan
+}
+
+// replayWorkflowRun 从失败的节点重新运行，上游成功节点的输出直接复用，失败节点以及它的下游重新执行
+func (s *service) replayWorkflowRun(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, runId uuid.UUID, req replayWorkflowRunReq) (<-chan string, <-chan error) {
+	dataChan := make(chan string)
+	errChan := make(chan error)
+	go func() {
+		defer s.recoverRun(ctx, dataChan, errChan)
+		source, err := s.repo.getWorkflowRun(ctx, userID, workflowId, runId)
+		if err != nil {
+			logs.Errorf("查询运行记录失败: %v", err)
+			s.sendError(ctx, errChan, errs.DBError)
+			return
+		}
+		if source == nil {
+			s.sendError(ctx, errChan, biz.ErrWorkflowRunNotFound)
+			return
+		}
+		nodeRuns, err := s.repo.listNodeRuns(ctx, source.ID)
+		if err != nil {
+			logs.Errorf("查询节点运行记录失败: %v", err)
+			s.sendError(ctx, errChan, errs.DBError)
+			return
+		}
+		//找到重新运行的起点，必须是一个失败的节点
+		var from *model.NodeRun
+		for _, v := range nodeRuns {
+			if v.Status == model.WorkflowRunFailed && (req.NodeID == "" || v.NodeID == req.NodeID) {
+				from = v
+				break
+			}
+		}
+		if from == nil {
+			s.sendError(ctx, errChan, biz.ErrWorkflowReplayNode)
+			return
+		}
+		rerun := downstreamNodes(&source.Definition, from.NodeID)
+		cached := make(map[string]map[string]any)
+		for _, v := range nodeRuns {
+			if rerun[v.NodeID] {
+				continue
+			}
+			if v.Status == model.WorkflowRunSucceeded || v.Status == model.WorkflowRunCached {
+				cached[v.NodeID] = v.Output
+			}
+		}
+		run := &model.WorkflowRun{
+			BaseModel: model.BaseModel{
+				ID: uuid.New(),
+			},
+			WorkflowID:      source.WorkflowID,
+			CreatorID:       userID,
+			WorkflowVersion: source.WorkflowVersion,
+			Definition:      source.Definition,
+			Status:          model.WorkflowRunRunning,
+			Inputs:          source.Inputs,
+			ReplayOf:        &source.ID,
+		}
+		s.executeRun(ctx, run, cached, dataChan, errChan)
+	}()
+	return dataChan, errChan
+}
+
+// executeRun 执行工作流并记录运行历史，每个节点开始和结束时都推送给前端
+func (s *service) executeRun(ctx context.Context, run *model.WorkflowRun, cached map[string]map[string]any, dataChan chan string, errChan chan error) {
+	err := s.repo.createWorkflowRun(ctx, run)
+	if err != nil {
+		logs.Errorf("创建运行记录失败: %v", err)
+		s.sendError(ctx, errChan, errs.DBError)
+		return
+	}
+	s.sendData(ctx, dataChan, ai.BuildWorkflowRunMessage(run.ID.String()))
+	start := time.Now()
+	var mu sync.Mutex
+	totalTokens := 0
+	e := newEngine(&run.Definition, &nodeExecutor{service: s, userId: run.CreatorID}, func(event *nodeEvent) {
+		var errMsg string
+		if event.Err != nil {
+			errMsg = event.Err.Error()
+		}
+		if event.Status != model.WorkflowRunRunning {
+			mu.Lock()
+			totalTokens += event.Tokens
+			mu.Unlock()
+			s.saveNodeRun(run.ID, event, errMsg)
+		}
+		s.sendData(ctx, dataChan, ai.BuildWorkflowNodeMessage(event.Node.ID, string(event.Node.Type), event.Node.Name,
+			string(event.Status), event.Output, errMsg, event.Elapsed.Milliseconds()))
+	}).withCached(cached)
+	output, err := e.run(ctx, run.Inputs)
+	finishedAt := time.Now()
+	run.ElapsedMs = finishedAt.Sub(start).Milliseconds()
+	run.FinishedAt = &finishedAt
+	mu.Lock()
+	run.TotalTokens = totalTokens
+	mu.Unlock()
+	if err != nil {
+		logs.Errorf("运行工作流失败: %v", err)
+		run.Status = model.WorkflowRunFailed
+		run.Error = err.Error()
+	} else {
+		run.Status = model.WorkflowRunSucceeded
+		run.Outputs = output
+	}
+	//客户端断开连接后也要把运行结果存下来，所以这里不用请求的上下文
+	saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.repo.updateWorkflowRun(saveCtx, run); err != nil {
+		logs.Errorf("保存运行记录失败: %v", err)
+	}
+	s.sendData(ctx, dataChan, ai.BuildWorkflowResultMessage(run.ID.String(), string(run.Status), output, run.Error, run.ElapsedMs))
+}
+
+func (s *service) saveNodeRun(runId uuid.UUID, event *nodeEvent, errMsg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.repo.createNodeRun(ctx, &model.NodeRun{
+		BaseModel: model.BaseModel{
+			ID: uuid.New(),
+		},
+		RunID:     runId,
+		NodeID:    event.Node.ID,
+		NodeType:  event.Node.Type,
+		NodeName:  event.Node.Name,
+		Status:    event.Status,
+		Input:     event.Input,
+		Output:    event.Output,
+		Error:     errMsg,
+		Tokens:    event.Tokens,
+		ElapsedMs: event.Elapsed.Milliseconds(),
+	})
+	if err != nil {
+		logs.Errorf("保存节点运行记录失败: %v", err)
+	}
+}
+
+// downstreamNodes 返回从nodeId出发能到达的所有节点，包括它自己
+func downstreamNodes(definition *model.WorkflowDefinition, nodeId string) map[string]bool {
+	successors := make(map[string][]string)
+	for _, edge := range definition.Edges {
+		successors[edge.Source] = append(successors[edge.Source], edge.Target)
+	}
+	visited := map[string]bool{nodeId: true}
+	queue := []string{nodeId}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range successors[current] {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return visited
+}
+
+func (s *service) recoverRun(ctx context.Context, dataChan chan string, errChan chan error) {
+	if err := recover(); err != nil {
+		logs.Errorf("运行工作流失败: %v", err)
+		select {
+		case errChan <- errors.New("internal server error"):
+		case <-ctx.Done():
+			logs.Warnf("发送取消 context Done")
+		}
+	}
+	close(dataChan)
+	close(errChan)
+}
+
+func (s *service) listWorkflowRuns(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, req listWorkflowRunReq) (*ListWorkflowRunResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	page := req.Page
+	if page <= 0 {
+		page = 1
+	}
+	size := req.PageSize
+	if size <= 0 {
+		size = 20
+	}
+	filter := WorkflowRunFilter{
+		Status: req.Status,
+		Limit:  size,
+		Offset: (page - 1) * size,
+	}
+	list, total, err := s.repo.listWorkflowRuns(ctx, userID, workflowId, filter)
+	if err != nil {
+		logs.Errorf("查询运行记录列表失败: %v", err)
+		return nil, errs.DBError
+	}
+	return &ListWorkflowRunResponse{
+		Runs:  list,
+		Total: total,
+	}, nil
+}
+
+func (s *service) getWorkflowRun(ctx context.Context, userID uuid.UUID, workflowId uuid.UUID, id uuid.UUID) (*model.WorkflowRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	run, err := s.repo.getWorkflowRun(ctx, userID, workflowId, id)
+	if err != nil {
+		logs.Errorf("查询运行记录失败: %v", err)
+		return nil, errs.DBError
+	}
+	if run == nil {
+		return nil, biz.ErrWorkflowRunNotFound
+	}
+	nodeRuns, err := s.repo.listNodeRuns(ctx, run.ID)
+	if err != nil {
+		logs.Errorf("查询节点运行记录失败: %v", err)
+		return nil, errs.DBError
+	}
+	run.NodeRuns = nodeRuns
+	return run, nil
+}
+
+func (s *service) sendData(ctx context.Context, dataChan chan string, data string) {
+	select {
+	case dataChan <- data:
+	case <-ctx.Done():
+		logs.Warnf("sendData 发送取消 context Done")
+	}
+}
+
+func (s *service) sendError(ctx context.Context, errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
+		logs.Warnf("发送取消 context Done")
+	}
+}
+
+func (s *service) getUserPlan(userID uuid.UUID) (model.SubscriptionPlan, error) {
+	trigger, err := event.Trigger("getUserPlan", &shared.GetUserPlanRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		logs.Errorf("触发getUserPlan事件失败: %v", err)
+		return "", err
+	}
+	return trigger.(model.SubscriptionPlan), nil
+}
+
+func newService() *service {
+	return &service{
+		repo: newModels(database.GetPostgresDB().GormDB),
+	}
+}
@@ -10,14 +10,14 @@ import (
 
 func main() {
 	//加载etc/config.yml中的配置
-	config.Init()
+	v := config.Init()
 	conf := config.GetConfig()
 	//初始化日志
 	logs.Init(conf.Log)
 	//初始化Gin服务
 	s := server.NewServer(conf)
 	//初始化各个模块
-	inits.Init(s, conf)
+	inits.Init(s, conf, v)
 	//启动服务
 	s.Start()
 }
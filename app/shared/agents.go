@@ -0,0 +1,19 @@
+package shared
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type RunAgentRequest struct {
+	// Ctx 智能体执行时间比较长，调用方取消时需要一起取消
+	Ctx     context.Context `json:"-"`
+	UserId  uuid.UUID       `json:"userId"`
+	AgentId uuid.UUID       `json:"agentId"`
+	Message string          `json:"message"`
+}
+
+type RunAgentResponse struct {
+	Content string `json:"content"`
+}
@@ -11,12 +11,21 @@ type SearchKnowledgeBaseRequest struct {
 	UserId          uuid.UUID `json:"userId"`
 	KnowledgeBaseId uuid.UUID `json:"knowledgeBaseId"`
 	Query           string    `json:"query"`
+	// QueryMode 问题改写方式，为空时使用知识库的配置
+	QueryMode string `json:"queryMode"`
 }
 
 type SearchKnowledgeBaseResponse struct {
 	Results []*SearchKnowledgeBaseResult `json:"results"`
 }
 
+// SearchKnowledgeBaseResult 检索到的父分段，智能体回答时作为引用来源
 type SearchKnowledgeBaseResult struct {
-	Content string `json:"content"`
+	KnowledgeBaseId uuid.UUID      `json:"knowledgeBaseId"`
+	DocumentId      uuid.UUID      `json:"documentId"`
+	DocumentName    string         `json:"documentName"`
+	ChunkId         uuid.UUID      `json:"chunkId"`
+	Content         string         `json:"content"`
+	Score           float64        `json:"score"`
+	Metadata        map[string]any `json:"metadata"`
 }
@@ -0,0 +1,7 @@
+package shared
+
+import "github.com/google/uuid"
+
+type GetUserPlanRequest struct {
+	UserId uuid.UUID `json:"userId"`
+}
@@ -18,6 +18,9 @@ var (
 var (
 	AgentNotFound             = errs.NewError(20001, "Agent不存在")
 	ErrProviderConfigNotFound = errs.NewError(20002, "ProviderConfig不存在")
+	ErrConversationNotFound   = errs.NewError(20003, "会话不存在")
+	ErrSubAgentCycle          = errs.NewError(20004, "子智能体不能形成循环引用")
+	ErrSubAgentDepth          = errs.NewError(20005, "子智能体层级过深")
 )
 
 var (
@@ -33,4 +36,18 @@ var (
 	ErrEmbeddingConfigNotFound = errs.NewError(40004, "EmbeddingConfig不存在")
 	ErrEmbedding               = errs.NewError(40005, "Embedding错误")
 	ErrRetriever               = errs.NewError(40006, "Retriever错误")
+	ErrStorageType             = errs.NewError(40007, "不支持的存储类型")
+	ErrRetrievalConfig         = errs.NewError(40008, "检索配置错误")
+	ErrDocumentProcessing      = errs.NewError(40009, "文档正在处理中")
+	ErrDocumentFileNotFound    = errs.NewError(40010, "文档的原始文件不存在")
+	ErrReindexRunning          = errs.NewError(40011, "知识库正在重建索引")
+	ErrChunkingConfig          = errs.NewError(40012, "切分配置错误")
+	ErrChunkNotFound           = errs.NewError(40013, "分段不存在")
+)
+var (
+	ErrWorkflowNotFound    = errs.NewError(50001, "工作流不存在")
+	ErrWorkflowLimit       = errs.NewError(50002, "工作流数量已达到当前订阅的上限")
+	ErrWorkflowInvalid     = errs.NewError(50003, "工作流定义不合法")
+	ErrWorkflowRunNotFound = errs.NewError(50004, "运行记录不存在")
+	ErrWorkflowReplayNode  = errs.NewError(50005, "只能从失败的节点重新运行")
 )
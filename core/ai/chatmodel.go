@@ -0,0 +1,61 @@
+package ai
+
+import (
+	"context"
+	"model"
+
+	"github.com/cloudwego/eino-ext/components/model/ollama"
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino-ext/components/model/qwen"
+	aiModel "github.com/cloudwego/eino/components/model"
+	"github.com/eino-contrib/ollama/api"
+)
+
+// NewChatModel 根据模型厂商创建对话模型，params 为空时使用模型的默认参数
+func NewChatModel(ctx context.Context, config *model.ProviderConfig, modelName string, params *model.ModelsParams) (aiModel.ToolCallingChatModel, error) {
+	switch config.Provider {
+	case model.OllamaProvider:
+		chatConfig := &ollama.ChatModelConfig{
+			Model:   modelName,
+			BaseURL: config.APIBase,
+		}
+		if params != nil {
+			chatConfig.Options = &api.Options{
+				Temperature: float32(params.Temperature),
+				TopP:        float32(params.TopP),
+				Runner: api.Runner{
+					NumCtx: params.MaxTokens,
+				},
+			}
+		}
+		return ollama.NewChatModel(ctx, chatConfig)
+	case model.QwenProvider:
+		chatConfig := &qwen.ChatModelConfig{
+			Model:   modelName,
+			BaseURL: config.APIBase,
+			APIKey:  config.APIKey,
+		}
+		if params != nil {
+			temperature := float32(params.Temperature)
+			topP := float32(params.TopP)
+			chatConfig.MaxTokens = &params.MaxTokens
+			chatConfig.Temperature = &temperature
+			chatConfig.TopP = &topP
+		}
+		return qwen.NewChatModel(ctx, chatConfig)
+	}
+	//默认用openai，大部分厂商都支持openai的方式
+	chatConfig := &openai.ChatModelConfig{
+		Model:   modelName,
+		BaseURL: config.APIBase,
+		APIKey:  config.APIKey,
+	}
+	if params != nil {
+		temperature := float32(params.Temperature)
+		topP := float32(params.TopP)
+		chatConfig.MaxCompletionTokens = &params.MaxTokens
+		chatConfig.Temperature = &temperature
+		chatConfig.TopP = &topP
+	}
+	return openai.NewChatModel(ctx, chatConfig)
+}
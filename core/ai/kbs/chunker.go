@@ -0,0 +1,193 @@
+package kbs
+
+import (
+	"common/utils"
+	"strings"
+	"unicode/utf8"
+)
+
+// 切分策略
+const (
+	// ChunkStrategyFixed 固定长度的滑动窗口，可能从句子中间切开
+	ChunkStrategyFixed = "fixed"
+	// ChunkStrategyRecursive 依次按段落、换行、句号、逗号、空格切分，尽量保留完整的段落和句子
+	ChunkStrategyRecursive = "recursive"
+	// ChunkStrategySentence 按句子切分，再把相邻的句子合并到指定长度
+	ChunkStrategySentence = "sentence"
+	// ChunkStrategyHeading 按 markdown 标题切分，单个章节过长时再按段落和句子切分
+	ChunkStrategyHeading = "heading"
+	// ChunkStrategyToken 按 token 数量的固定窗口切分
+	ChunkStrategyToken = "token"
+)
+
+// 长度单位
+const (
+	ChunkUnitChars  = "chars"
+	ChunkUnitTokens = "tokens"
+)
+
+// recursiveSeparators 递归切分使用的分隔符，优先使用前面的
+var recursiveSeparators = []string{"\n\n", "\n", "。", "！", "？", ". ", "! ", "? ", "；", "; ", "，", ", ", " "}
+
+// sentenceEnds 句子结束的标点
+var sentenceEnds = []string{"。", "！", "？", ". ", "! ", "? ", "\n"}
+
+// Chunker 按照策略把文本切分成不超过 Size 的片段，相邻片段重叠 Overlap
+type Chunker struct {
+	Strategy string
+	Size     int
+	Overlap  int
+	// Unit 长度单位 chars/tokens，token 数使用 cl100k_base 计算
+	Unit string
+}
+
+// Measure 按照长度单位计算文本长度
+func (c *Chunker) Measure(text string) int {
+	if c.Unit == ChunkUnitTokens || c.Strategy == ChunkStrategyToken {
+		return utils.GetTokenCount(text)
+	}
+	return utf8.RuneCountInString(text)
+}
+
+// Split 切分文本，去掉空白的片段
+func (c *Chunker) Split(text string) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if c.Size <= 0 || c.Measure(text) <= c.Size {
+		return []string{text}
+	}
+	var chunks []string
+	switch c.Strategy {
+	case ChunkStrategyRecursive:
+		chunks = c.merge(c.splitRecursive(text, recursiveSeparators))
+	case ChunkStrategySentence:
+		chunks = c.merge(c.splitOversize(splitKeep(text, sentenceEnds), recursiveSeparators))
+	case ChunkStrategyHeading:
+		chunks = c.merge(c.splitOversize(splitHeadings(text), recursiveSeparators))
+	default:
+		chunks = c.window(text)
+	}
+	result := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			result = append(result, chunk)
+		}
+	}
+	return result
+}
+
+// window 固定窗口切分，按 token 计算时根据整段文本的平均长度把 token 数换算成字符数
+func (c *Chunker) window(text string) []string {
+	size, overlap := c.Size, c.Overlap
+	if c.Unit == ChunkUnitTokens || c.Strategy == ChunkStrategyToken {
+		tokens := utils.GetTokenCount(text)
+		if tokens > 0 {
+			ratio := float64(utf8.RuneCountInString(text)) / float64(tokens)
+			size = max(int(float64(size)*ratio), 1)
+			overlap = int(float64(overlap) * ratio)
+		}
+	}
+	if overlap >= size {
+		overlap = 0
+	}
+	return utils.SplitByWindow(text, size, overlap)
+}
+
+// splitRecursive 用第一个出现的分隔符切分，超过长度的片段用后面的分隔符继续切分
+func (c *Chunker) splitRecursive(text string, separators []string) []string {
+	if c.Measure(text) <= c.Size {
+		return []string{text}
+	}
+	for i, sep := range separators {
+		if !strings.Contains(text, sep) {
+			continue
+		}
+		var result []string
+		for _, part := range splitKeep(text, []string{sep}) {
+			result = append(result, c.splitRecursive(part, separators[i+1:])...)
+		}
+		return result
+	}
+	//没有可用的分隔符时按固定窗口切分
+	return c.window(text)
+}
+
+// splitOversize 超过长度的片段再递归切分
+func (c *Chunker) splitOversize(parts []string, separators []string) []string {
+	result := make([]string, 0, len(parts))
+	for _, part := range parts {
+		result = append(result, c.splitRecursive(part, separators)...)
+	}
+	return result
+}
+
+// merge 把相邻的小片段合并到不超过 Size，新片段开头保留上一个片段末尾不超过 Overlap 的内容
+func (c *Chunker) merge(parts []string) []string {
+	var chunks []string
+	var current []string
+	var sizes []int
+	var total int
+	for _, part := range parts {
+		size := c.Measure(part)
+		if total+size > c.Size && len(current) > 0 {
+			chunks = append(chunks, strings.Join(current, ""))
+			for len(current) > 0 && (total > c.Overlap || total+size > c.Size) {
+				total -= sizes[0]
+				current, sizes = current[1:], sizes[1:]
+			}
+		}
+		current = append(current, part)
+		sizes = append(sizes, size)
+		total += size
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, ""))
+	}
+	return chunks
+}
+
+// splitKeep 在分隔符之后切开，分隔符保留在前一个片段的末尾
+func splitKeep(text string, separators []string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(text); {
+		matched := ""
+		for _, sep := range separators {
+			if strings.HasPrefix(text[i:], sep) {
+				matched = sep
+				break
+			}
+		}
+		if matched == "" {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+		i += len(matched)
+		parts = append(parts, text[start:i])
+		start = i
+	}
+	if start < len(text) {
+		parts = append(parts, text[start:])
+	}
+	return parts
+}
+
+// splitHeadings 在每个 markdown 标题行之前切开
+func splitHeadings(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	var parts []string
+	var sb strings.Builder
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") && sb.Len() > 0 {
+			parts = append(parts, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() > 0 {
+		parts = append(parts, sb.String())
+	}
+	return parts
+}
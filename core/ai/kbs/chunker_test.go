@@ -0,0 +1,45 @@
+package kbs
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkerSplit(t *testing.T) {
+	text := strings.Repeat("退款需要在七天内申请。", 10) + "\n\n" + strings.Repeat("发票可以在订单详情中下载。", 10)
+	for _, strategy := range []string{ChunkStrategyFixed, ChunkStrategyRecursive, ChunkStrategySentence, ChunkStrategyHeading} {
+		chunker := &Chunker{Strategy: strategy, Size: 60, Overlap: 12, Unit: ChunkUnitChars}
+		chunks := chunker.Split(text)
+		if len(chunks) < 2 {
+			t.Fatalf("%s: Split() returned %d chunks", strategy, len(chunks))
+		}
+		for _, chunk := range chunks {
+			if n := utf8.RuneCountInString(chunk); n > 60 {
+				t.Fatalf("%s: chunk length %d exceeds size", strategy, n)
+			}
+		}
+	}
+	//按句子切分时不会从句子中间切开
+	chunks := (&Chunker{Strategy: ChunkStrategySentence, Size: 60, Overlap: 12}).Split(text)
+	for _, chunk := range chunks {
+		if !strings.HasSuffix(chunk, "。") {
+			t.Fatalf("sentence chunk %q does not end with a full sentence", chunk)
+		}
+	}
+	//按 token 切分时每个片段不超过指定的 token 数(允许换算误差)
+	chunker := &Chunker{Strategy: ChunkStrategyToken, Size: 50, Overlap: 5}
+	for _, chunk := range chunker.Split(text) {
+		if n := chunker.Measure(chunk); n > 60 {
+			t.Fatalf("token chunk has %d tokens", n)
+		}
+	}
+}
+
+func TestChunkerHeading(t *testing.T) {
+	text := "# 退款\n" + strings.Repeat("七天内可以申请退款。", 4) + "\n## 发票\n" + strings.Repeat("发票在订单详情中下载。", 4)
+	chunks := (&Chunker{Strategy: ChunkStrategyHeading, Size: 60}).Split(text)
+	if len(chunks) != 2 || !strings.HasPrefix(chunks[1], "## 发票") {
+		t.Fatalf("Split() = %q", chunks)
+	}
+}
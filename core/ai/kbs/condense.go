@@ -0,0 +1,77 @@
+package kbs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	defaultCondenseTurns  = 6   //改写时参考的最近几条对话
+	maxCondenseMessageLen = 500 //每条对话最多取的字数，防止回答太长占满上下文
+)
+
+const condensePrompt = `你是一个检索问题改写助手。下面是用户和助手最近的对话：
+%s
+请结合对话内容，把用户最新的问题改写成一个不依赖上下文、可以单独用于检索知识库的问题。
+规则：
+1. 把"它"、"第二个"、"那个"等指代替换成对话中具体的对象，补全省略的主语和关键概念。
+2. 问题本身已经完整时原样返回，不要回答问题，不要添加对话中没有的内容。
+3. 只返回改写之后的问题，不要有任何解释。`
+
+// QueryCondenser 结合最近的对话把追问改写成完整的问题，例如"第二个呢？"检索不到有用的内容
+type QueryCondenser struct {
+	chatModel model.BaseChatModel
+	turns     int
+}
+
+func NewQueryCondenser(chatModel model.BaseChatModel, turns int) *QueryCondenser {
+	if turns <= 0 {
+		turns = defaultCondenseTurns
+	}
+	return &QueryCondenser{chatModel: chatModel, turns: turns}
+}
+
+// Condense 没有历史对话时不调用模型，直接返回原始问题
+func (c *QueryCondenser) Condense(ctx context.Context, history []*schema.Message, query string) (string, error) {
+	//只需要用户的问题和助手的回答，工具调用和系统消息不参与改写
+	var turns []*schema.Message
+	for _, m := range history {
+		if (m.Role == schema.User || m.Role == schema.Assistant) && strings.TrimSpace(m.Content) != "" {
+			turns = append(turns, m)
+		}
+	}
+	if len(turns) == 0 {
+		return query, nil
+	}
+	if len(turns) > c.turns {
+		turns = turns[len(turns)-c.turns:]
+	}
+	var sb strings.Builder
+	for _, m := range turns {
+		content := []rune(strings.TrimSpace(m.Content))
+		if len(content) > maxCondenseMessageLen {
+			content = append(content[:maxCondenseMessageLen], []rune("...")...)
+		}
+		role := "用户"
+		if m.Role == schema.Assistant {
+			role = "助手"
+		}
+		fmt.Fprintf(&sb, "%s：%s\n", role, string(content))
+	}
+	message, err := c.chatModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(fmt.Sprintf(condensePrompt, sb.String())),
+		schema.UserMessage(query),
+	})
+	if err != nil {
+		return "", err
+	}
+	condensed := strings.TrimSpace(message.Content)
+	if condensed == "" {
+		return query, nil
+	}
+	return condensed, nil
+}
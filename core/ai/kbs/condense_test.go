@@ -0,0 +1,28 @@
+package kbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestQueryCondenser(t *testing.T) {
+	ctx := context.Background()
+	chatModel := &fakeChatModel{content: "订单的退款流程是什么"}
+	condenser := NewQueryCondenser(chatModel, 4)
+	//没有历史对话时不调用模型
+	query, err := condenser.Condense(ctx, nil, "第二个呢？")
+	if err != nil || query != "第二个呢？" || chatModel.calls != 0 {
+		t.Fatalf("Condense() = %q, %v, calls = %d", query, err, chatModel.calls)
+	}
+	history := []*schema.Message{
+		schema.SystemMessage("【之前的对话摘要】"),
+		schema.UserMessage("售后支持哪些服务"),
+		schema.AssistantMessage("1. 换货 2. 退款", nil),
+	}
+	query, err = condenser.Condense(ctx, history, "第二个呢？")
+	if err != nil || query != "订单的退款流程是什么" || chatModel.calls != 1 {
+		t.Fatalf("Condense() = %q, %v, calls = %d", query, err, chatModel.calls)
+	}
+}
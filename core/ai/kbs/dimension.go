@@ -0,0 +1,46 @@
+package kbs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudwego/eino/components/embedding"
+)
+
+// ProbeDimension 向量化一段文本，得到向量模型输出的维度
+func ProbeDimension(ctx context.Context, embedder embedding.Embedder) (int, error) {
+	vectors, err := embedder.EmbedStrings(ctx, []string{"dimension probe"})
+	if err != nil {
+		return 0, err
+	}
+	if len(vectors) == 0 || len(vectors[0]) == 0 {
+		return 0, fmt.Errorf("embedding result is empty")
+	}
+	return len(vectors[0]), nil
+}
+
+// dimensionEmbedder 校验向量模型返回的维度，维度不一致时写入会失败或者检索不到，直接返回错误
+type dimensionEmbedder struct {
+	embedding.Embedder
+	dimension int
+}
+
+func withDimensionCheck(embedder embedding.Embedder, dimension int) embedding.Embedder {
+	if dimension <= 0 || embedder == nil {
+		return embedder
+	}
+	return &dimensionEmbedder{Embedder: embedder, dimension: dimension}
+}
+
+func (e *dimensionEmbedder) EmbedStrings(ctx context.Context, texts []string, opts ...embedding.Option) ([][]float64, error) {
+	vectors, err := e.Embedder.EmbedStrings(ctx, texts, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, vector := range vectors {
+		if len(vector) != e.dimension {
+			return nil, fmt.Errorf("embedding dimension mismatch: expected %d, got %d", e.dimension, len(vector))
+		}
+	}
+	return vectors, nil
+}
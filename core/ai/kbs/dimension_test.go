@@ -0,0 +1,33 @@
+package kbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestDimensionCheck(t *testing.T) {
+	ctx := context.Background()
+	dimension, err := ProbeDimension(ctx, &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("ProbeDimension() error = %v", err)
+	}
+	if dimension != len(fakeKeywords) {
+		t.Fatalf("ProbeDimension() = %d, want %d", dimension, len(fakeKeywords))
+	}
+	store, err := NewVectorStore(ctx, &Clients{}, &StoreConfig{
+		StorageType: StorageTypeLocal,
+		Index:       "kb_dimension",
+		Dimension:   dimension + 1,
+		Embedder:    &fakeEmbedder{},
+	})
+	if err != nil {
+		t.Fatalf("NewVectorStore() error = %v", err)
+	}
+	//模型的维度和知识库的维度不一致时写入失败
+	err = store.Store(ctx, []*schema.Document{{ID: "1", Content: "退款", MetaData: map[string]any{"doc_id": "a"}}})
+	if err == nil {
+		t.Fatal("Store() with mismatched dimension should fail")
+	}
+}
@@ -1,29 +1,42 @@
 package kbs
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/cloudwego/eino-ext/components/indexer/es8"
 	reEs8 "github.com/cloudwego/eino-ext/components/retriever/es8"
 	"github.com/cloudwego/eino-ext/components/retriever/es8/search_mode"
 	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/components/retriever"
 	"github.com/cloudwego/eino/schema"
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
 )
 
 type ESVectorStore struct {
-	indexer   *es8.Indexer
-	retriever *reEs8.Retriever
+	client           *elasticsearch.Client
+	index            string
+	indexer          *es8.Indexer
+	retriever        *reEs8.Retriever
+	keywordRetriever *reEs8.Retriever
 }
 
 func NewESVectorStore(
 	ctx context.Context,
 	esClient *elasticsearch.Client,
 	index string,
+	analyzer string,
+	dim int,
 	embedder embedding.Embedder) (*ESVectorStore, error) {
+	err := ensureESIndex(ctx, esClient, index, dim)
+	if err != nil {
+		return nil, err
+	}
 	indexer, err := es8.NewIndexer(ctx, &es8.IndexerConfig{
 		Client: esClient,
 		Index:  index,
@@ -55,47 +68,223 @@ func NewESVectorStore(
 		SearchMode: search_mode.SearchModeApproximate(&search_mode.ApproximateConfig{
 			VectorFieldName: "content_vector",
 		}),
-		ResultParser: func(ctx context.Context, hit types.Hit) (doc *schema.Document, err error) {
-			doc = &schema.Document{
-				ID:       *hit.Id_,
-				Content:  "",
-				MetaData: map[string]interface{}{},
-			}
-			var src map[string]any
-			err = json.Unmarshal(hit.Source_, &src)
-			if err != nil {
-				return nil, err
-			}
-			doc.Content = src["content"].(string)
-			doc.MetaData = src["metadata"].(map[string]any)
-			if hit.Score_ != nil {
-				doc.WithScore(float64(*hit.Score_))
-			}
-			return doc, nil
-		},
-		Embedding: embedder,
+		ResultParser: parseESHit,
+		Embedding:    embedder,
+	})
+	if err != nil {
+		return nil, err
+	}
+	//全文检索使用content字段上的match查询，中文分词取决于索引的analyzer，也可以在查询时指定
+	keywordRetriever, err := reEs8.NewRetriever(ctx, &reEs8.RetrieverConfig{
+		Client:       esClient,
+		Index:        index,
+		SearchMode:   &esKeywordMatch{field: "content", analyzer: analyzer},
+		ResultParser: parseESHit,
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &ESVectorStore{
-		indexer:   indexer,
-		retriever: retriever,
+		client:           esClient,
+		index:            index,
+		indexer:          indexer,
+		retriever:        retriever,
+		keywordRetriever: keywordRetriever,
 	}, nil
 }
 
+// ensureESIndex 索引不存在时按向量维度创建，不指定维度时由es在第一次写入时自动推断
+// 其他字段还是使用动态映射，doc_id 等字段依赖自动生成的 keyword 子字段
+func ensureESIndex(ctx context.Context, client *elasticsearch.Client, index string, dim int) error {
+	if dim <= 0 {
+		return nil
+	}
+	res, err := client.Indices.Exists([]string{index}, client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == 200 {
+		return nil
+	}
+	if res.StatusCode != 404 {
+		return fmt.Errorf("check index error: %s", res.String())
+	}
+	mapping := map[string]any{
+		"mappings": map[string]any{
+			"properties": map[string]any{
+				"content_vector": map[string]any{
+					"type":       "dense_vector",
+					"dims":       dim,
+					"index":      true,
+					"similarity": "cosine",
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	err = json.NewEncoder(&buf).Encode(mapping)
+	if err != nil {
+		return err
+	}
+	res, err = client.Indices.Create(index,
+		client.Indices.Create.WithBody(&buf),
+		client.Indices.Create.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	//并发创建时可能已经被其他请求创建了
+	if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+		return fmt.Errorf("create index error: %s", res.String())
+	}
+	return nil
+}
+
+func parseESHit(ctx context.Context, hit types.Hit) (doc *schema.Document, err error) {
+	doc = &schema.Document{
+		ID:       *hit.Id_,
+		Content:  "",
+		MetaData: map[string]interface{}{},
+	}
+	var src map[string]any
+	err = json.Unmarshal(hit.Source_, &src)
+	if err != nil {
+		return nil, err
+	}
+	doc.Content = src["content"].(string)
+	doc.MetaData = src["metadata"].(map[string]any)
+	if hit.Score_ != nil {
+		doc.WithScore(float64(*hit.Score_))
+	}
+	return doc, nil
+}
+
 func (s *ESVectorStore) Store(ctx context.Context, docs []*schema.Document) error {
 	_, err := s.indexer.Store(ctx, docs)
 	return err
 }
 func (s *ESVectorStore) Search(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	return s.retriever.Retrieve(ctx, query, retriever.WithTopK(topK), reEs8.WithFilters(s.buildESFilter(filters)))
+}
+
+func (s *ESVectorStore) KeywordSearch(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	return s.keywordRetriever.Retrieve(ctx, query, retriever.WithTopK(topK), reEs8.WithFilters(s.buildESFilter(filters)))
+}
+
+func (s *ESVectorStore) buildESFilter(filters SearchFilter) []types.Query {
 	var esFilters []types.Query
 	for k, v := range filters {
+		field := fmt.Sprintf("metadata.%s", k)
+		//字符串使用动态映射生成的keyword子字段精确匹配，text字段分词之后匹配不到
+		if _, ok := v.(string); ok {
+			field += ".keyword"
+		}
 		esFilters = append(esFilters, types.Query{
 			Term: map[string]types.TermQuery{
-				fmt.Sprintf("metadata.%s", k): {Value: v},
+				field: {Value: v},
 			},
 		})
 	}
-	return s.retriever.Retrieve(ctx, query, reEs8.WithFilters(esFilters))
+	return esFilters
+}
+
+func (s *ESVectorStore) Delete(ctx context.Context, docId string) error {
+	//需要删除doc_id这个字段匹配的文档
+	return s.deleteByTerm(ctx, "doc_id", docId)
+}
+
+func (s *ESVectorStore) DeleteByParent(ctx context.Context, parentId string) error {
+	return s.deleteByTerm(ctx, "parent_id", parentId)
+}
+
+func (s *ESVectorStore) DeleteByIds(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.deleteByQuery(ctx, map[string]interface{}{
+		"ids": map[string]interface{}{
+			"values": ids,
+		},
+	})
+}
+
+// deleteByTerm 删除某个字段等于指定值的文档
+func (s *ESVectorStore) deleteByTerm(ctx context.Context, field string, value string) error {
+	return s.deleteByQuery(ctx, map[string]interface{}{
+		"term": map[string]interface{}{
+			field + ".keyword": value, //使用keyword精确匹配
+		},
+	})
+}
+
+// deleteByQuery 删除匹配查询条件的文档
+func (s *ESVectorStore) deleteByQuery(ctx context.Context, condition map[string]interface{}) error {
+	query := map[string]interface{}{
+		"query": condition,
+	}
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(query)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.DeleteByQuery(
+		[]string{s.index},
+		&buf,
+		s.client.DeleteByQuery.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	//索引不存在说明还没有写入过数据
+	if res.StatusCode == 404 {
+		return nil
+	}
+	if res.IsError() {
+		return fmt.Errorf("delete by query error: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESVectorStore) DropIndex(ctx context.Context) error {
+	res, err := s.client.Indices.Delete(
+		[]string{s.index},
+		s.client.Indices.Delete.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil
+	}
+	if res.IsError() {
+		return fmt.Errorf("delete index error: %s", res.String())
+	}
+	return nil
+}
+
+// esKeywordMatch 带过滤条件的match查询，es8自带的ExactMatch不支持过滤
+type esKeywordMatch struct {
+	field    string
+	analyzer string
+}
+
+func (m *esKeywordMatch) BuildRequest(ctx context.Context, conf *reEs8.RetrieverConfig, query string, opts ...retriever.Option) (*search.Request, error) {
+	topK := conf.TopK
+	options := retriever.GetCommonOptions(&retriever.Options{TopK: &topK}, opts...)
+	implOptions := retriever.GetImplSpecificOptions(&reEs8.ImplOptions{}, opts...)
+	match := types.MatchQuery{Query: query}
+	if m.analyzer != "" {
+		match.Analyzer = &m.analyzer
+	}
+	q := &types.Query{
+		Bool: &types.BoolQuery{
+			Must:   []types.Query{{Match: map[string]types.MatchQuery{m.field: match}}},
+			Filter: implOptions.Filters,
+		},
+	}
+	return &search.Request{Query: q, Size: options.TopK}, nil
 }
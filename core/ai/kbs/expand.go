@@ -0,0 +1,146 @@
+package kbs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 问题改写的方式
+const (
+	// QueryModeNone 只用原始问题检索
+	QueryModeNone = ""
+	// QueryModeMultiQuery 让模型生成几个不同说法的问题，和原始问题一起检索
+	QueryModeMultiQuery = "multi_query"
+	// QueryModeHyDE 让模型先写一段假设的答案，用答案去检索，答案和文档片段在向量空间中更接近
+	QueryModeHyDE = "hyde"
+)
+
+const defaultMultiQueryCount = 3
+
+// QueryExpander 把一个问题改写成多个用于检索的文本，结果中包含原始问题
+type QueryExpander interface {
+	Expand(ctx context.Context, query string) ([]string, error)
+}
+
+const multiQueryPrompt = `你是一个检索问题改写助手。请把用户的问题改写成 %d 个意思相同但表达方式不同的问题，用于从知识库中检索资料。
+规则：
+1. 补全省略的主语和关键概念，使用同义词或者更专业的说法。
+2. 不要回答问题，不要添加问题中没有的限定条件。
+3. 必须仅返回 JSON 格式数据，格式为 {"queries": ["问题1", "问题2"]}。`
+
+// MultiQueryExpander 生成多个不同说法的问题
+type MultiQueryExpander struct {
+	chatModel model.BaseChatModel
+	count     int
+}
+
+func NewMultiQueryExpander(chatModel model.BaseChatModel, count int) *MultiQueryExpander {
+	if count <= 0 {
+		count = defaultMultiQueryCount
+	}
+	return &MultiQueryExpander{chatModel: chatModel, count: count}
+}
+
+func (e *MultiQueryExpander) Expand(ctx context.Context, query string) ([]string, error) {
+	var output struct {
+		Queries []string `json:"queries"`
+	}
+	err := generateJSON(ctx, e.chatModel, fmt.Sprintf(multiQueryPrompt, e.count), query, &output)
+	if err != nil {
+		return nil, err
+	}
+	queries := []string{query}
+	seen := map[string]struct{}{query: {}}
+	for _, q := range output.Queries {
+		q = strings.TrimSpace(q)
+		if _, ok := seen[q]; ok || q == "" {
+			continue
+		}
+		seen[q] = struct{}{}
+		queries = append(queries, q)
+		if len(queries) > e.count {
+			break
+		}
+	}
+	return queries, nil
+}
+
+const hydePrompt = `请针对用户的问题写一段100到200字的回答，就像是从相关资料中摘录出来的一样。
+不知道准确答案时也按照常见的资料写法给出合理的内容，不要说明自己不知道，不要使用 markdown 格式。`
+
+// HyDEExpander 生成一段假设的答案用于检索
+type HyDEExpander struct {
+	chatModel model.BaseChatModel
+}
+
+func NewHyDEExpander(chatModel model.BaseChatModel) *HyDEExpander {
+	return &HyDEExpander{chatModel: chatModel}
+}
+
+func (e *HyDEExpander) Expand(ctx context.Context, query string) ([]string, error) {
+	message, err := e.chatModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(hydePrompt),
+		schema.UserMessage(query),
+	})
+	if err != nil {
+		return nil, err
+	}
+	answer := strings.TrimSpace(message.Content)
+	if answer == "" {
+		return []string{query}, nil
+	}
+	//假设的答案可能是编造的，原始问题也保留一路检索
+	return []string{query, answer}, nil
+}
+
+// MultiSearch 并行检索多个问题，按排名融合之后按 parent_id 去重，同一个父分段只保留分数最高的子分段
+// 原始问题(第一个)检索失败时返回错误，改写的问题检索失败时忽略
+func MultiSearch(ctx context.Context, queries []string, topK int, search func(ctx context.Context, query string) ([]*schema.Document, error)) ([]*schema.Document, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	lists := make([][]*schema.Document, len(queries))
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i], errs[i] = search(ctx, query)
+		}()
+	}
+	wg.Wait()
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	if len(queries) == 1 {
+		return DedupeByParent(lists[0]), nil
+	}
+	docs := DedupeByParent(FuseRRF(lists, nil, defaultRRFK))
+	if topK > 0 && len(docs) > topK {
+		docs = docs[:topK]
+	}
+	return docs, nil
+}
+
+// DedupeByParent 按 parent_id 去重，保留第一次出现的子分段，没有 parent_id 的子分段都保留
+func DedupeByParent(docs []*schema.Document) []*schema.Document {
+	seen := make(map[string]struct{}, len(docs))
+	result := make([]*schema.Document, 0, len(docs))
+	for _, doc := range docs {
+		parentId, ok := doc.MetaData["parent_id"].(string)
+		if ok && parentId != "" {
+			if _, dup := seen[parentId]; dup {
+				continue
+			}
+			seen[parentId] = struct{}{}
+		}
+		result = append(result, doc)
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package kbs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestMultiQueryExpander(t *testing.T) {
+	chatModel := &fakeChatModel{content: `{"queries": ["如何申请退款", "退款流程", "如何申请退款"]}`}
+	queries, err := NewMultiQueryExpander(chatModel, 3).Expand(context.Background(), "怎么退款")
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	//原始问题在第一个，重复的改写被去掉
+	if len(queries) != 3 || queries[0] != "怎么退款" || queries[1] != "如何申请退款" || queries[2] != "退款流程" {
+		t.Fatalf("Expand() = %q", queries)
+	}
+}
+
+func TestMultiSearch(t *testing.T) {
+	child := func(id string, parentId string) *schema.Document {
+		return &schema.Document{ID: id, MetaData: map[string]any{"parent_id": parentId}}
+	}
+	results := map[string][]*schema.Document{
+		"q1": {child("1", "p1"), child("2", "p1"), child("3", "p2")},
+		"q2": {child("4", "p3"), child("3", "p2")},
+	}
+	docs, err := MultiSearch(context.Background(), []string{"q1", "q2", "q3"}, 10, func(ctx context.Context, query string) ([]*schema.Document, error) {
+		if query == "q3" {
+			return nil, errors.New("search failed")
+		}
+		return results[query], nil
+	})
+	if err != nil {
+		t.Fatalf("MultiSearch() error = %v", err)
+	}
+	//3 在两路结果中都出现，融合之后排在最前面，同一个父分段只保留一个子分段
+	if len(docs) != 3 || docs[0].ID != "3" {
+		t.Fatalf("MultiSearch() = %v", docs)
+	}
+	parents := make(map[any]bool)
+	for _, doc := range docs {
+		if parents[doc.MetaData["parent_id"]] {
+			t.Fatalf("duplicate parent in %v", docs)
+		}
+		parents[doc.MetaData["parent_id"]] = true
+	}
+}
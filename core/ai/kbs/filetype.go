@@ -9,6 +9,7 @@ const (
 	Text     FileType = "text"
 	PDF      FileType = "pdf"
 	Excel    FileType = "excel"
+	CSV      FileType = "csv"
 	Docx     FileType = "docx"
 	Html     FileType = "html"
 	Epub     FileType = "epub"
@@ -26,6 +27,8 @@ func FromExtension(ext string) FileType {
 		return PDF
 	case "xlsx", "xls":
 		return Excel
+	case "csv":
+		return CSV
 	case "docx", "doc":
 		return Docx
 	case "html", "htm":
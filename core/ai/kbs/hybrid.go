@@ -0,0 +1,163 @@
+package kbs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// 检索模式
+const (
+	SearchModeVector = "vector"
+	SearchModeHybrid = "hybrid"
+)
+
+// 混合检索时两路结果的融合方式
+const (
+	FusionRRF      = "rrf"
+	FusionWeighted = "weighted"
+)
+
+const defaultRRFK = 60
+
+// KeywordSearcher 支持全文检索的向量存储实现这个接口，不支持的存储在混合检索时只走向量检索
+type KeywordSearcher interface {
+	KeywordSearch(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error)
+}
+
+// HybridConfig 混合检索的配置
+type HybridConfig struct {
+	// Fusion 融合方式 rrf/weighted，默认 rrf
+	Fusion string
+	// RRFK rrf的平滑参数，默认60
+	RRFK int
+	// VectorWeight 和 KeywordWeight 是两路结果的权重，都为0时各占一半
+	VectorWeight  float64
+	KeywordWeight float64
+}
+
+// HybridSearch 同时进行向量检索和全文检索，把两路结果融合之后返回前 topK 个
+func HybridSearch(ctx context.Context, store VectorStore, query string, topK int, filters SearchFilter, config HybridConfig) ([]*schema.Document, error) {
+	keywordSearcher, ok := store.(KeywordSearcher)
+	if !ok {
+		return store.Search(ctx, query, topK, filters)
+	}
+	var (
+		wg                      sync.WaitGroup
+		vectorDocs, keywordDocs []*schema.Document
+		vectorErr, keywordErr   error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		vectorDocs, vectorErr = store.Search(ctx, query, topK, filters)
+	}()
+	go func() {
+		defer wg.Done()
+		keywordDocs, keywordErr = keywordSearcher.KeywordSearch(ctx, query, topK, filters)
+	}()
+	wg.Wait()
+	if vectorErr != nil {
+		return nil, vectorErr
+	}
+	//全文检索失败不影响向量检索的结果
+	if keywordErr != nil {
+		return vectorDocs, nil
+	}
+	vectorWeight, keywordWeight := config.VectorWeight, config.KeywordWeight
+	if vectorWeight <= 0 && keywordWeight <= 0 {
+		vectorWeight, keywordWeight = 1, 1
+	}
+	lists := [][]*schema.Document{vectorDocs, keywordDocs}
+	weights := []float64{vectorWeight, keywordWeight}
+	var docs []*schema.Document
+	if config.Fusion == FusionWeighted {
+		docs = FuseWeighted(lists, weights)
+	} else {
+		docs = FuseRRF(lists, weights, config.RRFK)
+	}
+	if topK > 0 && len(docs) > topK {
+		docs = docs[:topK]
+	}
+	return docs, nil
+}
+
+// FuseRRF 倒数排名融合，每个列表中排名为 r 的文档得分 weight/(k+r)
+// 只依赖排名，不需要关心各路分数的量纲
+func FuseRRF(lists [][]*schema.Document, weights []float64, k int) []*schema.Document {
+	if k <= 0 {
+		k = defaultRRFK
+	}
+	return fuse(lists, func(i int, list []*schema.Document) []float64 {
+		scores := make([]float64, len(list))
+		for rank := range list {
+			scores[rank] = weightAt(weights, i) / float64(k+rank+1)
+		}
+		return scores
+	})
+}
+
+// FuseWeighted 把每个列表的分数归一化到0-1之后按权重相加
+func FuseWeighted(lists [][]*schema.Document, weights []float64) []*schema.Document {
+	return fuse(lists, func(i int, list []*schema.Document) []float64 {
+		scores := make([]float64, len(list))
+		if len(list) == 0 {
+			return scores
+		}
+		minScore, maxScore := list[0].Score(), list[0].Score()
+		for _, doc := range list {
+			minScore = min(minScore, doc.Score())
+			maxScore = max(maxScore, doc.Score())
+		}
+		for j, doc := range list {
+			normalized := 1.0
+			if maxScore > minScore {
+				normalized = (doc.Score() - minScore) / (maxScore - minScore)
+			}
+			scores[j] = weightAt(weights, i) * normalized
+		}
+		return scores
+	})
+}
+
+// fuse 按文档ID累加每个列表给出的分数，结果按分数从高到低排序
+func fuse(lists [][]*schema.Document, scoreList func(i int, list []*schema.Document) []float64) []*schema.Document {
+	merged := make(map[string]*schema.Document)
+	var order []string
+	for i, list := range lists {
+		scores := scoreList(i, list)
+		for j, doc := range list {
+			existing, ok := merged[doc.ID]
+			if !ok {
+				//分数存放在MetaData中，复制一份避免修改原来的文档
+				metadata := make(map[string]any, len(doc.MetaData))
+				for k, v := range doc.MetaData {
+					metadata[k] = v
+				}
+				existing = &schema.Document{
+					ID:       doc.ID,
+					Content:  doc.Content,
+					MetaData: metadata,
+				}
+				existing.WithScore(0)
+				merged[doc.ID] = existing
+				order = append(order, doc.ID)
+			}
+			existing.WithScore(existing.Score() + scores[j])
+		}
+	}
+	docs := make([]*schema.Document, 0, len(order))
+	for _, id := range order {
+		docs = append(docs, merged[id])
+	}
+	sortByScoreStable(docs)
+	return docs
+}
+
+func weightAt(weights []float64, i int) float64 {
+	if i < len(weights) {
+		return weights[i]
+	}
+	return 1
+}
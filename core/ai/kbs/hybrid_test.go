@@ -0,0 +1,56 @@
+package kbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func scoredDoc(id string, score float64) *schema.Document {
+	doc := &schema.Document{ID: id, MetaData: map[string]any{}}
+	return doc.WithScore(score)
+}
+
+func TestFuseRRF(t *testing.T) {
+	vector := []*schema.Document{scoredDoc("a", 0.9), scoredDoc("b", 0.8), scoredDoc("c", 0.7)}
+	keyword := []*schema.Document{scoredDoc("c", 12), scoredDoc("d", 8)}
+	docs := FuseRRF([][]*schema.Document{vector, keyword}, nil, 60)
+	if len(docs) != 4 || docs[0].ID != "c" {
+		t.Fatalf("document found by both lists should rank first: %v", docs)
+	}
+	if vector[2].Score() != 0.7 {
+		t.Fatalf("fusion should not modify input documents")
+	}
+	docs = FuseWeighted([][]*schema.Document{vector, keyword}, []float64{1, 0})
+	if docs[0].ID != "a" {
+		t.Fatalf("keyword weight 0 should keep vector order: %v", docs)
+	}
+}
+
+func TestHybridSearch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalVectorStore("", "kb_hybrid", &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("NewLocalVectorStore() error = %v", err)
+	}
+	_ = store.Store(ctx, []*schema.Document{
+		{ID: "0", Content: "退款政策", MetaData: map[string]any{"doc_id": "a"}},
+		{ID: "1", Content: "退款流程说明", MetaData: map[string]any{"doc_id": "a"}},
+		{ID: "2", Content: "错误码 E1024 表示退款失败", MetaData: map[string]any{"doc_id": "a"}},
+		{ID: "3", Content: "物流查询", MetaData: map[string]any{"doc_id": "b"}},
+	})
+	//向量检索认为几个退款文档一样相关，错误码所在的文档排不进前两个
+	docs, _ := store.Search(ctx, "退款 E1024", 2, nil)
+	if len(docs) != 2 || docs[0].ID == "2" || docs[1].ID == "2" {
+		t.Fatalf("unexpected vector result: %v", docs)
+	}
+	//全文检索命中错误码之后融合的结果中包含该文档
+	docs, err = HybridSearch(ctx, store, "退款 E1024", 2, nil, HybridConfig{})
+	if err != nil {
+		t.Fatalf("HybridSearch() error = %v", err)
+	}
+	if len(docs) != 2 || docs[1].ID != "2" {
+		t.Fatalf("exact identifier should be retrieved: %v", docs)
+	}
+}
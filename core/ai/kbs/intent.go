@@ -0,0 +1,185 @@
+package kbs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 问题意图的提取方式
+const (
+	// IntentExtractorNone 不提取，直接使用原始问题检索，不调用模型
+	IntentExtractorNone = ""
+	// IntentExtractorNovel 提取小说的卷号和章节号
+	IntentExtractorNovel = "novel"
+	// IntentExtractorMetadata 按知识库中实际存在的元数据字段提取过滤条件
+	IntentExtractorMetadata = "metadata"
+)
+
+// QueryIntent 从问题中提取的检索关键词和元数据过滤条件
+type QueryIntent struct {
+	Keywords string
+	Filter   SearchFilter
+}
+
+// QueryIntentExtractor 检索之前从问题中提取关键词和过滤条件
+type QueryIntentExtractor interface {
+	Extract(ctx context.Context, query string) (*QueryIntent, error)
+}
+
+// NeedsChatModel 判断提取方式是否需要调用对话模型，不需要时不用创建模型
+func NeedsChatModel(extractor string) bool {
+	return extractor == IntentExtractorNovel || extractor == IntentExtractorMetadata
+}
+
+// NoopIntentExtractor 原样返回问题
+type NoopIntentExtractor struct{}
+
+func (NoopIntentExtractor) Extract(ctx context.Context, query string) (*QueryIntent, error) {
+	return &QueryIntent{Keywords: query, Filter: SearchFilter{}}, nil
+}
+
+const novelIntentPrompt = `你是一个结构化数据提取助手。请从用户的提问中提取查询关键词、卷号和章节号。
+规则：
+1. volume_num: 提取"卷"的信息（如：第四卷、卷4）。若未提取到则返回 0。
+2. chapter_num: 提取"章/回/节"的信息（如：第500章、五百回）。若未提取到则返回 0。
+3. keywords: 除去卷和章信息后的核心查询关键词。
+4. 所有的中文数字（如：第四卷、第五百回）必须转换为阿拉伯数字整数（4, 500）。
+5. 必须仅返回 JSON 格式数据。
+
+示例：
+问题："凡人修仙传第四卷风起海外第五百章讲了什么？"
+输出：{"keywords": "讲了什么", "volume_num": 4, "chapter_num": 500}
+
+问题："斗罗大陆第10章唐三的魂环"
+输出：{"keywords": "唐三的魂环", "volume_num": 0, "chapter_num": 10}`
+
+// NovelIntentExtractor 提取小说的卷号和章节号，对应 epub 入库时的 volume_num 和 chapter_num 元数据
+type NovelIntentExtractor struct {
+	chatModel model.BaseChatModel
+}
+
+func NewNovelIntentExtractor(chatModel model.BaseChatModel) *NovelIntentExtractor {
+	return &NovelIntentExtractor{chatModel: chatModel}
+}
+
+func (e *NovelIntentExtractor) Extract(ctx context.Context, query string) (*QueryIntent, error) {
+	var output struct {
+		Keywords   string `json:"keywords"`
+		VolumeNum  int    `json:"volume_num"`  //卷号 0 表示未指定
+		ChapterNum int    `json:"chapter_num"` //章节号 0 表示未指定
+	}
+	err := generateJSON(ctx, e.chatModel, novelIntentPrompt, query, &output)
+	if err != nil {
+		return nil, err
+	}
+	intent := &QueryIntent{Keywords: output.Keywords, Filter: SearchFilter{}}
+	if output.ChapterNum > 0 {
+		intent.Filter["chapter_num"] = output.ChapterNum
+	}
+	if output.VolumeNum > 0 {
+		intent.Filter["volume_num"] = output.VolumeNum
+	}
+	return intent.withDefault(query), nil
+}
+
+// MetadataField 知识库中存在的元数据字段和部分取值，提供给模型参考
+type MetadataField struct {
+	Key     string
+	Samples []string
+}
+
+const metadataIntentPrompt = `你是一个结构化数据提取助手。知识库中的文档片段带有下面这些元数据字段，括号中是部分取值示例：
+%s
+请从用户的提问中提取查询关键词，以及问题中明确指定的元数据过滤条件。
+规则：
+1. keywords: 除去过滤条件之后的核心查询关键词。
+2. filters: 只能使用上面列出的字段，取值的类型和格式要和示例一致，数字使用阿拉伯数字。问题中没有明确指定时不要添加过滤条件。
+3. 必须仅返回 JSON 格式数据，格式为 {"keywords": "关键词", "filters": {"字段": 取值}}。`
+
+// MetadataIntentExtractor 按知识库中实际存在的元数据字段提取过滤条件，适用于任意类型的文档
+type MetadataIntentExtractor struct {
+	chatModel model.BaseChatModel
+	fields    []MetadataField
+}
+
+func NewMetadataIntentExtractor(chatModel model.BaseChatModel, fields []MetadataField) *MetadataIntentExtractor {
+	return &MetadataIntentExtractor{chatModel: chatModel, fields: fields}
+}
+
+func (e *MetadataIntentExtractor) Extract(ctx context.Context, query string) (*QueryIntent, error) {
+	//知识库中没有可以过滤的字段时不需要调用模型
+	if len(e.fields) == 0 {
+		return NoopIntentExtractor{}.Extract(ctx, query)
+	}
+	var sb strings.Builder
+	allowed := make(map[string]struct{}, len(e.fields))
+	for _, field := range e.fields {
+		allowed[field.Key] = struct{}{}
+		fmt.Fprintf(&sb, "- %s（%s）\n", field.Key, strings.Join(field.Samples, "、"))
+	}
+	var output struct {
+		Keywords string         `json:"keywords"`
+		Filters  map[string]any `json:"filters"`
+	}
+	err := generateJSON(ctx, e.chatModel, fmt.Sprintf(metadataIntentPrompt, sb.String()), query, &output)
+	if err != nil {
+		return nil, err
+	}
+	intent := &QueryIntent{Keywords: output.Keywords, Filter: SearchFilter{}}
+	for key, value := range output.Filters {
+		//模型编造的字段会导致检索不到任何内容，直接丢弃
+		if _, ok := allowed[key]; !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				intent.Filter[key] = v
+			}
+		case float64:
+			//json解析出来的数字都是float64，整数转换回int和入库时的类型保持一致
+			if v == math.Trunc(v) {
+				intent.Filter[key] = int(v)
+			} else {
+				intent.Filter[key] = v
+			}
+		case bool:
+			intent.Filter[key] = v
+		}
+	}
+	return intent.withDefault(query), nil
+}
+
+// withDefault 没有提取到关键词时使用原始问题
+func (i *QueryIntent) withDefault(query string) *QueryIntent {
+	if strings.TrimSpace(i.Keywords) == "" {
+		i.Keywords = query
+	}
+	return i
+}
+
+// generateJSON 调用模型并解析返回的 JSON
+func generateJSON(ctx context.Context, chatModel model.BaseChatModel, prompt string, query string, v any) error {
+	message, err := chatModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(prompt),
+		schema.UserMessage(query),
+	})
+	if err != nil {
+		return err
+	}
+	//防止返回的内容有md的代码块标签
+	rawJSON := strings.TrimSpace(message.Content)
+	rawJSON = strings.TrimPrefix(rawJSON, "```json")
+	rawJSON = strings.TrimPrefix(rawJSON, "```")
+	rawJSON = strings.TrimSuffix(rawJSON, "```")
+	if err := json.Unmarshal([]byte(strings.TrimSpace(rawJSON)), v); err != nil {
+		return fmt.Errorf("parse intent result error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package kbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// fakeChatModel 返回固定的内容，记录调用次数
+type fakeChatModel struct {
+	content string
+	calls   int
+}
+
+func (f *fakeChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	f.calls++
+	return schema.AssistantMessage(f.content, nil), nil
+}
+
+func (f *fakeChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	return schema.StreamReaderFromArray([]*schema.Message{schema.AssistantMessage(f.content, nil)}), nil
+}
+
+func TestMetadataIntentExtractor(t *testing.T) {
+	ctx := context.Background()
+	chatModel := &fakeChatModel{content: "```json\n{\"keywords\": \"销售额\", \"filters\": {\"sheet\": \"2024\", \"row\": 12, \"author\": \"张三\"}}\n```"}
+	extractor := NewMetadataIntentExtractor(chatModel, []MetadataField{
+		{Key: "sheet", Samples: []string{"2023", "2024"}},
+		{Key: "row", Samples: []string{"2", "3"}},
+	})
+	intent, err := extractor.Extract(ctx, "2024表第12行的销售额")
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if intent.Keywords != "销售额" {
+		t.Fatalf("keywords = %q", intent.Keywords)
+	}
+	//不存在的字段被丢弃，整数保持int类型
+	if len(intent.Filter) != 2 || intent.Filter["sheet"] != "2024" || intent.Filter["row"] != 12 {
+		t.Fatalf("filter = %v", intent.Filter)
+	}
+
+	//没有可以过滤的字段时不调用模型
+	chatModel.calls = 0
+	intent, err = NewMetadataIntentExtractor(chatModel, nil).Extract(ctx, "退款流程")
+	if err != nil || intent.Keywords != "退款流程" || len(intent.Filter) != 0 || chatModel.calls != 0 {
+		t.Fatalf("Extract() = %v, %v, calls = %d", intent, err, chatModel.calls)
+	}
+}
+
+func TestNovelIntentExtractor(t *testing.T) {
+	chatModel := &fakeChatModel{content: `{"keywords": "", "volume_num": 4, "chapter_num": 0}`}
+	intent, err := NewNovelIntentExtractor(chatModel).Extract(context.Background(), "第四卷讲了什么")
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if intent.Keywords != "第四卷讲了什么" || len(intent.Filter) != 1 || intent.Filter["volume_num"] != 4 {
+		t.Fatalf("Extract() = %+v", intent)
+	}
+}
@@ -0,0 +1,378 @@
+package kbs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/schema"
+)
+
+// localCollections 同一个索引在进程内共用一份数据，每次请求创建的 LocalVectorStore 都能看到之前写入的内容
+var (
+	localMu          sync.Mutex
+	localCollections = make(map[string]*localCollection)
+)
+
+type localEntry struct {
+	ID       string         `json:"id"`
+	Content  string         `json:"content"`
+	MetaData map[string]any `json:"metadata"`
+	// Vector 归一化之后的向量，搜索时直接点乘就是余弦相似度
+	Vector []float64 `json:"vector"`
+}
+
+type localCollection struct {
+	mu      sync.RWMutex
+	file    string
+	entries map[string]*localEntry
+}
+
+// LocalVectorStore 进程内的向量存储，暴力计算余弦相似度
+// 配置了目录时会持久化到 <目录>/<索引>.json，适合测试和单机部署
+type LocalVectorStore struct {
+	collection *localCollection
+	embedder   embedding.Embedder
+}
+
+func NewLocalVectorStore(dir string, index string, embedder embedding.Embedder) (*LocalVectorStore, error) {
+	if index == "" || filepath.Base(index) != index {
+		return nil, fmt.Errorf("invalid local index name: %s", index)
+	}
+	file := ""
+	if dir != "" {
+		file = filepath.Join(dir, index+".json")
+	}
+	localMu.Lock()
+	defer localMu.Unlock()
+	key := file
+	if key == "" {
+		key = index
+	}
+	collection, ok := localCollections[key]
+	if !ok {
+		collection = &localCollection{
+			file:    file,
+			entries: make(map[string]*localEntry),
+		}
+		if err := collection.load(); err != nil {
+			return nil, err
+		}
+		localCollections[key] = collection
+	}
+	return &LocalVectorStore{
+		collection: collection,
+		embedder:   embedder,
+	}, nil
+}
+
+func (s *LocalVectorStore) Store(ctx context.Context, docs []*schema.Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Content
+	}
+	vectors, err := s.embedder.EmbedStrings(ctx, texts)
+	if err != nil {
+		return err
+	}
+	if len(vectors) != len(docs) {
+		return fmt.Errorf("embedding result count mismatch: %d != %d", len(vectors), len(docs))
+	}
+	c := s.collection
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range docs {
+		c.entries[doc.ID] = &localEntry{
+			ID:       doc.ID,
+			Content:  doc.Content,
+			MetaData: doc.MetaData,
+			Vector:   normalize(vectors[i]),
+		}
+	}
+	return c.save()
+}
+
+func (s *LocalVectorStore) Search(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	vectors, err := s.embedder.EmbedStrings(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("embedding result is empty")
+	}
+	queryVector := normalize(vectors[0])
+	c := s.collection
+	c.mu.RLock()
+	docs := make([]*schema.Document, 0)
+	for _, entry := range c.entries {
+		if !matchFilters(entry.MetaData, filters) || len(entry.Vector) != len(queryVector) {
+			continue
+		}
+		var score float64
+		for i := range queryVector {
+			score += queryVector[i] * entry.Vector[i]
+		}
+		metadata := make(map[string]any, len(entry.MetaData))
+		for k, v := range entry.MetaData {
+			metadata[k] = v
+		}
+		doc := &schema.Document{
+			ID:       entry.ID,
+			Content:  entry.Content,
+			MetaData: metadata,
+		}
+		docs = append(docs, doc.WithScore(score))
+	}
+	c.mu.RUnlock()
+	sortByScore(docs)
+	if topK > 0 && len(docs) > topK {
+		docs = docs[:topK]
+	}
+	return docs, nil
+}
+
+// KeywordSearch 使用BM25对所有切片打分
+func (s *LocalVectorStore) KeywordSearch(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	const k1, b = 1.2, 0.75
+	queryTokens := Tokenize(query)
+	if len(queryTokens) == 0 {
+		return []*schema.Document{}, nil
+	}
+	c := s.collection
+	c.mu.RLock()
+	type candidate struct {
+		entry *localEntry
+		tf    map[string]int
+		size  int
+	}
+	candidates := make([]*candidate, 0, len(c.entries))
+	df := make(map[string]int)
+	var totalSize int
+	for _, entry := range c.entries {
+		if !matchFilters(entry.MetaData, filters) {
+			continue
+		}
+		tokens := Tokenize(entry.Content)
+		tf := make(map[string]int)
+		for _, token := range tokens {
+			tf[token]++
+		}
+		for _, token := range queryTokens {
+			if tf[token] > 0 {
+				df[token]++
+			}
+		}
+		totalSize += len(tokens)
+		candidates = append(candidates, &candidate{entry: entry, tf: tf, size: len(tokens)})
+	}
+	c.mu.RUnlock()
+	if len(candidates) == 0 {
+		return []*schema.Document{}, nil
+	}
+	n := float64(len(candidates))
+	avgSize := float64(totalSize) / n
+	docs := make([]*schema.Document, 0)
+	for _, cand := range candidates {
+		var score float64
+		for _, token := range queryTokens {
+			freq := float64(cand.tf[token])
+			if freq == 0 {
+				continue
+			}
+			idf := math.Log(1 + (n-float64(df[token])+0.5)/(float64(df[token])+0.5))
+			score += idf * freq * (k1 + 1) / (freq + k1*(1-b+b*float64(cand.size)/avgSize))
+		}
+		if score <= 0 {
+			continue
+		}
+		metadata := make(map[string]any, len(cand.entry.MetaData))
+		for k, v := range cand.entry.MetaData {
+			metadata[k] = v
+		}
+		doc := &schema.Document{
+			ID:       cand.entry.ID,
+			Content:  cand.entry.Content,
+			MetaData: metadata,
+		}
+		docs = append(docs, doc.WithScore(score))
+	}
+	sortByScore(docs)
+	if topK > 0 && len(docs) > topK {
+		docs = docs[:topK]
+	}
+	return docs, nil
+}
+
+func (s *LocalVectorStore) Delete(ctx context.Context, docId string) error {
+	return s.deleteBy("doc_id", docId)
+}
+
+func (s *LocalVectorStore) DeleteByParent(ctx context.Context, parentId string) error {
+	return s.deleteBy("parent_id", parentId)
+}
+
+func (s *LocalVectorStore) DeleteByIds(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	c := s.collection
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		delete(c.entries, id)
+	}
+	return c.save()
+}
+
+// deleteBy 删除元数据中某个字段等于指定值的切片
+func (s *LocalVectorStore) deleteBy(key string, value string) error {
+	c := s.collection
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, entry := range c.entries {
+		if toString(entry.MetaData[key]) == value {
+			delete(c.entries, id)
+		}
+	}
+	return c.save()
+}
+
+// DropIndex 清空数据并删除持久化文件，之后创建的同名索引是空的
+func (s *LocalVectorStore) DropIndex(ctx context.Context) error {
+	c := s.collection
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*localEntry)
+	if c.file == "" {
+		return nil
+	}
+	err := os.Remove(c.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// sortByScore 按照分数从高到低排序，分数相同时按ID排序保证结果稳定
+func sortByScore(docs []*schema.Document) {
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Score() == docs[j].Score() {
+			return docs[i].ID < docs[j].ID
+		}
+		return docs[i].Score() > docs[j].Score()
+	})
+}
+
+func (c *localCollection) load() error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var entries []*localEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		c.entries[entry.ID] = entry
+	}
+	return nil
+}
+
+// save 先写临时文件再重命名，避免写到一半进程退出把文件写坏
+func (c *localCollection) save() error {
+	if c.file == "" {
+		return nil
+	}
+	entries := make([]*localEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0o755); err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// matchFilters 元数据需要和所有过滤条件相等，数字统一按float64比较，因为从文件加载后都是float64
+func matchFilters(metadata map[string]any, filters SearchFilter) bool {
+	for k, v := range filters {
+		value, ok := metadata[k]
+		if !ok {
+			return false
+		}
+		a, aOk := toFloat(value)
+		b, bOk := toFloat(v)
+		if aOk && bOk {
+			if a != b {
+				return false
+			}
+			continue
+		}
+		if fmt.Sprint(value) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func normalize(vector []float64) []float64 {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	result := make([]float64, len(vector))
+	if norm == 0 {
+		return result
+	}
+	for i, v := range vector {
+		result[i] = v / norm
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package kbs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/schema"
+)
+
+// fakeEmbedder 按关键词出现的次数生成向量
+type fakeEmbedder struct{}
+
+var fakeKeywords = []string{"退款", "发票", "物流"}
+
+func (f *fakeEmbedder) EmbedStrings(ctx context.Context, texts []string, opts ...embedding.Option) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vector := make([]float64, len(fakeKeywords))
+		for j, keyword := range fakeKeywords {
+			vector[j] = float64(strings.Count(text, keyword))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func TestLocalVectorStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewVectorStore(ctx, &Clients{LocalDir: dir}, &StoreConfig{
+		StorageType: StorageTypeLocal,
+		Index:       "kb_test",
+		Embedder:    &fakeEmbedder{},
+	})
+	if err != nil {
+		t.Fatalf("NewVectorStore() error = %v", err)
+	}
+	err = store.Store(ctx, []*schema.Document{
+		{ID: "1", Content: "退款流程", MetaData: map[string]any{"doc_id": "a", "parent_id": "p1", "chapter_num": 1}},
+		{ID: "2", Content: "退款和发票", MetaData: map[string]any{"doc_id": "a", "parent_id": "p2", "chapter_num": 2}},
+		{ID: "3", Content: "物流查询", MetaData: map[string]any{"doc_id": "b", "chapter_num": 1}},
+	})
+	if err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	docs, err := store.Search(ctx, "退款", 2, nil)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(docs) != 2 || docs[0].ID != "1" || docs[1].ID != "2" {
+		t.Fatalf("unexpected search result: %v", docs)
+	}
+	docs, _ = store.Search(ctx, "退款", 10, SearchFilter{"chapter_num": 2})
+	if len(docs) != 1 || docs[0].ID != "2" {
+		t.Fatalf("filter not applied: %v", docs)
+	}
+
+	//从文件重新加载，数字类型的过滤条件依然生效
+	localMu.Lock()
+	localCollections = make(map[string]*localCollection)
+	localMu.Unlock()
+	reloaded, err := NewLocalVectorStore(dir, "kb_test", &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	docs, _ = reloaded.Search(ctx, "物流", 10, SearchFilter{"chapter_num": 1})
+	if len(docs) != 2 || docs[0].ID != "3" {
+		t.Fatalf("unexpected result after reload: %v", docs)
+	}
+
+	//禁用分段时只删除这个父分段下的子分段
+	if err := reloaded.DeleteByParent(ctx, "p1"); err != nil {
+		t.Fatalf("DeleteByParent() error = %v", err)
+	}
+	docs, _ = reloaded.Search(ctx, "退款", 10, nil)
+	if len(docs) != 2 || docs[0].ID != "2" {
+		t.Fatalf("parent chunks not deleted: %v", docs)
+	}
+	if err := reloaded.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	docs, _ = reloaded.Search(ctx, "退款", 10, nil)
+	if len(docs) != 1 || docs[0].ID != "3" {
+		t.Fatalf("document not deleted: %v", docs)
+	}
+	if err := reloaded.DeleteByIds(ctx, []string{"3"}); err != nil {
+		t.Fatalf("DeleteByIds() error = %v", err)
+	}
+	docs, _ = reloaded.Search(ctx, "退款", 10, nil)
+	if len(docs) != 0 {
+		t.Fatalf("chunks not deleted by id: %v", docs)
+	}
+}
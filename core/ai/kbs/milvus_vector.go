@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/cloudwego/eino-ext/components/indexer/milvus"
 	reMilvus "github.com/cloudwego/eino-ext/components/retriever/milvus"
@@ -15,21 +16,26 @@ import (
 	"github.com/mszlu521/thunder/logs"
 )
 
-const dim = 768 //要和向量模型保持一致 要不然查询不出来
-
 type MilvusVectorStore struct {
-	indexer   *milvus.Indexer
-	retriever *reMilvus.Retriever
+	client     client.Client
+	collection string
+	indexer    *milvus.Indexer
+	retriever  *reMilvus.Retriever
 }
 
 func NewMilvusVectorStore(
 	ctx context.Context,
 	c client.Client,
 	collectionName string,
+	dim int,
 	embedder embedding.Embedder,
 ) (*MilvusVectorStore, error) {
+	//集合的向量字段需要固定维度，要和向量模型保持一致 要不然查询不出来
+	if dim <= 0 {
+		return nil, fmt.Errorf("milvus collection %s requires embedding dimension", collectionName)
+	}
 	//先创建collection
-	err := ensureMilvusCollection(ctx, c, collectionName)
+	err := ensureMilvusCollection(ctx, c, collectionName, dim)
 	if err != nil {
 		return nil, err
 	}
@@ -183,8 +189,10 @@ func NewMilvusVectorStore(
 		return nil, err
 	}
 	return &MilvusVectorStore{
-		indexer:   indexer,
-		retriever: retriever,
+		client:     c,
+		collection: collectionName,
+		indexer:    indexer,
+		retriever:  retriever,
 	}, nil
 }
 func (s *MilvusVectorStore) Store(ctx context.Context, docs []*schema.Document) error {
@@ -221,12 +229,58 @@ func (s *MilvusVectorStore) Search(ctx context.Context, query string, topK int,
 	return s.retriever.Retrieve(ctx, query, options...)
 }
 
+func (s *MilvusVectorStore) Delete(ctx context.Context, docId string) error {
+	return s.deleteByExpr(ctx, fmt.Sprintf("doc_id=='%s'", docId))
+}
+
+func (s *MilvusVectorStore) DeleteByParent(ctx context.Context, parentId string) error {
+	return s.deleteByExpr(ctx, fmt.Sprintf("parent_id=='%s'", parentId))
+}
+
+func (s *MilvusVectorStore) DeleteByIds(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	quoted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		quoted = append(quoted, "'"+milvusStringEscaper.Replace(id)+"'")
+	}
+	return s.deleteByExpr(ctx, fmt.Sprintf("id in [%s]", strings.Join(quoted, ",")))
+}
+
+func (s *MilvusVectorStore) deleteByExpr(ctx context.Context, expr string) error {
+	//集合可能已经被删除，比如重建索引之后删除了旧的集合，不存在时不需要删除
+	has, err := s.client.HasCollection(ctx, s.collection)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return nil
+	}
+	return s.client.Delete(ctx, s.collection, "", expr)
+}
+
+func (s *MilvusVectorStore) DropIndex(ctx context.Context) error {
+	has, err := s.client.HasCollection(ctx, s.collection)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return nil
+	}
+	return s.client.DropCollection(ctx, s.collection)
+}
+
+// milvusStringEscaper 过滤条件中的字段和取值来自文档的元数据和模型的输出，引号和反斜杠需要转义，否则会改变表达式
+var milvusStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
 func (s *MilvusVectorStore) buildMilvusFilter(filters SearchFilter) string {
 	expr := make([]string, 0)
 	for key, value := range filters {
+		key = milvusStringEscaper.Replace(key)
 		switch v := value.(type) {
 		case string:
-			expr = append(expr, fmt.Sprintf("metadata['%s'] == '%s'", key, v))
+			expr = append(expr, fmt.Sprintf("metadata['%s'] == '%s'", key, milvusStringEscaper.Replace(v)))
 		case int, int32, int64, uint, uint32, uint64:
 			expr = append(expr, fmt.Sprintf("metadata['%s'] == %d", key, v))
 		case float32, float64:
@@ -249,14 +303,23 @@ func (s *MilvusVectorStore) buildMilvusFilter(filters SearchFilter) string {
 	logs.Infof("milvus filter: %s", result)
 	return result
 }
-func ensureMilvusCollection(ctx context.Context, client client.Client, collectionName string) error {
+func ensureMilvusCollection(ctx context.Context, client client.Client, collectionName string, dim int) error {
 	//先判断collection是否存在
 	has, err := client.HasCollection(ctx, collectionName)
 	if err != nil {
 		return err
 	}
 	if has {
-		//如果已经存在 就直接返回就行
+		//已经存在时确认维度一致，之前的集合都是按768维创建的
+		collection, err := client.DescribeCollection(ctx, collectionName)
+		if err != nil {
+			return err
+		}
+		for _, field := range collection.Schema.Fields {
+			if field.Name == "vector" && field.TypeParams["dim"] != fmt.Sprintf("%d", dim) {
+				return fmt.Errorf("milvus collection %s dimension is %s, embedding dimension is %d", collectionName, field.TypeParams["dim"], dim)
+			}
+		}
 		return nil
 	}
 	collectionSchema := &entity.Schema{
@@ -0,0 +1,17 @@
+package kbs
+
+import "testing"
+
+func TestBuildMilvusFilterEscape(t *testing.T) {
+	s := &MilvusVectorStore{}
+	got := s.buildMilvusFilter(SearchFilter{"author": `O'Brien\`})
+	want := `metadata['author'] == 'O\'Brien\\'`
+	if got != want {
+		t.Fatalf("buildMilvusFilter() = %s, want %s", got, want)
+	}
+	got = s.buildMilvusFilter(SearchFilter{"a'] == 'x' or metadata['b": 1})
+	want = `metadata['a\'] == \'x\' or metadata[\'b'] == 1`
+	if got != want {
+		t.Fatalf("buildMilvusFilter() = %s, want %s", got, want)
+	}
+}
@@ -24,3 +24,7 @@ func HtmlParser(config *HtmlConfig) (parser.Parser, error) {
 func EpubParser(config *epub.Config) (parser.Parser, error) {
 	return epub.NewParser(context.Background(), config)
 }
+
+func SpreadsheetParser(config *SpreadsheetConfig) (parser.Parser, error) {
+	return NewSheetParser(context.Background(), config)
+}
@@ -0,0 +1,352 @@
+package kbs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/schema"
+	"gorm.io/gorm"
+)
+
+// pgvector 支持的索引类型
+const (
+	PgIndexHNSW    = "hnsw"
+	PgIndexIVFFlat = "ivfflat"
+)
+
+var pgTableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}$`)
+
+// PgVectorConfig pgvector 的索引配置，来自知识库的 StorageConfig
+type PgVectorConfig struct {
+	// IndexType 索引类型 hnsw/ivfflat，默认 hnsw
+	IndexType string
+	// M 和 EfConstruction 是 hnsw 的参数
+	M              int
+	EfConstruction int
+	// Lists 是 ivfflat 的参数
+	Lists int
+	// TextSearchConfig 全文检索使用的分词配置，默认 simple
+	// 中文需要安装 zhparser 之类的扩展并创建对应的配置，比如 chinese
+	TextSearchConfig string
+	// Dimension 向量的维度，为0时使用第一次写入的向量的维度
+	Dimension int
+}
+
+// PgVectorStore 基于 Postgres pgvector 扩展的向量存储，每个知识库一张表
+// 表在第一次写入时创建，没有配置维度时使用第一次写入的向量的维度
+type PgVectorStore struct {
+	db       *gorm.DB
+	table    string
+	config   PgVectorConfig
+	embedder embedding.Embedder
+}
+
+func NewPgVectorStore(db *gorm.DB, table string, config PgVectorConfig, embedder embedding.Embedder) (*PgVectorStore, error) {
+	//表名会直接拼接到sql中，必须校验
+	if !pgTableNameRegex.MatchString(table) {
+		return nil, fmt.Errorf("invalid pgvector table name: %s", table)
+	}
+	if config.IndexType == "" {
+		config.IndexType = PgIndexHNSW
+	}
+	if config.IndexType != PgIndexHNSW && config.IndexType != PgIndexIVFFlat {
+		return nil, fmt.Errorf("unsupported pgvector index type: %s", config.IndexType)
+	}
+	if config.TextSearchConfig == "" {
+		config.TextSearchConfig = "simple"
+	}
+	//分词配置会拼接到索引表达式中，同样需要校验
+	if !pgTableNameRegex.MatchString(config.TextSearchConfig) {
+		return nil, fmt.Errorf("invalid text search config: %s", config.TextSearchConfig)
+	}
+	return &PgVectorStore{
+		db:       db,
+		table:    table,
+		config:   config,
+		embedder: embedder,
+	}, nil
+}
+
+func (s *PgVectorStore) Store(ctx context.Context, docs []*schema.Document) error {
+	//这里分批插入
+	const batchSize = 50
+	total := len(docs)
+	if total == 0 {
+		return nil
+	}
+	for i := 0; i < total; i += batchSize {
+		end := i + batchSize
+		if end > total {
+			end = total
+		}
+		batch := docs[i:end]
+		texts := make([]string, len(batch))
+		for j, doc := range batch {
+			texts[j] = doc.Content
+		}
+		vectors, err := s.embedder.EmbedStrings(ctx, texts)
+		if err != nil {
+			return err
+		}
+		if len(vectors) != len(batch) {
+			return fmt.Errorf("embedding result count mismatch: %d != %d", len(vectors), len(batch))
+		}
+		if i == 0 {
+			dimension := s.config.Dimension
+			if dimension <= 0 {
+				dimension = len(vectors[0])
+			}
+			err = s.ensureTable(ctx, dimension)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.insert(ctx, batch, vectors)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PgVectorStore) insert(ctx context.Context, docs []*schema.Document, vectors [][]float64) error {
+	placeholders := make([]string, 0, len(docs))
+	args := make([]any, 0, len(docs)*6)
+	for i, doc := range docs {
+		metadata, err := json.Marshal(doc.MetaData)
+		if err != nil {
+			return err
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?::jsonb, ?::vector)")
+		args = append(args,
+			doc.ID,
+			toString(doc.MetaData["doc_id"]),
+			toString(doc.MetaData["parent_id"]),
+			doc.Content,
+			string(metadata),
+			formatVector(vectors[i]),
+		)
+	}
+	sql := fmt.Sprintf(`INSERT INTO %s (id, doc_id, parent_id, content, metadata, embedding) VALUES %s
+ON CONFLICT (id) DO UPDATE SET doc_id = EXCLUDED.doc_id, parent_id = EXCLUDED.parent_id,
+content = EXCLUDED.content, metadata = EXCLUDED.metadata, embedding = EXCLUDED.embedding`,
+		s.table, strings.Join(placeholders, ", "))
+	return s.db.WithContext(ctx).Exec(sql, args...).Error
+}
+
+func (s *PgVectorStore) Search(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	exist, err := s.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		//还没有写入过数据
+		return []*schema.Document{}, nil
+	}
+	vectors, err := s.embedder.EmbedStrings(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, fmt.Errorf("embedding result is empty")
+	}
+	vector := formatVector(vectors[0])
+	where, args, err := s.buildPgFilter(filters)
+	if err != nil {
+		return nil, err
+	}
+	sql := fmt.Sprintf(`SELECT id, content, metadata, 1 - (embedding <=> ?::vector) AS score FROM %s %s
+ORDER BY embedding <=> ?::vector LIMIT ?`, s.table, where)
+	args = append([]any{vector}, args...)
+	args = append(args, vector, topK)
+	return s.query(ctx, sql, args...)
+}
+
+func (s *PgVectorStore) KeywordSearch(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error) {
+	exist, err := s.tableExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return []*schema.Document{}, nil
+	}
+	tsvector := s.tsvectorExpr()
+	tsquery := fmt.Sprintf("plainto_tsquery('%s', ?)", s.config.TextSearchConfig)
+	where, args, err := s.buildPgFilter(filters)
+	if err != nil {
+		return nil, err
+	}
+	if where == "" {
+		where = fmt.Sprintf("WHERE %s @@ %s", tsvector, tsquery)
+	} else {
+		where = fmt.Sprintf("%s AND %s @@ %s", where, tsvector, tsquery)
+	}
+	sql := fmt.Sprintf(`SELECT id, content, metadata, ts_rank_cd(%s, %s) AS score FROM %s %s
+ORDER BY score DESC LIMIT ?`, tsvector, tsquery, s.table, where)
+	args = append([]any{query}, args...)
+	args = append(args, query, topK)
+	return s.query(ctx, sql, args...)
+}
+
+func (s *PgVectorStore) tsvectorExpr() string {
+	return fmt.Sprintf("to_tsvector('%s', content)", s.config.TextSearchConfig)
+}
+
+func (s *PgVectorStore) query(ctx context.Context, sql string, args ...any) ([]*schema.Document, error) {
+	var rows []struct {
+		ID       string
+		Content  string
+		Metadata string
+		Score    float64
+	}
+	err := s.db.WithContext(ctx).Raw(sql, args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]*schema.Document, 0, len(rows))
+	for _, row := range rows {
+		metadata := make(map[string]any)
+		if row.Metadata != "" {
+			if err := json.Unmarshal([]byte(row.Metadata), &metadata); err != nil {
+				metadata = make(map[string]any)
+			}
+		}
+		doc := &schema.Document{
+			ID:       row.ID,
+			Content:  row.Content,
+			MetaData: metadata,
+		}
+		doc.WithScore(row.Score)
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (s *PgVectorStore) Delete(ctx context.Context, docId string) error {
+	return s.deleteBy(ctx, "doc_id", docId)
+}
+
+func (s *PgVectorStore) DeleteByParent(ctx context.Context, parentId string) error {
+	return s.deleteBy(ctx, "parent_id", parentId)
+}
+
+func (s *PgVectorStore) DeleteByIds(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	exist, err := s.tableExists(ctx)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	return s.db.WithContext(ctx).Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN ?", s.table), ids).Error
+}
+
+func (s *PgVectorStore) deleteBy(ctx context.Context, column string, value string) error {
+	exist, err := s.tableExists(ctx)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	return s.db.WithContext(ctx).Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", s.table, column), value).Error
+}
+
+func (s *PgVectorStore) DropIndex(ctx context.Context) error {
+	return s.db.WithContext(ctx).Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", s.table)).Error
+}
+
+// buildPgFilter 使用jsonb的包含查询匹配元数据，数字和字符串的类型都会保留
+func (s *PgVectorStore) buildPgFilter(filters SearchFilter) (string, []any, error) {
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return "", nil, err
+	}
+	return "WHERE metadata @> ?::jsonb", []any{string(data)}, nil
+}
+
+func (s *PgVectorStore) tableExists(ctx context.Context) (bool, error) {
+	var exist bool
+	err := s.db.WithContext(ctx).Raw("SELECT to_regclass(?) IS NOT NULL", s.table).Scan(&exist).Error
+	return exist, err
+}
+
+func (s *PgVectorStore) ensureTable(ctx context.Context, dimension int) error {
+	db := s.db.WithContext(ctx)
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id varchar(128) PRIMARY KEY,
+doc_id varchar(128) NOT NULL,
+parent_id varchar(128),
+content text NOT NULL,
+metadata jsonb,
+embedding vector(%d) NOT NULL)`, s.table, dimension),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_doc_id_idx ON %s (doc_id)", s.table, s.table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_metadata_idx ON %s USING gin (metadata jsonb_path_ops)", s.table, s.table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_content_idx ON %s USING gin (%s)", s.table, s.table, s.tsvectorExpr()),
+		s.vectorIndexSQL(),
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PgVectorStore) vectorIndexSQL() string {
+	if s.config.IndexType == PgIndexIVFFlat {
+		lists := s.config.Lists
+		if lists <= 0 {
+			lists = 100
+		}
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_embedding_idx ON %s USING ivfflat (embedding vector_cosine_ops) WITH (lists = %d)",
+			s.table, s.table, lists)
+	}
+	m := s.config.M
+	if m <= 0 {
+		m = 16
+	}
+	efConstruction := s.config.EfConstruction
+	if efConstruction <= 0 {
+		efConstruction = 64
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_embedding_idx ON %s USING hnsw (embedding vector_cosine_ops) WITH (m = %d, ef_construction = %d)",
+		s.table, s.table, m, efConstruction)
+}
+
+// formatVector 转换成pgvector的文本格式 [1,2,3]
+func formatVector(vector []float64) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i, v := range vector {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+func toString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,158 @@
+package kbs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 重排序方式
+const (
+	RerankerNone    = ""
+	RerankerLexical = "lexical"
+	RerankerLLM     = "llm"
+)
+
+// Reranker 对检索出来的切片重新打分，返回按新分数从高到低排序的结果
+type Reranker interface {
+	Rerank(ctx context.Context, query string, docs []*schema.Document) ([]*schema.Document, error)
+}
+
+// Rerank 重排序之后去掉分数低于 minScore 的切片
+func Rerank(ctx context.Context, reranker Reranker, query string, docs []*schema.Document, minScore float64) ([]*schema.Document, error) {
+	if reranker == nil || len(docs) == 0 {
+		return docs, nil
+	}
+	reranked, err := reranker.Rerank(ctx, query, docs)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*schema.Document, 0, len(reranked))
+	for _, doc := range reranked {
+		if doc.Score() < minScore {
+			continue
+		}
+		result = append(result, doc)
+	}
+	return result, nil
+}
+
+// LexicalReranker 按问题中的词在切片中出现的比例打分，不需要调用模型
+type LexicalReranker struct{}
+
+func NewLexicalReranker() *LexicalReranker {
+	return &LexicalReranker{}
+}
+
+func (r *LexicalReranker) Rerank(ctx context.Context, query string, docs []*schema.Document) ([]*schema.Document, error) {
+	queryTokens := uniqueTokens(Tokenize(query))
+	result := make([]*schema.Document, 0, len(docs))
+	for _, doc := range docs {
+		var score float64
+		if len(queryTokens) > 0 {
+			docTokens := make(map[string]struct{})
+			for _, token := range Tokenize(doc.Content) {
+				docTokens[token] = struct{}{}
+			}
+			var hit int
+			for _, token := range queryTokens {
+				if _, ok := docTokens[token]; ok {
+					hit++
+				}
+			}
+			score = float64(hit) / float64(len(queryTokens))
+		}
+		result = append(result, withNewScore(doc, score))
+	}
+	sortByScoreStable(result)
+	return result, nil
+}
+
+const llmRerankPrompt = `你是一个检索结果相关性评估助手。请判断每个文本片段和用户问题的相关程度，给出0到1之间的分数。
+规则：
+1. 1 表示片段可以直接回答问题，0 表示完全无关。
+2. 只根据片段内容判断，不要使用片段之外的知识。
+3. 必须仅返回 JSON 格式数据，格式为 {"scores": [片段1的分数, 片段2的分数, ...]}，数量和片段数量一致。`
+
+// LLMReranker 使用对话模型一次性给所有切片打分
+type LLMReranker struct {
+	chatModel model.BaseChatModel
+}
+
+func NewLLMReranker(chatModel model.BaseChatModel) *LLMReranker {
+	return &LLMReranker{chatModel: chatModel}
+}
+
+func (r *LLMReranker) Rerank(ctx context.Context, query string, docs []*schema.Document) ([]*schema.Document, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "问题：%s\n", query)
+	for i, doc := range docs {
+		fmt.Fprintf(&sb, "\n片段%d：\n%s\n", i+1, doc.Content)
+	}
+	message, err := r.chatModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(llmRerankPrompt),
+		schema.UserMessage(sb.String()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	//防止返回的内容有md的代码块标签
+	rawJSON := strings.TrimSpace(message.Content)
+	rawJSON = strings.TrimPrefix(rawJSON, "```json")
+	rawJSON = strings.TrimPrefix(rawJSON, "```")
+	rawJSON = strings.TrimSuffix(rawJSON, "```")
+	var output struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(rawJSON)), &output); err != nil {
+		return nil, fmt.Errorf("parse rerank result error: %w", err)
+	}
+	if len(output.Scores) != len(docs) {
+		return nil, fmt.Errorf("rerank score count mismatch: %d != %d", len(output.Scores), len(docs))
+	}
+	result := make([]*schema.Document, 0, len(docs))
+	for i, doc := range docs {
+		result = append(result, withNewScore(doc, output.Scores[i]))
+	}
+	sortByScoreStable(result)
+	return result, nil
+}
+
+// withNewScore 复制一份文档并设置新的分数，分数存放在MetaData中，不能直接修改原来的文档
+func withNewScore(doc *schema.Document, score float64) *schema.Document {
+	metadata := make(map[string]any, len(doc.MetaData))
+	for k, v := range doc.MetaData {
+		metadata[k] = v
+	}
+	result := &schema.Document{
+		ID:       doc.ID,
+		Content:  doc.Content,
+		MetaData: metadata,
+	}
+	return result.WithScore(score)
+}
+
+// sortByScoreStable 分数相同时保留检索时的顺序
+func sortByScoreStable(docs []*schema.Document) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Score() > docs[j].Score()
+	})
+}
+
+func uniqueTokens(tokens []string) []string {
+	seen := make(map[string]struct{}, len(tokens))
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if _, ok := seen[token]; ok {
+			continue
+		}
+		seen[token] = struct{}{}
+		result = append(result, token)
+	}
+	return result
+}
@@ -0,0 +1,27 @@
+package kbs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestLexicalRerank(t *testing.T) {
+	docs := []*schema.Document{
+		{ID: "1", Content: "物流查询", MetaData: map[string]any{}},
+		{ID: "2", Content: "错误码 E1024 表示退款失败", MetaData: map[string]any{}},
+		{ID: "3", Content: "退款流程", MetaData: map[string]any{}},
+	}
+	result, err := Rerank(context.Background(), NewLexicalReranker(), "E1024 退款", docs, 0.3)
+	if err != nil {
+		t.Fatalf("Rerank() error = %v", err)
+	}
+	//完全不相关的切片被丢弃
+	if len(result) != 2 || result[0].ID != "2" || result[1].ID != "3" {
+		t.Fatalf("unexpected rerank result: %v", result)
+	}
+	if result[0].Score() != 1 {
+		t.Fatalf("all query tokens hit, got score %v", result[0].Score())
+	}
+}
@@ -0,0 +1,336 @@
+package kbs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/eino/components/document/parser"
+	"github.com/cloudwego/eino/schema"
+)
+
+// 表格解析结果的元数据
+const (
+	MetaKeySheet  = "sheet"
+	MetaKeyRow    = "row"
+	MetaKeyHeader = "header"
+)
+
+// 支持的表格格式
+const (
+	SpreadsheetXLSX = "xlsx"
+	SpreadsheetCSV  = "csv"
+)
+
+var _ parser.Parser = (*SheetParser)(nil)
+
+type SpreadsheetConfig struct {
+	// Format 表格格式 xlsx/csv
+	Format string
+}
+
+// SheetParser 解析 xlsx 和 csv，每个工作表的第一行非空行作为表头
+// 之后的每一行输出一个文档，内容是 "列名: 值" 的形式，元数据中记录工作表名称和行号(从1开始，和表格中显示的一致)
+type SheetParser struct {
+	conf *SpreadsheetConfig
+}
+
+func NewSheetParser(ctx context.Context, conf *SpreadsheetConfig) (*SheetParser, error) {
+	if conf == nil || (conf.Format != SpreadsheetXLSX && conf.Format != SpreadsheetCSV) {
+		return nil, fmt.Errorf("unsupported spreadsheet format")
+	}
+	return &SheetParser{conf: conf}, nil
+}
+
+// sheetRow 表格中的一行，Number 是行号
+type sheetRow struct {
+	Number int
+	Cells  []string
+}
+
+func (p *SheetParser) Parse(ctx context.Context, reader io.Reader, opts ...parser.Option) ([]*schema.Document, error) {
+	option := parser.GetCommonOptions(&parser.Options{}, opts...)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var docs []*schema.Document
+	if p.conf.Format == SpreadsheetCSV {
+		//csv 只有一个表，使用文件名作为工作表名称
+		name := strings.TrimSuffix(filepath.Base(option.URI), filepath.Ext(option.URI))
+		if option.URI == "" {
+			name = "Sheet1"
+		}
+		rows, err := readCSV(data)
+		if err != nil {
+			return nil, err
+		}
+		docs = renderRows(name, rows, option.ExtraMeta)
+	} else {
+		sheets, err := readXLSX(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, sheet := range sheets {
+			docs = append(docs, renderRows(sheet.name, sheet.rows, option.ExtraMeta)...)
+		}
+	}
+	return docs, nil
+}
+
+// renderRows 每一行转换成 "列名: 值" 的文本，空的单元格跳过
+func renderRows(sheet string, rows []sheetRow, extraMeta map[string]any) []*schema.Document {
+	var header []string
+	docs := make([]*schema.Document, 0, len(rows))
+	for _, row := range rows {
+		if isEmptyRow(row.Cells) {
+			continue
+		}
+		if header == nil {
+			header = row.Cells
+			continue
+		}
+		lines := make([]string, 0, len(row.Cells))
+		for i, value := range row.Cells {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			column := ""
+			if i < len(header) {
+				column = strings.TrimSpace(header[i])
+			}
+			if column == "" {
+				//表头为空的列使用列号
+				column = columnName(i)
+			}
+			lines = append(lines, column+": "+value)
+		}
+		metadata := make(map[string]any, len(extraMeta)+3)
+		for k, v := range extraMeta {
+			metadata[k] = v
+		}
+		metadata[MetaKeySheet] = sheet
+		metadata[MetaKeyRow] = row.Number
+		metadata[MetaKeyHeader] = strings.Join(header, ",")
+		docs = append(docs, &schema.Document{
+			Content:  strings.Join(lines, "\n"),
+			MetaData: metadata,
+		})
+	}
+	return docs
+}
+
+func isEmptyRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func readCSV(data []byte) ([]sheetRow, error) {
+	//去掉excel导出的csv带的BOM
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var rows []sheetRow
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		//csv会跳过空行，行号使用记录在文件中的起始行
+		line, _ := r.FieldPos(0)
+		rows = append(rows, sheetRow{Number: line, Cells: record})
+	}
+	return rows, nil
+}
+
+type xlsxSheet struct {
+	name string
+	rows []sheetRow
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText 富文本的单元格由多段 r 组成
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	var sb strings.Builder
+	sb.WriteString(t.T)
+	for _, r := range t.R {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string    `xml:"r,attr"`
+			T  string    `xml:"t,attr"`
+			V  string    `xml:"v"`
+			Is *xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX 直接读取 xlsx 压缩包中的xml，日期等带格式的数字按原始的数值输出
+func readXLSX(data []byte) ([]*xlsxSheet, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		//xls 是二进制格式，不是zip
+		return nil, fmt.Errorf("only xlsx spreadsheets are supported: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	var workbook xlsxWorkbook
+	if err := readXMLFile(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := readXMLFile(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+	var sharedStrings xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readXMLFile(files, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+	sheets := make([]*xlsxSheet, 0, len(workbook.Sheets))
+	for _, s := range workbook.Sheets {
+		var worksheet xlsxWorksheet
+		if err := readXMLFile(files, targets[s.RID], &worksheet); err != nil {
+			return nil, err
+		}
+		sheet := &xlsxSheet{name: s.Name}
+		for i, row := range worksheet.Rows {
+			number := row.R
+			if number == 0 {
+				number = i + 1
+			}
+			var cells []string
+			for j, c := range row.Cells {
+				col := j
+				if c.R != "" {
+					col = columnIndex(c.R)
+				}
+				for len(cells) <= col {
+					cells = append(cells, "")
+				}
+				cells[col] = cellValue(c.T, c.V, c.Is, sharedStrings.Items)
+			}
+			sheet.rows = append(sheet.rows, sheetRow{Number: number, Cells: cells})
+		}
+		sheets = append(sheets, sheet)
+	}
+	return sheets, nil
+}
+
+func cellValue(cellType string, value string, inline *xlsxText, sharedStrings []xlsxText) string {
+	switch cellType {
+	case "s":
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= len(sharedStrings) {
+			return ""
+		}
+		return sharedStrings[i].String()
+	case "inlineStr":
+		if inline == nil {
+			return ""
+		}
+		return inline.String()
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return value
+}
+
+func readXMLFile(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("xlsx part not found: %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// columnIndex 单元格引用 "AB12" 转换成从0开始的列号
+func columnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+// columnName 从0开始的列号转换成 "A"、"AB" 的形式
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
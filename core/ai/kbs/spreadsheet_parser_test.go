@@ -0,0 +1,74 @@
+package kbs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/document/parser"
+)
+
+func TestSheetParserCSV(t *testing.T) {
+	p, err := NewSheetParser(context.Background(), &SpreadsheetConfig{Format: SpreadsheetCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "\xef\xbb\xbf问题,答案\n如何退款,七天内申请\n\n发票,\"联系客服, 提供订单号\"\n"
+	docs, err := p.Parse(context.Background(), strings.NewReader(data), parser.WithURI("/tmp/faq.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %d docs, want 2", len(docs))
+	}
+	if docs[0].Content != "问题: 如何退款\n答案: 七天内申请" {
+		t.Fatalf("content = %q", docs[0].Content)
+	}
+	//空行跳过，行号和文件中的一致
+	if docs[1].MetaData[MetaKeyRow] != 4 || docs[1].MetaData[MetaKeySheet] != "faq" {
+		t.Fatalf("metadata = %v", docs[1].MetaData)
+	}
+}
+
+func TestSheetParserXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="商品" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst><si><t>名称</t></si><si><t>价格</t></si><si><r><t>蓝牙</t></r><r><t>耳机</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3"><v>199</v></c><c r="D3" t="inlineStr"><is><t>现货</t></is></c></row>
+</sheetData></worksheet>`,
+	}
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	p, err := NewSheetParser(context.Background(), &SpreadsheetConfig{Format: SpreadsheetXLSX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := p.Parse(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d docs, want 1", len(docs))
+	}
+	//没有表头的列使用列号
+	if docs[0].Content != "名称: 蓝牙耳机\n价格: 199\nD: 现货" {
+		t.Fatalf("content = %q", docs[0].Content)
+	}
+	if docs[0].MetaData[MetaKeyRow] != 3 || docs[0].MetaData[MetaKeySheet] != "商品" {
+		t.Fatalf("metadata = %v", docs[0].MetaData)
+	}
+}
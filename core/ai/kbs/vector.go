@@ -2,8 +2,13 @@ package kbs
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/cloudwego/eino/components/embedding"
 	"github.com/cloudwego/eino/schema"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"gorm.io/gorm"
 )
 
 type SearchFilter map[string]any
@@ -11,4 +16,139 @@ type SearchFilter map[string]any
 type VectorStore interface {
 	Store(ctx context.Context, docs []*schema.Document) error
 	Search(ctx context.Context, query string, topK int, filters SearchFilter) ([]*schema.Document, error)
+	// Delete 删除某个文档的所有切片
+	Delete(ctx context.Context, docId string) error
+	// DeleteByParent 删除某个父分段下的所有子分段，修改或禁用分段时使用
+	DeleteByParent(ctx context.Context, parentId string) error
+	// DeleteByIds 删除指定id的切片，替换子分段时先写入新的向量再删除旧的
+	DeleteByIds(ctx context.Context, ids []string) error
+	// DropIndex 删除整个索引(集合)，重建索引切换之后删除旧的索引
+	DropIndex(ctx context.Context) error
+}
+
+// 支持的向量存储类型，和知识库上的 StorageType 保持一致
+const (
+	StorageTypeElasticSearch = "es"
+	StorageTypeMilvus        = "milvus"
+	StorageTypePgVector      = "pgvector"
+	StorageTypeLocal         = "local"
+)
+
+// Clients 各个向量存储后端的客户端，由调用方创建，多个知识库共用
+type Clients struct {
+	ES     *elasticsearch.Client
+	Milvus client.Client
+	// Postgres 需要安装 pgvector 扩展
+	Postgres *gorm.DB
+	// LocalDir 本地向量存储的持久化目录，为空时只保存在内存中
+	LocalDir string
+}
+
+// StoreConfig 创建向量存储需要的配置
+type StoreConfig struct {
+	// StorageType 存储类型，为空时使用 es
+	StorageType string
+	// StorageConfig 知识库上保存的存储配置，可以使用的字段见 storageConfigKeys
+	// es 支持 analyzer 指定全文检索的分词器，比如 ik_smart
+	// pgvector 支持 index_type(hnsw/ivfflat)、m、ef_construction、lists、ts_config(全文检索的分词配置)
+	StorageConfig map[string]any
+	// Index 索引(集合)名称，由知识库ID生成，不能来自用户的输入，否则可以读写其他知识库的数据
+	Index string
+	// Dimension 向量的维度，创建索引(集合)时使用，每次向量化都会校验，为0时不校验
+	Dimension int
+	Embedder  embedding.Embedder
+}
+
+// IsSupportedStorageType 判断是否支持该存储类型
+func IsSupportedStorageType(storageType string) bool {
+	switch storageType {
+	case StorageTypeElasticSearch, StorageTypeMilvus, StorageTypePgVector, StorageTypeLocal:
+		return true
+	}
+	return false
+}
+
+// storageConfigKeys 每种存储类型可以配置的字段和取值类型
+var storageConfigKeys = map[string]map[string]string{
+	StorageTypeElasticSearch: {"analyzer": "string"},
+	StorageTypePgVector: {
+		"index_type":      "string",
+		"m":               "number",
+		"ef_construction": "number",
+		"lists":           "number",
+		"ts_config":       "string",
+	},
+}
+
+// ValidStorageConfig 校验用户传入的存储配置，只允许存储类型支持的字段
+func ValidStorageConfig(storageType string, config map[string]any) bool {
+	if storageType == "" {
+		storageType = StorageTypeElasticSearch
+	}
+	keys := storageConfigKeys[storageType]
+	for key, value := range config {
+		switch keys[key] {
+		case "string":
+			if _, ok := value.(string); !ok {
+				return false
+			}
+		case "number":
+			if intValue(value) <= 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewVectorStore 根据知识库的存储类型创建对应的向量存储
+func NewVectorStore(ctx context.Context, clients *Clients, config *StoreConfig) (VectorStore, error) {
+	index := config.Index
+	embedder := withDimensionCheck(config.Embedder, config.Dimension)
+	switch config.StorageType {
+	case "", StorageTypeElasticSearch:
+		if clients.ES == nil {
+			return nil, fmt.Errorf("elasticsearch client not provided")
+		}
+		analyzer, _ := config.StorageConfig["analyzer"].(string)
+		return NewESVectorStore(ctx, clients.ES, index, analyzer, config.Dimension, embedder)
+	case StorageTypeMilvus:
+		if clients.Milvus == nil {
+			return nil, fmt.Errorf("milvus client not provided")
+		}
+		return NewMilvusVectorStore(ctx, clients.Milvus, index, config.Dimension, embedder)
+	case StorageTypePgVector:
+		if clients.Postgres == nil {
+			return nil, fmt.Errorf("postgres client not provided")
+		}
+		indexType, _ := config.StorageConfig["index_type"].(string)
+		textSearchConfig, _ := config.StorageConfig["ts_config"].(string)
+		return NewPgVectorStore(clients.Postgres, index, PgVectorConfig{
+			IndexType:        indexType,
+			M:                intValue(config.StorageConfig["m"]),
+			EfConstruction:   intValue(config.StorageConfig["ef_construction"]),
+			Lists:            intValue(config.StorageConfig["lists"]),
+			TextSearchConfig: textSearchConfig,
+			Dimension:        config.Dimension,
+		}, embedder)
+	case StorageTypeLocal:
+		//持久化目录只能使用服务端的配置，不能由知识库指定
+		return NewLocalVectorStore(clients.LocalDir, index, embedder)
+	}
+	return nil, fmt.Errorf("unsupported storage type: %s", config.StorageType)
+}
+
+// intValue 存储配置是从json解析的，数字可能是float64
+func intValue(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
 }
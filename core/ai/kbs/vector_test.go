@@ -0,0 +1,25 @@
+package kbs
+
+import "testing"
+
+func TestValidStorageConfig(t *testing.T) {
+	tests := []struct {
+		storageType string
+		config      map[string]any
+		want        bool
+	}{
+		{StorageTypeElasticSearch, map[string]any{"analyzer": "ik_smart"}, true},
+		{"", nil, true},
+		{StorageTypePgVector, map[string]any{"index_type": "hnsw", "m": float64(16), "ts_config": "simple"}, true},
+		//索引名称由知识库ID生成，不能自己指定
+		{StorageTypeElasticSearch, map[string]any{"index": "kb_other"}, false},
+		{StorageTypeLocal, map[string]any{"path": "/tmp"}, false},
+		{StorageTypeMilvus, map[string]any{"analyzer": "ik_smart"}, false},
+		{StorageTypePgVector, map[string]any{"lists": "100"}, false},
+	}
+	for _, tt := range tests {
+		if got := ValidStorageConfig(tt.storageType, tt.config); got != tt.want {
+			t.Errorf("ValidStorageConfig(%q, %v) = %v, want %v", tt.storageType, tt.config, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"common/utils"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	DefaultContextWindow = 8192 //模型没有配置上下文窗口时的默认值，取一个比较保守的数
+	DefaultMaxTokens     = 1024 //没有配置最大生成长度时，给回答预留的token数
+	historyRatio         = 0.6  //历史消息最多占用的比例，剩下的留给系统提示词、知识库内容和工具描述
+	keepRatio            = 0.5  //压缩时保留最近消息占历史预算的比例
+	maxToolContentLength = 500  //生成摘要时工具返回内容的最大长度
+)
+
+const summaryPrompt = `你是一个对话摘要助手。请将下面的对话内容压缩成一段简洁的摘要，供后续对话作为上下文使用。
+规则：
+1. 保留用户的目标、偏好、已经确认的事实和结论、尚未解决的问题。
+2. 保留关键的数字、名称、标识等具体信息，不要编造内容。
+3. 如果提供了已有摘要，将已有摘要和新的对话合并成一份新的摘要。
+4. 只输出摘要内容，不要输出任何解释。`
+
+// Manager 负责让会话历史保持在模型的上下文窗口内
+// 历史过长时，用智能体自己的对话模型把最早的消息压缩成摘要
+type Manager struct {
+	chatModel     model.BaseChatModel
+	contextWindow int
+	maxTokens     int
+}
+
+func NewManager(chatModel model.BaseChatModel, contextWindow int, maxTokens int) *Manager {
+	if contextWindow <= 0 {
+		contextWindow = DefaultContextWindow
+	}
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxTokens
+	}
+	//最大生成长度配置得比上下文窗口还大时，按默认值预留
+	if maxTokens >= contextWindow {
+		maxTokens = min(DefaultMaxTokens, contextWindow/2)
+	}
+	return &Manager{
+		chatModel:     chatModel,
+		contextWindow: contextWindow,
+		maxTokens:     maxTokens,
+	}
+}
+
+// InputBudget 发送给模型的输入最多可以使用的token数
+func (m *Manager) InputBudget() int {
+	return m.contextWindow - m.maxTokens
+}
+
+// HistoryBudget 历史消息(包括摘要)最多可以使用的token数
+func (m *Manager) HistoryBudget() int {
+	return int(float64(m.InputBudget()) * historyRatio)
+}
+
+// CompactResult 压缩的结果
+type CompactResult struct {
+	// Summary 新的摘要，没有压缩时就是原来的摘要
+	Summary string
+	// Messages 压缩后保留的最近消息
+	Messages []*schema.Message
+	// Compacted 被压缩进摘要的消息数量，是原消息列表的前Compacted条
+	Compacted int
+}
+
+// Compact 历史消息超过预算时，把最早的一部分消息和已有摘要合并成新的摘要
+func (m *Manager) Compact(ctx context.Context, summary string, messages []*schema.Message) (*CompactResult, error) {
+	result := &CompactResult{
+		Summary:  summary,
+		Messages: messages,
+	}
+	budget := m.HistoryBudget()
+	if utils.GetTokenCount(summary)+CountTokens(messages) <= budget {
+		return result, nil
+	}
+	//从后往前保留最近的消息
+	keepBudget := int(float64(budget) * keepRatio)
+	start := len(messages)
+	used := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		used += CountTokens(messages[i : i+1])
+		if used > keepBudget {
+			break
+		}
+		start = i
+	}
+	//保留的消息不能从工具结果开始，否则工具调用和结果就被拆开了
+	for start < len(messages) && messages[start].Role == schema.Tool {
+		start++
+	}
+	if start == 0 {
+		return result, nil
+	}
+	newSummary, err := m.summarize(ctx, summary, messages[:start])
+	if err != nil {
+		return nil, err
+	}
+	result.Summary = newSummary
+	result.Messages = messages[start:]
+	result.Compacted = start
+	return result, nil
+}
+
+func (m *Manager) summarize(ctx context.Context, summary string, messages []*schema.Message) (string, error) {
+	var builder strings.Builder
+	if summary != "" {
+		builder.WriteString("【已有摘要】\n")
+		builder.WriteString(summary)
+		builder.WriteString("\n\n")
+	}
+	builder.WriteString("【对话内容】\n")
+	for _, msg := range messages {
+		switch msg.Role {
+		case schema.User:
+			builder.WriteString(fmt.Sprintf("用户: %s\n", msg.Content))
+		case schema.Assistant:
+			if msg.Content != "" {
+				builder.WriteString(fmt.Sprintf("助手: %s\n", msg.Content))
+			}
+			for _, tc := range msg.ToolCalls {
+				builder.WriteString(fmt.Sprintf("助手调用工具 %s: %s\n", tc.Function.Name, tc.Function.Arguments))
+			}
+		case schema.Tool:
+			content := []rune(msg.Content)
+			if len(content) > maxToolContentLength {
+				content = append(content[:maxToolContentLength], []rune("...")...)
+			}
+			builder.WriteString(fmt.Sprintf("工具 %s 返回: %s\n", msg.ToolName, string(content)))
+		}
+	}
+	output, err := m.chatModel.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(summaryPrompt),
+		schema.UserMessage(builder.String()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output.Content), nil
+}
+
+// Trim 不调用模型，直接丢弃最早的消息直到满足预算，作为压缩失败时的兜底
+// 开头的系统消息(系统提示词和摘要)会一直保留
+func Trim(messages []*schema.Message, budget int) []*schema.Message {
+	if CountTokens(messages) <= budget {
+		return messages
+	}
+	head := 0
+	for head < len(messages) && messages[head].Role == schema.System {
+		head++
+	}
+	used := CountTokens(messages[:head])
+	start := len(messages)
+	for i := len(messages) - 1; i >= head; i-- {
+		used += CountTokens(messages[i : i+1])
+		//最后一条消息(用户本次的问题)无论如何都要保留
+		if used > budget && i < len(messages)-1 {
+			break
+		}
+		start = i
+	}
+	for start < len(messages)-1 && messages[start].Role == schema.Tool {
+		start++
+	}
+	trimmed := make([]*schema.Message, 0, head+len(messages)-start)
+	trimmed = append(trimmed, messages[:head]...)
+	return append(trimmed, messages[start:]...)
+}
+
+// CountTokens 估算消息列表的token数
+func CountTokens(messages []*schema.Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += utils.GetTokenCount(msg.Content)
+		for _, tc := range msg.ToolCalls {
+			total += utils.GetTokenCount(tc.Function.Name) + utils.GetTokenCount(tc.Function.Arguments)
+		}
+		//每条消息的角色等格式信息大约占用4个token
+		total += 4
+	}
+	return total
+}
+
+// SummaryMessage 将摘要包装成系统消息，放在历史消息的最前面
+func SummaryMessage(summary string) *schema.Message {
+	return schema.SystemMessage("【之前的对话摘要】\n" + summary)
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+type fakeChatModel struct {
+	calls int
+}
+
+func (f *fakeChatModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	f.calls++
+	return schema.AssistantMessage("摘要", nil), nil
+}
+
+func (f *fakeChatModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	return nil, nil
+}
+
+func buildHistory() []*schema.Message {
+	long := strings.Repeat("知识库检索增强生成", 40)
+	return []*schema.Message{
+		schema.UserMessage(long),
+		schema.AssistantMessage("", []schema.ToolCall{{ID: "call_1", Function: schema.FunctionCall{Name: "weather", Arguments: "{}"}}}),
+		schema.ToolMessage(long, "call_1", schema.WithToolName("weather")),
+		schema.AssistantMessage(long, nil),
+		schema.UserMessage(long),
+		schema.AssistantMessage(long, nil),
+		schema.UserMessage("第二个呢？"),
+	}
+}
+
+func TestCompact(t *testing.T) {
+	history := buildHistory()
+	fake := &fakeChatModel{}
+	//预算只够放下一部分历史
+	window := CountTokens(history) + DefaultMaxTokens
+	manager := NewManager(fake, window, DefaultMaxTokens)
+	result, err := manager.Compact(context.Background(), "", history)
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if fake.calls != 1 || result.Summary != "摘要" {
+		t.Fatalf("expected one summarize call, got calls=%d summary=%q", fake.calls, result.Summary)
+	}
+	if result.Compacted == 0 || result.Compacted+len(result.Messages) != len(history) {
+		t.Fatalf("unexpected split: compacted=%d kept=%d", result.Compacted, len(result.Messages))
+	}
+	if result.Messages[0].Role == schema.Tool {
+		t.Fatalf("kept messages must not start with a tool result")
+	}
+
+	//预算足够时不压缩
+	manager = NewManager(fake, window*4, DefaultMaxTokens)
+	result, err = manager.Compact(context.Background(), "", history)
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if result.Compacted != 0 || len(result.Messages) != len(history) || fake.calls != 1 {
+		t.Fatalf("expected no compaction, got compacted=%d", result.Compacted)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	history := buildHistory()
+	messages := append([]*schema.Message{schema.SystemMessage("系统提示词"), SummaryMessage("摘要")}, history...)
+	budget := CountTokens(messages[:2]) + CountTokens(history[len(history)-3:])
+	trimmed := Trim(messages, budget)
+	if CountTokens(trimmed) > budget {
+		t.Fatalf("trimmed messages exceed budget")
+	}
+	if trimmed[0].Role != schema.System || trimmed[1].Role != schema.System {
+		t.Fatalf("leading system messages must be kept")
+	}
+	if trimmed[len(trimmed)-1] != history[len(history)-1] {
+		t.Fatalf("latest user message must be kept")
+	}
+	if trimmed[2].Role == schema.Tool {
+		t.Fatalf("trimmed history must not start with a tool result")
+	}
+}
@@ -10,6 +10,7 @@ type AgentMessage struct {
 	IsErr            bool   `json:"isErr"`
 	Content          string `json:"content"`
 	ReasoningContent string `json:"reasoningContent"`
+	SessionId        string `json:"sessionId,omitempty"`
 }
 
 func BuildErrMessage(agentName string, errMsg string) string {
@@ -44,3 +45,101 @@ func BuildMessage(name string, toolName string, content string) string {
 	bytes, _ := json.Marshal(msg)
 	return string(bytes)
 }
+
+// BuildConversationMessage 告诉前端本次对话所属的会话ID，新建会话时前端需要记录下来，后续对话带上
+func BuildConversationMessage(name string, sessionId string) string {
+	msg := AgentMessage{
+		Action:    "conversation",
+		AgentName: name,
+		SessionId: sessionId,
+	}
+	bytes, _ := json.Marshal(msg)
+	return string(bytes)
+}
+
+// WorkflowMessage 工作流运行时返回客户端的消息，每个节点开始和结束时都会发送一次
+type WorkflowMessage struct {
+	Action    string `json:"action"`
+	RunId     string `json:"runId,omitempty"`
+	NodeId    string `json:"nodeId,omitempty"`
+	NodeType  string `json:"nodeType,omitempty"`
+	NodeName  string `json:"nodeName,omitempty"`
+	Status    string `json:"status"`
+	Output    any    `json:"output,omitempty"`
+	IsErr     bool   `json:"isErr"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMs int64  `json:"elapsedMs"`
+}
+
+func BuildWorkflowNodeMessage(nodeId string, nodeType string, nodeName string, status string, output any, errMsg string, elapsedMs int64) string {
+	msg := WorkflowMessage{
+		Action:    "workflow_node", //前端根据这个action更新画布上节点的状态
+		NodeId:    nodeId,
+		NodeType:  nodeType,
+		NodeName:  nodeName,
+		Status:    status,
+		Output:    output,
+		IsErr:     errMsg != "",
+		Error:     errMsg,
+		ElapsedMs: elapsedMs,
+	}
+	bytes, _ := json.Marshal(msg)
+	return string(bytes)
+}
+
+// BuildWorkflowRunMessage 工作流开始运行，告诉前端本次运行的ID，用于查看运行记录和重新运行
+func BuildWorkflowRunMessage(runId string) string {
+	msg := WorkflowMessage{
+		Action: "workflow_run",
+		RunId:  runId,
+		Status: "running",
+	}
+	bytes, _ := json.Marshal(msg)
+	return string(bytes)
+}
+
+// BuildWorkflowResultMessage 工作流运行结束，返回结束节点的输出
+func BuildWorkflowResultMessage(runId string, status string, output any, errMsg string, elapsedMs int64) string {
+	msg := WorkflowMessage{
+		Action:    "workflow_result",
+		RunId:     runId,
+		Status:    status,
+		Output:    output,
+		IsErr:     errMsg != "",
+		Error:     errMsg,
+		ElapsedMs: elapsedMs,
+	}
+	bytes, _ := json.Marshal(msg)
+	return string(bytes)
+}
+
+// Citation 回答引用的知识库来源，Index 和提示词中的来源编号一致
+type Citation struct {
+	Index           int            `json:"index"`
+	KnowledgeBaseId string         `json:"knowledgeBaseId"`
+	DocumentId      string         `json:"documentId"`
+	DocumentName    string         `json:"documentName"`
+	ChunkId         string         `json:"chunkId"`
+	Content         string         `json:"content"`
+	Score           float64        `json:"score"`
+	Metadata        map[string]any `json:"metadata,omitempty"`
+}
+
+// CitationMessage 知识库检索完成后发送给前端，回答中的 [编号] 可以链接到对应的文档和分段
+type CitationMessage struct {
+	Action    string      `json:"action"`
+	AgentName string      `json:"agentName"`
+	Query     string      `json:"query"` //实际检索的问题，追问时是改写之后的问题
+	Citations []*Citation `json:"citations"`
+}
+
+func BuildCitationsMessage(agentName string, query string, citations []*Citation) string {
+	msg := CitationMessage{
+		Action:    "citations",
+		AgentName: agentName,
+		Query:     query,
+		Citations: citations,
+	}
+	bytes, _ := json.Marshal(msg)
+	return string(bytes)
+}
@@ -12,6 +12,9 @@ require (
 	github.com/cloudwego/eino v0.6.0 // indirect
 	github.com/cloudwego/eino-ext/components/indexer/es8 v0.0.0-20251114102822-95f6d97bd4ee // indirect
 	github.com/cloudwego/eino-ext/components/indexer/milvus v0.0.0-20260114111548-9f93a1348a18 // indirect
+	github.com/cloudwego/eino-ext/components/model/ollama v0.1.6 // indirect
+	github.com/cloudwego/eino-ext/components/model/openai v0.1.5 // indirect
+	github.com/cloudwego/eino-ext/components/model/qwen v0.1.2 // indirect
 	github.com/cloudwego/eino-ext/components/retriever/es8 v0.0.0-20251114102822-95f6d97bd4ee // indirect
 	github.com/cloudwego/eino-ext/components/retriever/milvus v0.0.0-20260114111548-9f93a1348a18 // indirect
 	github.com/cockroachdb/errors v1.9.1 // indirect
@@ -19,6 +22,7 @@ require (
 	github.com/cockroachdb/redact v1.1.3 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/eino-contrib/jsonschema v1.0.2 // indirect
+	github.com/eino-contrib/ollama v0.1.0 // indirect
 	github.com/elastic/elastic-transport-go/v8 v8.6.0 // indirect
 	github.com/elastic/go-elasticsearch/v8 v8.16.0 // indirect
 	github.com/getsentry/sentry-go v0.12.0 // indirect
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewLocalStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "documents/ab/abc.txt"
+	if err := s.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	exist, err := s.Exists(ctx, key)
+	if err != nil || !exist {
+		t.Fatalf("exists = %v, %v", exist, err)
+	}
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "hello" {
+		t.Fatalf("content = %q", data)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete error = %v", err)
+	}
+	//删除不存在的对象不报错
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalStorageKeyStaysInRoot(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewLocalStorage(filepath.Join(root, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "../escape.txt", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file written outside root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "data", "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
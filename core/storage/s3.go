@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// S3Config S3 兼容存储的配置，MinIO 使用 path-style 的地址
+type S3Config struct {
+	// Endpoint 服务地址，比如 http://localhost:9000
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix 所有对象key的前缀
+	Prefix string
+}
+
+// unsignedPayload 不对请求体计算签名，上传时不需要先把文件读一遍
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Storage 直接调用 S3 的 REST 接口，使用 SigV4 签名
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+func NewS3Storage(config *S3Config) (*S3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("storage: s3 endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	cfg := *config
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3Storage{
+		config:   cfg,
+		endpoint: endpoint,
+		signer:   v4.NewSigner(),
+		client:   &http.Client{},
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, reader)
+	if err != nil {
+		return err
+	}
+	//S3 不支持 chunked 上传，长度未知时读到内存里
+	if size < 0 {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		size = int64(len(data))
+		req.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (s *S3Storage) newRequest(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	if key == "" {
+		return nil, errors.New("storage: key is empty")
+	}
+	u := *s.endpoint
+	u.Path = "/" + s.config.Bucket + "/" + strings.TrimPrefix(s.config.Prefix+key, "/")
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// do 签名并发送请求，404 转换成 ErrNotFound，其他非 2xx 的响应返回错误
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	credentials := aws.Credentials{
+		AccessKeyID:     s.config.AccessKeyID,
+		SecretAccessKey: s.config.SecretAccessKey,
+	}
+	err := s.signer.SignHTTP(req.Context(), credentials, req, unsignedPayload, "s3", s.config.Region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("storage: s3 %s %s failed: %s %s", req.Method, req.URL.Path, resp.Status, string(message))
+	}
+	return resp, nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage 保存原始文件的对象存储
+type Storage interface {
+	// Put 写入对象，size 为 -1 时表示长度未知
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Get 读取对象，对象不存在时返回 ErrNotFound，使用完需要关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// 支持的存储驱动
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// Config 对象存储的配置
+type Config struct {
+	// Driver 存储驱动 local/s3，默认 local
+	Driver string
+	// LocalDir 本地存储的根目录
+	LocalDir string
+	S3       S3Config
+}
+
+// New 根据配置创建对象存储
+func New(config *Config) (Storage, error) {
+	switch config.Driver {
+	case "", DriverLocal:
+		return NewLocalStorage(config.LocalDir)
+	case DriverS3:
+		return NewS3Storage(&config.S3)
+	}
+	return nil, errors.New("storage: unsupported driver " + config.Driver)
+}
@@ -58,6 +58,8 @@ type Agent struct {
 
 	Tools          []*Tool          `json:"tools" gorm:"many2many:agent_tools"`
 	KnowledgeBases []*KnowledgeBase `json:"knowledgeBases" gorm:"many2many:agent_knowledge_bases"`
+	// SubAgents 协作的子智能体，对话时注册到supervisor中
+	SubAgents []*Agent `json:"subAgents" gorm:"many2many:agent_sub_agents;joinForeignKey:AgentID;joinReferences:SubAgentID"`
 }
 
 // TableName 返回表名
@@ -96,6 +98,10 @@ type ModelsParams struct {
 	// 基于 Token "出现的频次" 进行惩罚。
 	// 值越大，模型越排斥逐字逐句地重复之前生成过的文本（减少复读机现象）。
 	FrequencyPenalty float64 `json:"frequencyPenalty"`
+	// ContextWindow (上下文窗口)
+	// 模型能接收的最大 Token 数（输入 + 输出）。
+	// 会话历史会根据它和 MaxTokens 计算预算，超出时对较早的对话做摘要压缩。
+	ContextWindow int `json:"contextWindow"`
 }
 
 func (j JSON) ToModelParams() ModelsParams {
@@ -129,6 +135,10 @@ func (j JSON) ToModelParams() ModelsParams {
 		params.FrequencyPenalty = frequencyPenalty
 	}
 
+	if contextWindow, ok := j["contextWindow"].(float64); ok {
+		params.ContextWindow = int(contextWindow)
+	}
+
 	return params
 }
 
@@ -193,3 +203,17 @@ type AgentKnowledgeBase struct {
 func (AgentKnowledgeBase) TableName() string {
 	return "agent_knowledge_bases"
 }
+
+// AgentSubAgent 定义了智能体与子智能体的多对多关联
+type AgentSubAgent struct {
+	// 复合主键：AgentID + SubAgentID
+	AgentID    uuid.UUID `json:"agentId" gorm:"type:uuid;primaryKey"`
+	SubAgentID uuid.UUID `json:"subAgentId" gorm:"type:uuid;primaryKey;index"`
+	Status     string    `json:"status" gorm:"size:50;default:'active'"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TableName 返回表名
+func (AgentSubAgent) TableName() string {
+	return "agent_sub_agents"
+}
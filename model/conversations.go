@@ -0,0 +1,95 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/google/uuid"
+)
+
+// Conversation 定义了用户与智能体之间的一次会话
+type Conversation struct {
+	BaseModel
+	// AgentID 会话所属的智能体
+	AgentID uuid.UUID `json:"agentId" gorm:"column:agent_id;type:uuid;not null;index"`
+	// CreatorID 会话的创建者
+	CreatorID uuid.UUID `json:"creatorId" gorm:"column:creator_id;type:uuid;not null;index"`
+	// Title 会话标题，默认取第一条用户消息
+	Title string `json:"title" gorm:"column:title;type:varchar(255);not null"`
+	// MessageCount 消息数量统计
+	MessageCount int `json:"messageCount" gorm:"column:message_count;type:integer;not null;default:0"`
+	// LastMessageAt 最后一条消息的时间，用于会话列表排序
+	LastMessageAt *time.Time `json:"lastMessageAt" gorm:"column:last_message_at;type:timestamptz;index"`
+	// Summary 较早对话的摘要，历史消息超过token预算时滚动生成
+	Summary string `json:"summary" gorm:"column:summary;type:text"`
+	// SummarizedAt 已经压缩进摘要的最后一条消息的时间，之后的消息才会作为原文加载
+	SummarizedAt *time.Time `json:"summarizedAt" gorm:"column:summarized_at;type:timestamptz"`
+
+	Messages []*ConversationMessage `json:"messages,omitempty" gorm:"foreignKey:ConversationID"`
+}
+
+// TableName 返回表名
+func (*Conversation) TableName() string {
+	return "conversations"
+}
+
+// ConversationMessage 存储会话中的每一条消息，包括用户消息、模型回答以及工具调用
+type ConversationMessage struct {
+	BaseModel
+	ConversationID uuid.UUID `json:"conversationId" gorm:"column:conversation_id;type:uuid;not null;index"`
+	// Role 消息角色: user assistant tool
+	Role schema.RoleType `json:"role" gorm:"column:role;type:varchar(20);not null"`
+	// AgentName 产生这条消息的智能体名称，多智能体协作时用于区分
+	AgentName string `json:"agentName" gorm:"column:agent_name;type:varchar(255)"`
+	Content   string `json:"content" gorm:"column:content;type:text"`
+	// ReasoningContent 模型的思考内容
+	ReasoningContent string `json:"reasoningContent" gorm:"column:reasoning_content;type:text"`
+	// ToolCalls 模型发起的工具调用，只有assistant消息才有
+	ToolCalls ToolCalls `json:"toolCalls" gorm:"column:tool_calls;type:jsonb"`
+	// ToolCallID ToolName 工具调用的结果，只有tool消息才有
+	ToolCallID string `json:"toolCallId" gorm:"column:tool_call_id;type:varchar(255)"`
+	ToolName   string `json:"toolName" gorm:"column:tool_name;type:varchar(255)"`
+	TokenCount int    `json:"tokenCount" gorm:"column:token_count;type:integer;default:0"`
+}
+
+// TableName 返回表名
+func (*ConversationMessage) TableName() string {
+	return "conversation_messages"
+}
+
+// ToSchemaMessage 转换为eino的消息，用于拼接历史上下文
+func (m *ConversationMessage) ToSchemaMessage() *schema.Message {
+	return &schema.Message{
+		Role:       m.Role,
+		Content:    m.Content,
+		ToolCalls:  m.ToolCalls,
+		ToolCallID: m.ToolCallID,
+		ToolName:   m.ToolName,
+	}
+}
+
+type ToolCalls []schema.ToolCall
+
+// Value 写入 PG 时调用
+func (t ToolCalls) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(t)
+}
+
+// Scan 从 PG 读取时调用
+func (t *ToolCalls) Scan(value interface{}) error {
+	if value == nil {
+		*t = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to scan ToolCalls")
+	}
+	return json.Unmarshal(bytes, t)
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type IngestionJobStatus string
+
+const (
+	IngestionJobPending   IngestionJobStatus = "pending"
+	IngestionJobRunning   IngestionJobStatus = "running"
+	IngestionJobSucceeded IngestionJobStatus = "succeeded"
+	IngestionJobFailed    IngestionJobStatus = "failed"
+)
+
+// IngestionJob 文档解析入库的任务，由后台的worker从表中领取执行
+type IngestionJob struct {
+	BaseModel
+	DocumentID      uuid.UUID          `json:"documentId" gorm:"column:document_id;type:uuid;not null;index"`
+	KnowledgeBaseID uuid.UUID          `json:"knowledgeBaseId" gorm:"column:kb_id;type:uuid;not null;index"`
+	Status          IngestionJobStatus `json:"status" gorm:"column:status;type:varchar(20);not null;default:'pending';index"`
+	// Attempts 已经执行的次数，MaxAttempts 最多执行的次数
+	Attempts    int `json:"attempts" gorm:"column:attempts;type:integer;not null;default:0"`
+	MaxAttempts int `json:"maxAttempts" gorm:"column:max_attempts;type:integer;not null;default:3"`
+	// NextRunAt 失败重试时按指数退避设置下一次执行的时间
+	NextRunAt time.Time `json:"nextRunAt" gorm:"column:next_run_at;type:timestamptz;not null;index"`
+	// LockedAt 执行中会定时刷新，长时间没有刷新说明worker已经退出，任务会被重新领取
+	LockedAt *time.Time `json:"lockedAt" gorm:"column:locked_at;type:timestamptz"`
+	Error    string     `json:"error" gorm:"column:error;type:text"`
+}
+
+// TableName 返回表名
+func (IngestionJob) TableName() string {
+	return "ingestion_jobs"
+}
@@ -38,6 +38,8 @@ type StorageType string
 const (
 	StorageTypeElasticSearch StorageType = "es"
 	StorageTypeMilvus        StorageType = "milvus"
+	StorageTypePgVector      StorageType = "pgvector"
+	StorageTypeLocal         StorageType = "local"
 )
 
 type KnowledgeBase struct {
@@ -55,11 +57,111 @@ type KnowledgeBase struct {
 	DocumentCount          uint                `json:"documentCount" gorm:"column:document_count;type:integer;not null;default:0"`
 	Tags                   StringArrayJSON     `json:"tags" gorm:"column:tags;type:jsonb"`
 	Status                 KnowledgeBaseStatus `json:"status" gorm:"column:status;type:varchar(20);not null;default:'active'"`
+	// RetrievalConfig 检索配置
+	RetrievalConfig RetrievalConfig `json:"retrievalConfig" gorm:"column:retrieval_config;type:jsonb"`
+	// VectorIndex 当前使用的向量索引(集合)名称，重建索引完成后切换到新的索引，为空时使用默认的索引
+	VectorIndex string `json:"vectorIndex" gorm:"column:vector_index;type:varchar(128)"`
+	// ChunkingConfig 文档切分配置，修改之后只对新上传的文档生效
+	ChunkingConfig ChunkingConfig `json:"chunkingConfig" gorm:"column:chunking_config;type:jsonb"`
 
 	// 关联关系
 	Agents []Agent `json:"agents" gorm:"many2many:agent_knowledge_bases;"`
 }
 
+// RetrievalConfig 知识库的检索配置
+type RetrievalConfig struct {
+	// SearchMode 检索模式 vector/hybrid，默认只用向量检索
+	SearchMode string `json:"searchMode"`
+	// Fusion 混合检索的融合方式 rrf/weighted，默认 rrf
+	Fusion string `json:"fusion"`
+	// RRFK rrf的平滑参数，默认60
+	RRFK int `json:"rrfK"`
+	// VectorWeight 和 KeywordWeight 是向量检索和全文检索结果的权重
+	VectorWeight  float64 `json:"vectorWeight"`
+	KeywordWeight float64 `json:"keywordWeight"`
+	// Reranker 重排序方式 lexical/llm，为空时不重排序，llm 使用知识库的对话模型
+	Reranker string `json:"reranker"`
+	// MinScore 重排序之后低于该分数的切片会被丢弃，分数范围0-1
+	MinScore float64 `json:"minScore"`
+	// IntentExtractor 检索之前提取问题意图的方式 novel/metadata，为空时不提取，也不调用模型
+	IntentExtractor string `json:"intentExtractor"`
+	// QueryMode 检索之前改写问题的方式 multi_query/hyde，为空时只用原始问题检索
+	QueryMode string `json:"queryMode"`
+}
+
+// Value 写入 PG 时调用
+func (c RetrievalConfig) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan 从 PG 读取时调用
+func (c *RetrievalConfig) Scan(value interface{}) error {
+	if value == nil {
+		*c = RetrievalConfig{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to scan RetrievalConfig")
+	}
+	return json.Unmarshal(bytes, c)
+}
+
+// ChunkingConfig 知识库的文档切分配置，没有配置的项使用默认值
+type ChunkingConfig struct {
+	// Strategy 切分策略 fixed/recursive/sentence/heading/token，默认 fixed
+	Strategy string `json:"strategy"`
+	// ParentSize 和 ParentOverlap 是父分段的长度和重叠长度，默认1200/200
+	ParentSize    int `json:"parentSize"`
+	ParentOverlap int `json:"parentOverlap"`
+	// ChildSize 和 ChildOverlap 是子分段(用于向量检索)的长度和重叠长度，默认400/50
+	ChildSize    int `json:"childSize"`
+	ChildOverlap int `json:"childOverlap"`
+	// Unit 长度单位 chars/tokens，默认 chars
+	Unit string `json:"unit"`
+}
+
+// WithDefaults 返回填充了默认值的配置
+func (c ChunkingConfig) WithDefaults() ChunkingConfig {
+	if c.Strategy == "" {
+		c.Strategy = "fixed"
+	}
+	if c.Unit == "" {
+		c.Unit = "chars"
+	}
+	if c.ParentSize <= 0 {
+		c.ParentSize = 1200
+		if c.ParentOverlap == 0 {
+			c.ParentOverlap = 200
+		}
+	}
+	if c.ChildSize <= 0 {
+		c.ChildSize = 400
+		if c.ChildOverlap == 0 {
+			c.ChildOverlap = 50
+		}
+	}
+	return c
+}
+
+// Value 写入 PG 时调用
+func (c ChunkingConfig) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan 从 PG 读取时调用
+func (c *ChunkingConfig) Scan(value interface{}) error {
+	if value == nil {
+		*c = ChunkingConfig{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to scan ChunkingConfig")
+	}
+	return json.Unmarshal(bytes, c)
+}
+
 type KnowledgeBaseStatus string
 
 const (
@@ -94,6 +196,8 @@ type Document struct {
 	MetaInfo JSON `json:"metaInfo" gorm:"column:meta_info;type:jsonb"`
 	// 6. 是否启用
 	Enabled bool `json:"enabled" gorm:"column:enabled;type:boolean;not null;default:true"` // 软开关，关闭后检索不到
+	// ReplacesID 重新上传替换的旧文档，新文档处理完成后才删除旧文档
+	ReplacesID *uuid.UUID `json:"replacesId,omitempty" gorm:"column:replaces_id;type:uuid"`
 	// 关联
 	Chunks []DocumentChunk `json:"chunks,omitempty" gorm:"foreignKey:DocumentID"`
 }
@@ -116,6 +220,8 @@ type DocumentChunk struct {
 	// 1. 关联关系
 	DocumentID      uuid.UUID `json:"documentId" gorm:"column:document_id;type:uuid;not null;index"`
 	KnowledgeBaseID uuid.UUID `json:"knowledgeBaseId" gorm:"column:kb_id;type:uuid;not null;index"` // 冗余字段，为了方便按库查询
+	// ParentID 子分段所属的父分段，父分段为空。子分段保存一份用于重建索引
+	ParentID *uuid.UUID `json:"parentId,omitempty" gorm:"column:parent_id;type:uuid;index"`
 	// 2. 索引同步 (关键字段)
 	// 记录该切片在 ES 中的 ID (_id)，用于后续的更新或删除操作
 	ElasticSearchID string `json:"esId" gorm:"column:es_id;type:varchar(100);index"`
@@ -128,7 +234,7 @@ type DocumentChunk struct {
 	// 极其重要！这里存放 {"page_num": 1, "heading": "第一章", "image_url": "..."}
 	// 这些数据会同步写入 ES 的 metadata 字段，用于 filter
 	MetaInfo JSON        `json:"metaInfo" gorm:"column:meta_info;type:jsonb"`
-	Status   ChunkStatus `gorm:"column:status;type:varchar(20);not null;default:'pending'"`
+	Status   ChunkStatus `json:"status" gorm:"column:status;type:varchar(20);not null;default:'pending'"`
 }
 type ChunkStatus string
 
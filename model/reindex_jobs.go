@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReindexJobStatus string
+
+const (
+	ReindexJobRunning   ReindexJobStatus = "running"
+	ReindexJobSucceeded ReindexJobStatus = "succeeded"
+	ReindexJobFailed    ReindexJobStatus = "failed"
+)
+
+// ReindexJob 知识库重建向量索引的任务
+// 使用新的向量模型把所有子分段写入新的索引，完成之后知识库切换到新的索引并删除旧的索引
+type ReindexJob struct {
+	BaseModel
+	KnowledgeBaseID uuid.UUID `json:"knowledgeBaseId" gorm:"column:kb_id;type:uuid;not null;index"`
+	CreatorID       uuid.UUID `json:"creatorId" gorm:"column:creator_id;type:uuid;not null"`
+	// EmbeddingModelName、EmbeddingModelProvider 和 EmbeddingDimension 是重建使用的向量模型，切换时写回知识库
+	EmbeddingModelName     string `json:"embeddingModelName" gorm:"column:embedding_model_name;type:varchar(255)"`
+	EmbeddingModelProvider string `json:"embeddingModelProvider" gorm:"column:embedding_model_provider;type:varchar(50)"`
+	EmbeddingDimension     int    `json:"embeddingDimension" gorm:"column:embedding_dimension;type:integer;not null;default:0"`
+	// SourceIndex 重建之前使用的索引，TargetIndex 新写入的索引
+	SourceIndex string           `json:"sourceIndex" gorm:"column:source_index;type:varchar(128)"`
+	TargetIndex string           `json:"targetIndex" gorm:"column:target_index;type:varchar(128);not null"`
+	Status      ReindexJobStatus `json:"status" gorm:"column:status;type:varchar(20);not null;default:'running';index"`
+	// TotalChunks 需要重新向量化的子分段数量，ProcessedChunks 已经写入新索引的数量
+	TotalChunks     int `json:"totalChunks" gorm:"column:total_chunks;type:integer;not null;default:0"`
+	ProcessedChunks int `json:"processedChunks" gorm:"column:processed_chunks;type:integer;not null;default:0"`
+	// LockedAt 执行中会定时刷新，长时间没有刷新说明进程已经退出，任务会被重新执行
+	LockedAt   *time.Time `json:"lockedAt" gorm:"column:locked_at;type:timestamptz"`
+	FinishedAt *time.Time `json:"finishedAt" gorm:"column:finished_at;type:timestamptz"`
+	Error      string     `json:"error" gorm:"column:error;type:text"`
+}
+
+// TableName 返回表名
+func (ReindexJob) TableName() string {
+	return "reindex_jobs"
+}
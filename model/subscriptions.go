@@ -15,6 +15,19 @@ type PlanConfig struct {
 	MaxKnowledgeBaseSize int64 `json:"maxKnowledgeBaseSize"`
 }
 
+// freePlanConfig 免费版的额度
+var freePlanConfig = PlanConfig{
+	MaxAgents:            10,
+	MaxWorkflows:         10,
+	MaxKnowledgeBaseSize: 10,
+}
+
+// GetPlanConfig 获取订阅版本的额度，目前只定义了免费版的额度，其他版本也按免费版处理
+func GetPlanConfig(plan SubscriptionPlan) *PlanConfig {
+	config := freePlanConfig
+	return &config
+}
+
 type PaymentDuration string
 
 const (
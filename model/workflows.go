@@ -0,0 +1,160 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WorkflowStatus string
+
+const (
+	WorkflowStatusDraft     WorkflowStatus = "draft"
+	WorkflowStatusPublished WorkflowStatus = "published"
+)
+
+// WorkflowNodeType 工作流节点类型
+type WorkflowNodeType string
+
+const (
+	WorkflowNodeStart     WorkflowNodeType = "start"     // 开始节点，接收运行时的输入
+	WorkflowNodeLLM       WorkflowNodeType = "llm"       // 大模型调用
+	WorkflowNodeTool      WorkflowNodeType = "tool"      // 工具调用
+	WorkflowNodeKnowledge WorkflowNodeType = "knowledge" // 知识库检索
+	WorkflowNodeCondition WorkflowNodeType = "condition" // 条件分支
+	WorkflowNodeVariable  WorkflowNodeType = "variable"  // 变量赋值
+	WorkflowNodeAgent     WorkflowNodeType = "agent"     // 调用智能体
+	WorkflowNodeHttp      WorkflowNodeType = "http"      // HTTP请求
+	WorkflowNodeEnd       WorkflowNodeType = "end"       // 结束节点，输出工作流的结果
+)
+
+// Workflow 定义了可视化编排的工作流
+type Workflow struct {
+	BaseModel
+	// CreatorID 创建者ID
+	CreatorID uuid.UUID `json:"creatorId" gorm:"column:creator_id;type:uuid;not null;index"`
+	// Name 工作流名称
+	Name string `json:"name" gorm:"column:name;type:varchar(255);not null"`
+	// Description 描述信息
+	Description string `json:"description" gorm:"column:description;type:text"`
+	// Icon 图标URL或路径
+	Icon string `json:"icon" gorm:"column:icon;type:varchar(512)"`
+	// Status 状态（草稿、发布）
+	Status WorkflowStatus `json:"status" gorm:"column:status;type:varchar(20);not null;default:'draft'"`
+	// Definition 节点和连线，前端画布保存的内容
+	Definition WorkflowDefinition `json:"definition" gorm:"column:definition;type:jsonb"`
+	// Version 版本号，每次修改定义加1
+	Version uint `json:"version" gorm:"column:version;type:int;not null;default:1"`
+}
+
+// TableName 返回表名
+func (Workflow) TableName() string {
+	return "workflows"
+}
+
+// WorkflowDefinition 工作流的图定义
+type WorkflowDefinition struct {
+	Nodes []*WorkflowNode `json:"nodes"`
+	Edges []*WorkflowEdge `json:"edges"`
+}
+
+// WorkflowNode 工作流中的一个节点
+// 节点的配置根据类型不同而不同，在配置中可以用 {{节点ID.字段}} 引用前面节点的输出
+type WorkflowNode struct {
+	ID     string           `json:"id"`
+	Type   WorkflowNodeType `json:"type"`
+	Name   string           `json:"name"`
+	Config JSON             `json:"config"`
+	// Position 画布上的位置，只有前端使用
+	Position JSON `json:"position,omitempty"`
+}
+
+// WorkflowEdge 节点之间的连线
+type WorkflowEdge struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// SourceHandle 条件节点的出口，对应条件的ID，没有命中任何条件时走 else
+	SourceHandle string `json:"sourceHandle,omitempty"`
+}
+
+// Value 写入 PG 时调用
+func (d WorkflowDefinition) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+// Scan 从 PG 读取时调用
+func (d *WorkflowDefinition) Scan(value interface{}) error {
+	if value == nil {
+		*d = WorkflowDefinition{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("failed to scan WorkflowDefinition")
+	}
+	return json.Unmarshal(bytes, d)
+}
+
+type WorkflowRunStatus string
+
+const (
+	WorkflowRunRunning   WorkflowRunStatus = "running"
+	WorkflowRunSucceeded WorkflowRunStatus = "succeeded"
+	WorkflowRunFailed    WorkflowRunStatus = "failed"
+	// WorkflowRunCached 重新运行时直接复用上一次运行的输出，没有真正执行
+	WorkflowRunCached WorkflowRunStatus = "cached"
+)
+
+// WorkflowRun 工作流的一次运行记录
+type WorkflowRun struct {
+	BaseModel
+	WorkflowID uuid.UUID `json:"workflowId" gorm:"column:workflow_id;type:uuid;not null;index"`
+	CreatorID  uuid.UUID `json:"creatorId" gorm:"column:creator_id;type:uuid;not null;index"`
+	// WorkflowVersion 运行时工作流的版本
+	WorkflowVersion uint `json:"workflowVersion" gorm:"column:workflow_version;type:int;not null;default:1"`
+	// Definition 运行时的工作流定义快照，重新运行时使用同样的定义
+	Definition WorkflowDefinition `json:"definition,omitempty" gorm:"column:definition;type:jsonb"`
+	Status     WorkflowRunStatus  `json:"status" gorm:"column:status;type:varchar(20);not null;index"`
+	Inputs     JSON               `json:"inputs" gorm:"column:inputs;type:jsonb"`
+	Outputs    JSON               `json:"outputs" gorm:"column:outputs;type:jsonb"`
+	Error      string             `json:"error" gorm:"column:error;type:text"`
+	// TotalTokens 所有节点消耗的token数
+	TotalTokens int        `json:"totalTokens" gorm:"column:total_tokens;type:integer;not null;default:0"`
+	ElapsedMs   int64      `json:"elapsedMs" gorm:"column:elapsed_ms;type:bigint;not null;default:0"`
+	FinishedAt  *time.Time `json:"finishedAt" gorm:"column:finished_at;type:timestamptz"`
+	// ReplayOf 从哪一次运行的失败节点重新运行的
+	ReplayOf *uuid.UUID `json:"replayOf" gorm:"column:replay_of;type:uuid"`
+
+	NodeRuns []*NodeRun `json:"nodeRuns,omitempty" gorm:"foreignKey:RunID"`
+}
+
+// TableName 返回表名
+func (WorkflowRun) TableName() string {
+	return "workflow_runs"
+}
+
+// NodeRun 工作流运行时每个节点的执行记录
+type NodeRun struct {
+	BaseModel
+	RunID    uuid.UUID         `json:"runId" gorm:"column:run_id;type:uuid;not null;index"`
+	NodeID   string            `json:"nodeId" gorm:"column:node_id;type:varchar(255);not null"`
+	NodeType WorkflowNodeType  `json:"nodeType" gorm:"column:node_type;type:varchar(20);not null"`
+	NodeName string            `json:"nodeName" gorm:"column:node_name;type:varchar(255)"`
+	Status   WorkflowRunStatus `json:"status" gorm:"column:status;type:varchar(20);not null"`
+	// Input 渲染变量之后的节点配置
+	Input JSON `json:"input" gorm:"column:input;type:jsonb"`
+	// Output 节点的输出，后面的节点通过 {{节点ID.字段}} 引用
+	Output    JSON   `json:"output" gorm:"column:output;type:jsonb"`
+	Error     string `json:"error" gorm:"column:error;type:text"`
+	Tokens    int    `json:"tokens" gorm:"column:tokens;type:integer;not null;default:0"`
+	ElapsedMs int64  `json:"elapsedMs" gorm:"column:elapsed_ms;type:bigint;not null;default:0"`
+}
+
+// TableName 返回表名
+func (NodeRun) TableName() string {
+	return "workflow_node_runs"
+}